@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 
+	"github.com/natac13/go-crypto-exchange/orderbook"
 	"github.com/natac13/go-crypto-exchange/server"
 )
 
@@ -22,16 +25,81 @@ func NewClient() *Client {
 }
 
 type PlaceLimitOrderParams struct {
-	UserID int64   `json:"userId"`
-	Bid    bool    `json:"bid"`
-	Price  float64 `json:"price"`
-	Size   float64 `json:"size"`
+	UserID int64             `json:"userId"`
+	Bid    bool              `json:"bid"`
+	Price  orderbook.Decimal `json:"price"`
+	Size   orderbook.Decimal `json:"size"`
+	// TimeInForce defaults to GTC when empty
+	TimeInForce orderbook.TimeInForce `json:"timeInForce,omitempty"`
+	// ExpiresAt is required for GTD orders, in unix nanoseconds
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+	// PostOnly orders are rejected or, with PostOnlySlide, repriced instead
+	// of taking liquidity
+	PostOnly     bool                   `json:"postOnly,omitempty"`
+	PostOnlyMode orderbook.PostOnlyMode `json:"postOnlyMode,omitempty"`
+	// DisplaySize makes the order an iceberg that only shows this much at
+	// a time
+	DisplaySize orderbook.Decimal `json:"displaySize"`
+	// Hidden keeps the order out of the public book
+	Hidden bool `json:"hidden,omitempty"`
+	// MinQty is the smallest fill the order takes, AllOrNone only lets it
+	// fill completely at once
+	MinQty    orderbook.Decimal `json:"minQty"`
+	AllOrNone bool              `json:"allOrNone,omitempty"`
+}
+
+// PlacePeggedOrderParams places an order that follows Peg, the best bid, the
+// best ask or the midpoint, at PegOffset from it.
+type PlacePeggedOrderParams struct {
+	UserID    int64                  `json:"userId"`
+	Bid       bool                   `json:"bid"`
+	Size      orderbook.Decimal      `json:"size"`
+	Peg       orderbook.PegReference `json:"peg"`
+	PegOffset orderbook.Decimal      `json:"pegOffset"`
+	Hidden    bool                   `json:"hidden,omitempty"`
+	MinQty    orderbook.Decimal      `json:"minQty"`
+	AllOrNone bool                   `json:"allOrNone,omitempty"`
 }
 
 type PlaceMarketOrderParams struct {
-	UserID int64   `json:"userId"`
-	Bid    bool    `json:"bid"`
-	Size   float64 `json:"size"`
+	UserID int64             `json:"userId"`
+	Bid    bool              `json:"bid"`
+	Size   orderbook.Decimal `json:"size"`
+	// AllowPartialFill fills what the book has instead of rejecting the
+	// order. WorstPrice and MaxSlippage, a fraction of the best price,
+	// limit how far into the book the order goes.
+	AllowPartialFill bool              `json:"allowPartialFill"`
+	WorstPrice       orderbook.Decimal `json:"worstPrice"`
+	MaxSlippage      orderbook.Decimal `json:"maxSlippage"`
+	// QuoteSize sizes the order in the quote currency instead of Size, the
+	// response has the base size it bought or sold
+	QuoteSize orderbook.Decimal `json:"quoteSize"`
+}
+
+// PlaceStopOrderParams places a stop market order, or a stop limit order at
+// Price when Price is set.
+type PlaceStopOrderParams struct {
+	UserID    int64             `json:"userId"`
+	Bid       bool              `json:"bid"`
+	StopPrice orderbook.Decimal `json:"stopPrice"`
+	Price     orderbook.Decimal `json:"price"`
+	Size      orderbook.Decimal `json:"size"`
+}
+
+// AmendOrderParams changes a resting order, a zero Price or Size keeps the
+// current one.
+type AmendOrderParams struct {
+	Price orderbook.Decimal `json:"price"`
+	Size  orderbook.Decimal `json:"size"`
+}
+
+// GetTradesParams pages through the tape. From and To are unix nanoseconds
+// and Cursor is the NextCursor of the previous page, zero values are left out.
+type GetTradesParams struct {
+	From   int64
+	To     int64
+	Cursor int64
+	Limit  int
 }
 
 func (c *Client) GetOrders(userId int64) (*server.UserOrdersResponse, error) {
@@ -62,6 +130,11 @@ func (c *Client) PlaceMarketOrder(p *PlaceMarketOrderParams) (*server.PlaceOrder
 		Bid:    p.Bid,
 		Size:   p.Size,
 		Market: server.MarketETH,
+
+		AllowPartialFill: p.AllowPartialFill,
+		WorstPrice:       p.WorstPrice,
+		MaxSlippage:      p.MaxSlippage,
+		QuoteSize:        p.QuoteSize,
 	}
 	body, err := json.Marshal(params)
 
@@ -98,6 +171,15 @@ func (c *Client) PlaceLimitOrder(p *PlaceLimitOrderParams) (*server.PlaceOrderRe
 		Size:   p.Size,
 		Price:  p.Price,
 		Market: server.MarketETH,
+
+		TimeInForce:  p.TimeInForce,
+		ExpiresAt:    p.ExpiresAt,
+		PostOnly:     p.PostOnly,
+		PostOnlyMode: p.PostOnlyMode,
+		DisplaySize:  p.DisplaySize,
+		Hidden:       p.Hidden,
+		MinQty:       p.MinQty,
+		AllOrNone:    p.AllOrNone,
 	}
 	body, err := json.Marshal(params)
 
@@ -126,43 +208,120 @@ func (c *Client) PlaceLimitOrder(p *PlaceLimitOrderParams) (*server.PlaceOrderRe
 	return &placeLimitOrderResponse, nil
 }
 
-func (c *Client) GetBestBid() (float64, error) {
+func (c *Client) PlaceStopOrder(p *PlaceStopOrderParams) (*server.PlaceOrderResponse, error) {
+	params := &server.PlaceOrderRequest{
+		UserID:    p.UserID,
+		Type:      server.StopMarketOrder,
+		Bid:       p.Bid,
+		Size:      p.Size,
+		StopPrice: p.StopPrice,
+		Market:    server.MarketETH,
+	}
+	if !p.Price.IsZero() {
+		params.Type = server.StopLimitOrder
+		params.Price = p.Price
+	}
+	body, err := json.Marshal(params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	e := EndPoint + "/order"
+	req, err := http.NewRequest("POST", e, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	var placeStopOrderResponse server.PlaceOrderResponse
+	if err := json.NewDecoder(response.Body).Decode(&placeStopOrderResponse); err != nil {
+		return nil, err
+	}
+
+	return &placeStopOrderResponse, nil
+}
+
+func (c *Client) PlacePeggedOrder(p *PlacePeggedOrderParams) (*server.PlaceOrderResponse, error) {
+	params := &server.PlaceOrderRequest{
+		UserID:    p.UserID,
+		Type:      server.PeggedOrder,
+		Bid:       p.Bid,
+		Size:      p.Size,
+		Peg:       p.Peg,
+		PegOffset: p.PegOffset,
+		Hidden:    p.Hidden,
+		MinQty:    p.MinQty,
+		AllOrNone: p.AllOrNone,
+		Market:    server.MarketETH,
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", EndPoint+"/order", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var placePeggedOrderResponse server.PlaceOrderResponse
+	if err := json.NewDecoder(response.Body).Decode(&placePeggedOrderResponse); err != nil {
+		return nil, err
+	}
+
+	return &placePeggedOrderResponse, nil
+}
+
+func (c *Client) GetBestBid() (orderbook.Decimal, error) {
 	e := fmt.Sprintf("%s/book/ETH/best-bid", EndPoint)
 	req, err := http.NewRequest(http.MethodGet, e, nil)
 	if err != nil {
-		return 0, err
+		return orderbook.Zero, err
 	}
 
 	res, err := c.Do(req)
 	if err != nil {
-		return 0, err
+		return orderbook.Zero, err
 	}
 	defer res.Body.Close()
 
 	priceResponse := &server.PriceResponse{}
 	if err := json.NewDecoder(res.Body).Decode(priceResponse); err != nil {
-		return 0, err
+		return orderbook.Zero, err
 	}
 
 	return priceResponse.Price, nil
 }
 
-func (c *Client) GetBestAsk() (float64, error) {
+func (c *Client) GetBestAsk() (orderbook.Decimal, error) {
 	e := fmt.Sprintf("%s/book/ETH/best-ask", EndPoint)
 	req, err := http.NewRequest(http.MethodGet, e, nil)
 	if err != nil {
-		return 0, err
+		return orderbook.Zero, err
 	}
 
 	res, err := c.Do(req)
 	if err != nil {
-		return 0, err
+		return orderbook.Zero, err
 	}
 	defer res.Body.Close()
 
 	priceResponse := &server.PriceResponse{}
 	if err := json.NewDecoder(res.Body).Decode(priceResponse); err != nil {
-		return 0, err
+		return orderbook.Zero, err
 	}
 
 	return priceResponse.Price, nil
@@ -185,3 +344,122 @@ func (c *Client) CancelOrder(orderId int64) error {
 
 	return nil
 }
+
+func (c *Client) AmendOrder(orderId int64, p *AmendOrderParams) (*server.PlaceOrderResponse, error) {
+	params := &server.AmendOrderRequest{
+		Price: p.Price,
+		Size:  p.Size,
+	}
+	body, err := json.Marshal(params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	e := fmt.Sprintf("%s/order/%d", EndPoint, orderId)
+	req, err := http.NewRequest(http.MethodPatch, e, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	var amendOrderResponse server.PlaceOrderResponse
+	if err := json.NewDecoder(response.Body).Decode(&amendOrderResponse); err != nil {
+		return nil, err
+	}
+
+	return &amendOrderResponse, nil
+}
+
+func (c *Client) GetTrades(p *GetTradesParams) (*server.TradesResponse, error) {
+	query := url.Values{}
+	if p.From != 0 {
+		query.Set("from", strconv.FormatInt(p.From, 10))
+	}
+	if p.To != 0 {
+		query.Set("to", strconv.FormatInt(p.To, 10))
+	}
+	if p.Cursor != 0 {
+		query.Set("cursor", strconv.FormatInt(p.Cursor, 10))
+	}
+	if p.Limit != 0 {
+		query.Set("limit", strconv.Itoa(p.Limit))
+	}
+
+	e := fmt.Sprintf("%s/trades/ETH?%s", EndPoint, query.Encode())
+	req, err := http.NewRequest(http.MethodGet, e, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	trades := server.TradesResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&trades); err != nil {
+		return nil, err
+	}
+
+	return &trades, nil
+}
+
+func (c *Client) GetMarket(market server.Market) (*server.MarketResponse, error) {
+	e := fmt.Sprintf("%s/markets/%s", EndPoint, market)
+	req, err := http.NewRequest(http.MethodGet, e, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	marketRes := server.MarketResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&marketRes); err != nil {
+		return nil, err
+	}
+
+	return &marketRes, nil
+}
+
+// GetDepth returns up to levels price levels a side, all of them when levels
+// is zero, bucketed to multiples of group when group is positive.
+func (c *Client) GetDepth(levels int, group orderbook.Decimal) (*server.DepthResponse, error) {
+	query := url.Values{}
+	if levels != 0 {
+		query.Set("levels", strconv.Itoa(levels))
+	}
+	if group.IsPositive() {
+		query.Set("group", group.String())
+	}
+
+	e := fmt.Sprintf("%s/book/ETH/depth?%s", EndPoint, query.Encode())
+	req, err := http.NewRequest(http.MethodGet, e, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	depth := server.DepthResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&depth); err != nil {
+		return nil, err
+	}
+
+	return &depth, nil
+}
@@ -3,10 +3,10 @@ package main
 import (
 	"fmt"
 	"log"
-	"math"
 	"time"
 
 	"github.com/natac13/go-crypto-exchange/client"
+	"github.com/natac13/go-crypto-exchange/orderbook"
 	"github.com/natac13/go-crypto-exchange/server"
 )
 
@@ -16,6 +16,10 @@ const (
 
 var (
 	tick = 2 * time.Second
+
+	marketOrderSize = orderbook.RequireFromString("0.2")
+	makerOrderSize  = orderbook.RequireFromInt(5)
+	makerPriceStep  = orderbook.RequireFromInt(100)
 )
 
 func marketOrderPlacer(c *client.Client) {
@@ -24,7 +28,7 @@ func marketOrderPlacer(c *client.Client) {
 		marketSellOrder := &client.PlaceMarketOrderParams{
 			UserID: 9,
 			Bid:    false,
-			Size:   0.2,
+			Size:   marketOrderSize,
 		}
 
 		_, err := c.PlaceMarketOrder(marketSellOrder)
@@ -35,7 +39,7 @@ func marketOrderPlacer(c *client.Client) {
 		marketBuyOrder := &client.PlaceMarketOrderParams{
 			UserID: 9,
 			Bid:    true,
-			Size:   0.2,
+			Size:   marketOrderSize,
 		}
 
 		_, err = c.PlaceMarketOrder(marketBuyOrder)
@@ -65,7 +69,7 @@ func makeMarketSimple(c *client.Client) {
 		if err != nil {
 			log.Println(err)
 		}
-		spread := math.Abs(bestAsk - bestBid)
+		spread := bestAsk.Sub(bestBid).Abs()
 		fmt.Println("exchange spread: => ", spread)
 
 		// place 2 orders to tighten the spread
@@ -75,8 +79,8 @@ func makeMarketSimple(c *client.Client) {
 			bidLimit := &client.PlaceLimitOrderParams{
 				UserID: 7,
 				Bid:    true,
-				Price:  bestBid + 100,
-				Size:   5,
+				Price:  bestBid.Add(makerPriceStep),
+				Size:   makerOrderSize,
 			}
 			_, err := c.PlaceLimitOrder(bidLimit)
 			if err != nil {
@@ -87,8 +91,8 @@ func makeMarketSimple(c *client.Client) {
 			askLimit := &client.PlaceLimitOrderParams{
 				UserID: 7,
 				Bid:    false,
-				Price:  bestAsk - 100,
-				Size:   5,
+				Price:  bestAsk.Sub(makerPriceStep),
+				Size:   makerOrderSize,
 			}
 			_, err := c.PlaceLimitOrder(askLimit)
 			if err != nil {
@@ -104,18 +108,20 @@ func makeMarketSimple(c *client.Client) {
 }
 
 func seedMarket(c *client.Client) error {
+	// the seller does not want to show its full size
 	ask := &client.PlaceLimitOrderParams{
-		UserID: 8,
-		Bid:    false,
-		Price:  10_000,
-		Size:   10,
+		UserID:      8,
+		Bid:         false,
+		Price:       orderbook.RequireFromInt(10_000),
+		Size:        orderbook.RequireFromInt(10),
+		DisplaySize: orderbook.RequireFromInt(2),
 	}
 
 	bid := &client.PlaceLimitOrderParams{
 		UserID: 8,
 		Bid:    true,
-		Price:  9_000,
-		Size:   10,
+		Price:  orderbook.RequireFromInt(9_000),
+		Size:   orderbook.RequireFromInt(10),
 	}
 
 	_, err := c.PlaceLimitOrder(ask)
@@ -0,0 +1,102 @@
+package orderbook
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrOrderNotResting = errors.New("only resting limit orders can be amended")
+	ErrInvalidSize     = errors.New("size must be positive")
+	ErrInvalidPrice    = errors.New("price must be positive")
+)
+
+// AmendOrder changes the price and size of a resting order, where size is
+// the new remaining size including any iceberg reserve. Reducing the size at
+// the same price keeps the order's place in the queue. Increasing the size
+// or changing the price moves it to the back of the queue at the new price,
+// where it matches like a new limit order if it crosses the book. The order
+// keeps its ID either way. A pegged order keeps following its reference, so
+// only its size can change.
+func (ob *Orderbook) AmendOrder(o *Order, price, size Decimal) ([]Match, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	ob.expireOrders(now)
+	defer ob.repeg(now)
+
+	if o.Limit == nil || ob.Orders[o.ID] != o {
+		return nil, ErrOrderNotResting
+	}
+	if o.Peg != "" && !price.Equal(o.Limit.Price) {
+		return nil, ErrPeggedAmendPrice
+	}
+	if !price.IsPositive() {
+		return nil, ErrInvalidPrice
+	}
+	if !size.IsPositive() {
+		return nil, ErrInvalidSize
+	}
+
+	remaining := o.Size.Add(o.reserve)
+
+	if price.Equal(o.Limit.Price) && size.LessThanOrEqual(remaining) {
+		o.Limit.reduceOrder(o, remaining.Sub(size))
+		ob.publishCanceled(o, remaining.Sub(size), size, CancelAmended)
+		if !o.Hidden {
+			ob.publishLevel(o.Bid, o.Limit)
+		}
+		ob.publishIndicative()
+		return nil, nil
+	}
+
+	if ob.halted(now) {
+		return nil, ErrTradingHalted
+	}
+	// the amended order is checked the way placeLimitOrder will check it,
+	// at the same time, so it cannot be rejected once o has left the book
+	amended := o.scratch()
+	amended.Size, amended.reserve = size, Zero
+	if err := ob.checkLimitOrder(price, amended, now); err != nil {
+		return nil, err
+	}
+	if o.PostOnly && !ob.auction {
+		if _, err := ob.postOnlyPrice(price, amended); err != nil {
+			return nil, err
+		}
+	}
+	if !o.PostOnly && !ob.auction {
+		_, acceptable := ob.crossingLevels(price, amended)
+		if _, err := ob.planLimitOrder(amended, acceptable); err != nil {
+			return nil, err
+		}
+	}
+	if err := ob.reserveIDs(); err != nil {
+		return nil, err
+	}
+
+	ob.cancelOrder(o, CancelAmended)
+	o.Size = size
+	o.reserve = Zero
+	o.Timestamp = time.Now().UnixNano()
+
+	matches, err := ob.placeLimitOrder(nil, price, o, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return ob.triggerStops(matches), nil
+}
+
+// reduceOrder takes by off an order without changing its place in the queue.
+// An iceberg order loses its reserve first.
+func (l *Limit) reduceOrder(o *Order, by Decimal) {
+	fromReserve := MinDecimal(by, o.reserve)
+	o.reserve = o.reserve.Sub(fromReserve)
+	l.reserveVolume = l.reserveVolume.Sub(fromReserve)
+
+	fromSize := by.Sub(fromReserve)
+	o.Size = o.Size.Sub(fromSize)
+	l.addVolume(o, fromSize.Neg())
+}
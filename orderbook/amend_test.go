@@ -0,0 +1,129 @@
+package orderbook
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAmendOrderReduceKeepsPriority(t *testing.T) {
+	ob := NewOrderbook()
+
+	buyOrderA := NewOrder(true, dec(5), 0)
+	buyOrderB := NewOrder(true, dec(5), 0)
+	ob.PlaceLimitOrder(dec(9_000), buyOrderA)
+	ob.PlaceLimitOrder(dec(9_000), buyOrderB)
+
+	matches, err := ob.AmendOrder(buyOrderA, dec(9_000), dec(2))
+
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	limit := ob.BidLimits[dec(9_000)]
+	assert(t, limit.Orders(), Orders{buyOrderA, buyOrderB})
+	assert(t, buyOrderA.Size, dec(2))
+	assert(t, limit.TotalVolume, dec(7))
+
+	matches, _ = ob.PlaceMarketOrder(NewOrder(false, dec(1), 0))
+	assert(t, matches[0].Bid, buyOrderA)
+}
+
+func TestAmendOrderIncreaseRequeues(t *testing.T) {
+	ob := NewOrderbook()
+
+	buyOrderA := NewOrder(true, dec(5), 0)
+	buyOrderB := NewOrder(true, dec(5), 0)
+	ob.PlaceLimitOrder(dec(9_000), buyOrderA)
+	ob.PlaceLimitOrder(dec(9_000), buyOrderB)
+	id := buyOrderA.ID
+
+	_, err := ob.AmendOrder(buyOrderA, dec(9_000), dec(8))
+
+	assert(t, err, nil)
+	limit := ob.BidLimits[dec(9_000)]
+	assert(t, limit.Orders(), Orders{buyOrderB, buyOrderA})
+	assert(t, limit.TotalVolume, dec(13))
+	assert(t, buyOrderA.ID, id)
+	assert(t, ob.Orders[id], buyOrderA)
+}
+
+func TestAmendOrderPrice(t *testing.T) {
+	ob := NewOrderbook()
+
+	sellOrderA := NewOrder(false, dec(2), 0)
+	buyOrderA := NewOrder(true, dec(5), 0)
+	ob.PlaceLimitOrder(dec(10_000), sellOrderA)
+	ob.PlaceLimitOrder(dec(9_000), buyOrderA)
+
+	// moving the bid through the ask matches it
+	matches, err := ob.AmendOrder(buyOrderA, dec(10_000), dec(5))
+
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Ask, sellOrderA)
+	assert(t, buyOrderA.Size, dec(3))
+	assert(t, buyOrderA.Limit.Price, dec(10_000))
+	assert(t, ob.bids.Len(), 1)
+	_, ok := ob.BidLimits[dec(9_000)]
+	assert(t, ok, false)
+}
+
+func TestAmendOrderIceberg(t *testing.T) {
+	ob := NewOrderbook()
+
+	iceberg := NewOrder(false, dec(10), 0)
+	iceberg.DisplaySize = dec(2)
+	ob.PlaceLimitOrder(dec(10_000), iceberg)
+
+	// comes out of the reserve first
+	_, err := ob.AmendOrder(iceberg, dec(10_000), dec(4))
+	assert(t, err, nil)
+	assert(t, iceberg.Size, dec(2))
+	assert(t, iceberg.Reserve(), dec(2))
+
+	_, err = ob.AmendOrder(iceberg, dec(10_000), dec(1))
+	assert(t, err, nil)
+	assert(t, iceberg.Size, dec(1))
+	assert(t, iceberg.Reserve(), Zero)
+	assert(t, ob.AskTotalVolume(), dec(1))
+}
+
+func TestAmendOrderRejected(t *testing.T) {
+	ob := NewOrderbook()
+
+	sellOrderA := NewOrder(false, dec(2), 0)
+	buyOrderA := NewOrder(true, dec(5), 0)
+	buyOrderA.PostOnly = true
+	ob.PlaceLimitOrder(dec(10_000), sellOrderA)
+	ob.PlaceLimitOrder(dec(9_000), buyOrderA)
+
+	_, err := ob.AmendOrder(buyOrderA, dec(9_000), Zero)
+	assert(t, err, ErrInvalidSize)
+
+	// a post-only order cannot be amended into a cross and stays as it was
+	_, err = ob.AmendOrder(buyOrderA, dec(10_000), dec(5))
+	assert(t, err, ErrPostOnlyWouldCross)
+	assert(t, buyOrderA.Limit.Price, dec(9_000))
+	assert(t, ob.Orders[buyOrderA.ID], buyOrderA)
+
+	ob.CancelOrder(buyOrderA)
+	_, err = ob.AmendOrder(buyOrderA, dec(9_000), dec(1))
+	assert(t, err, ErrOrderNotResting)
+}
+
+func TestAmendOrderRejectedKeepsOrder(t *testing.T) {
+	ob := NewOrderbook()
+
+	buyOrder := NewOrder(true, dec(5), 1)
+	buyOrder.TimeInForce = GoodTilDate
+	buyOrder.ExpiresAt = time.Now().Add(time.Hour).UnixNano()
+	ob.PlaceLimitOrder(dec(9_000), buyOrder)
+
+	// anything placeLimitOrder would reject the amended order for is caught
+	// before the order leaves the book, not after
+	buyOrder.SelfTradePrevention = "XX"
+	_, err := ob.AmendOrder(buyOrder, dec(9_100), dec(5))
+	assert(t, errors.Is(err, ErrInvalidSelfTradePrevention), true)
+	assert(t, ob.Orders[buyOrder.ID], buyOrder)
+	assert(t, buyOrder.Limit.Price, dec(9_000))
+	assert(t, ob.BidTotalVolume(), dec(5))
+}
@@ -0,0 +1,461 @@
+package orderbook
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	ErrAuctionMarketOrder = errors.New("market orders are not accepted during an auction")
+	ErrAuctionTimeInForce = errors.New("immediate or cancel and fill or kill orders are not accepted during an auction")
+	ErrNoAuction          = errors.New("the market is not in an auction")
+)
+
+// StatusAuction is a market collecting orders for a call auction.
+const StatusAuction TradingStatus = "AUCTION"
+
+// IndicativeUncross is published whenever an order enters or leaves the
+// book during an auction. Price and Volume are what an uncross would trade
+// right now, both zero when the book does not cross.
+type IndicativeUncross struct {
+	Price  Decimal
+	Volume Decimal
+}
+
+func (IndicativeUncross) event() {}
+
+// StartAuction switches the book to a call auction, as for a new listing.
+// Limit orders rest without matching, so the book can cross, until Uncross
+// is called. end is when the auction is due to uncross, in unix nanoseconds,
+// or zero when there is no set time. The book does not uncross by itself,
+// the caller checks MarketState.AuctionEnd.
+func (ob *Orderbook) StartAuction(end int64) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.startAuction(end)
+}
+
+func (ob *Orderbook) startAuction(end int64) {
+	ob.auction = true
+	ob.auctionEnd = end
+	ob.publishIndicative()
+}
+
+// Uncross ends the auction. Every bid at or above and every ask at or below
+// the clearing price trades at that price, best price first and then in time
+// order, and the book goes back to continuous trading. The returned matches
+// also include those of any stop orders the auction triggered. The matching
+// policy does not apply to the auction trades.
+//
+// Orders of the same user that would trade with each other are handled by
+// the self-trade prevention mode of the later of the two, as if it had come
+// in against the other. They trade if it has none. The orders prevention
+// cancels or reduces leave the auction and the clearing price is worked out
+// again without them.
+//
+// An order with a MinQty or AllOrNone condition takes part only when its
+// share of the auction meets it, all of its size for AllOrNone and at least
+// its MinQty otherwise, in one or more trades. One whose share would not is
+// left out and the clearing price worked out again without it. If it is
+// left crossing the book after the uncross it is canceled with
+// CancelWouldCross.
+func (ob *Orderbook) Uncross() ([]Match, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if !ob.auction {
+		return nil, ErrNoAuction
+	}
+	if ob.halted(time.Now().UnixNano()) {
+		return nil, ErrTradingHalted
+	}
+	if err := ob.reserveIDs(); err != nil {
+		return nil, err
+	}
+
+	matches, excluded := ob.uncross()
+	ob.auction = false
+	ob.auctionEnd = 0
+	ob.cancelCrossing(excluded)
+
+	matches = ob.triggerStops(matches)
+	ob.repeg(time.Now().UnixNano())
+	return matches, nil
+}
+
+// Indicative returns the price and volume an uncross would trade right now.
+func (ob *Orderbook) Indicative() (Decimal, Decimal) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	return ob.clearingPrice()
+}
+
+func (ob *Orderbook) publishIndicative() {
+	if len(ob.listeners) == 0 || !ob.auction {
+		return
+	}
+	price, volume := ob.clearingPrice()
+	ob.publish(IndicativeUncross{Price: price, Volume: volume})
+}
+
+// clearingPrice returns the price and volume the auction would uncross at,
+// see auctionPlan.
+func (ob *Orderbook) clearingPrice() (Decimal, Decimal) {
+	price, volume, _ := ob.auctionPlan()
+	return price, volume
+}
+
+// auctionPlan works out the clearing price and volume of the auction and
+// the orders left out of it for their MinQty or AllOrNone condition. Each
+// round leaves out the first order, best price first and then in time
+// order, whose share at the clearing price breaks its condition, until
+// every share meets them.
+func (ob *Orderbook) auctionPlan() (Decimal, Decimal, map[*Order]bool) {
+	var excluded map[*Order]bool
+	for {
+		price, volume := ob.clearingPriceWithout(excluded)
+		unmet := unmetAuctionCondition(ob.auctionAllocation(ob.asks, volume, excluded))
+		if unmet == nil {
+			unmet = unmetAuctionCondition(ob.auctionAllocation(ob.bids, volume, excluded))
+		}
+		if unmet == nil {
+			return price, volume, excluded
+		}
+		if excluded == nil {
+			excluded = make(map[*Order]bool)
+		}
+		excluded[unmet] = true
+	}
+}
+
+// unmetAuctionCondition returns the first order whose share in fills breaks
+// its MinQty or AllOrNone condition, or nil.
+func unmetAuctionCondition(fills []auctionFill) *Order {
+	for _, fill := range fills {
+		o := fill.order
+		if !o.conditional() {
+			continue
+		}
+		total := o.Size.Add(o.reserve)
+		if o.AllOrNone && fill.size.LessThan(total) || fill.size.LessThan(MinDecimal(o.MinQty, total)) {
+			return o
+		}
+	}
+	return nil
+}
+
+// clearingPriceWithout finds the price that trades the most volume, leaving
+// out the excluded orders. Ties go to the price leaving the smallest surplus
+// on either side, then to the price nearest the last trade, then to the
+// lowest price.
+func (ob *Orderbook) clearingPriceWithout(excluded map[*Order]bool) (Decimal, Decimal) {
+	best := struct{ price, volume, surplus Decimal }{}
+	if ob.asks.Len() == 0 || ob.bids.Len() == 0 || ob.asks.Best().Price.GreaterThan(ob.bids.Best().Price) {
+		return Zero, Zero
+	}
+
+	// every crossing price, lowest first, with the ask volume at or below
+	// it and the bid volume at or above it
+	prices := []Decimal{}
+	askVolume := make(map[Decimal]Decimal)
+	bidVolume := make(map[Decimal]Decimal)
+	ob.asks.Each(func(l *Limit) bool {
+		askVolume[l.Price] = l.TotalVolume.Add(l.reserveVolume).Add(l.hiddenVolume)
+		prices = append(prices, l.Price)
+		return true
+	})
+	ob.bids.Each(func(l *Limit) bool {
+		bidVolume[l.Price] = l.TotalVolume.Add(l.reserveVolume).Add(l.hiddenVolume)
+		if _, ok := askVolume[l.Price]; !ok {
+			prices = append(prices, l.Price)
+		}
+		return true
+	})
+	for o := range excluded {
+		volumes := askVolume
+		if o.Bid {
+			volumes = bidVolume
+		}
+		volumes[o.Limit.Price] = volumes[o.Limit.Price].Sub(o.Size.Add(o.reserve))
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i].LessThan(prices[j]) })
+
+	asksAtOrBelow := make([]Decimal, len(prices))
+	cumulative := Zero
+	for i, price := range prices {
+		cumulative = cumulative.Add(askVolume[price])
+		asksAtOrBelow[i] = cumulative
+	}
+
+	bidsAtOrAbove := Zero
+	for i := len(prices) - 1; i >= 0; i-- {
+		price := prices[i]
+		bidsAtOrAbove = bidsAtOrAbove.Add(bidVolume[price])
+
+		volume := MinDecimal(bidsAtOrAbove, asksAtOrBelow[i])
+		surplus := bidsAtOrAbove.Sub(asksAtOrBelow[i]).Abs()
+		if volume.IsPositive() && ob.betterClearing(price, volume, surplus, best.price, best.volume, best.surplus) {
+			best.price, best.volume, best.surplus = price, volume, surplus
+		}
+	}
+
+	return best.price, best.volume
+}
+
+// betterClearing reports whether price beats the best clearing price so
+// far. Prices are tried highest first, so a tie that gets this far goes to
+// the new, lower price.
+func (ob *Orderbook) betterClearing(price, volume, surplus, bestPrice, bestVolume, bestSurplus Decimal) bool {
+	if c := volume.Cmp(bestVolume); c != 0 {
+		return c > 0
+	}
+	if c := surplus.Cmp(bestSurplus); c != 0 {
+		return c < 0
+	}
+	if ob.lastTradePrice.IsZero() {
+		return true
+	}
+	distance := price.Sub(ob.lastTradePrice).Abs()
+	return distance.LessThanOrEqual(bestPrice.Sub(ob.lastTradePrice).Abs())
+}
+
+// uncross trades the auction and returns its matches along with the
+// orders it left out for their conditions, see auctionPlan.
+func (ob *Orderbook) uncross() ([]Match, map[*Order]bool) {
+	for {
+		price, volume, excluded := ob.auctionPlan()
+		if volume.IsZero() {
+			return nil, excluded
+		}
+
+		asks := ob.auctionAllocation(ob.asks, volume, excluded)
+		bids := ob.auctionAllocation(ob.bids, volume, excluded)
+		if ob.preventAuctionSelfTrade(asks, bids) {
+			continue
+		}
+		return ob.tradeAuction(price, asks, bids), excluded
+	}
+}
+
+// preventAuctionSelfTrade pairs asks and bids the way tradeAuction does and
+// applies self-trade prevention to the first pair of orders of one user
+// that must not trade. It reports whether it changed the book.
+func (ob *Orderbook) preventAuctionSelfTrade(asks, bids []auctionFill) bool {
+	i, j := 0, 0
+	askLeft, bidLeft := Zero, Zero
+	for i < len(asks) && j < len(bids) {
+		if askLeft.IsZero() {
+			askLeft = asks[i].size
+		}
+		if bidLeft.IsZero() {
+			bidLeft = bids[j].size
+		}
+		ask, bid := asks[i].order, bids[j].order
+		size := MinDecimal(askLeft, bidLeft)
+
+		newer, older := ask, bid
+		if bid.Timestamp > ask.Timestamp || bid.Timestamp == ask.Timestamp && bid.ID > ask.ID {
+			newer, older = bid, ask
+		}
+		if newer.preventsSelfTrade(older) {
+			ob.preventRestingSelfTrade(newer, older, size)
+			return true
+		}
+
+		if askLeft = askLeft.Sub(size); askLeft.IsZero() {
+			i++
+		}
+		if bidLeft = bidLeft.Sub(size); bidLeft.IsZero() {
+			j++
+		}
+	}
+	return false
+}
+
+// preventRestingSelfTrade applies the self-trade prevention mode of newer
+// to two resting orders of one user that would trade size with each other.
+func (ob *Orderbook) preventRestingSelfTrade(newer, older *Order, size Decimal) {
+	switch newer.SelfTradePrevention {
+	case CancelNewest:
+		ob.cancelOrder(newer, CancelSelfTrade)
+	case CancelOldest:
+		ob.cancelOrder(older, CancelSelfTrade)
+	case CancelBoth:
+		ob.cancelOrder(newer, CancelSelfTrade)
+		ob.cancelOrder(older, CancelSelfTrade)
+	case DecrementAndCancel:
+		ob.reduceResting(newer, size, CancelSelfTrade)
+		ob.reduceResting(older, size, CancelSelfTrade)
+	}
+}
+
+// reduceResting takes size off a resting order, canceling it when that is
+// all it has left.
+func (ob *Orderbook) reduceResting(o *Order, size Decimal, reason CancelReason) {
+	remaining := o.Size.Add(o.reserve)
+	if size.GreaterThanOrEqual(remaining) {
+		ob.cancelOrder(o, reason)
+		return
+	}
+
+	o.Limit.reduceOrder(o, size)
+	ob.publishCanceled(o, size, remaining.Sub(size), reason)
+	if !o.Hidden {
+		ob.publishLevel(o.Bid, o.Limit)
+	}
+}
+
+// tradeAuction trades the paired asks and bids at price.
+func (ob *Orderbook) tradeAuction(price Decimal, asks, bids []auctionFill) []Match {
+	var (
+		matches []Match
+		touched []*Limit
+		// visible is whether each touched level had a visible order before
+		// the auction traded against it
+		visible = make(map[*Limit]bool)
+	)
+	touch := func(l *Limit) {
+		if n := len(touched); n == 0 || touched[n-1] != l {
+			touched = append(touched, l)
+		}
+		if _, ok := visible[l]; !ok {
+			visible[l] = l.VisibleLen() > 0
+		}
+	}
+	for len(asks) > 0 && len(bids) > 0 {
+		ask, bid := &asks[0], &bids[0]
+		size := MinDecimal(ask.size, bid.size)
+		touch(ask.order.Limit)
+		touch(bid.order.Limit)
+
+		match := Match{Ask: ask.order, Bid: bid.order, SizeFilled: size, Price: price}
+		matches = append(matches, match)
+		ob.fillAuctionOrder(ask.order, size)
+		ob.fillAuctionOrder(bid.order, size)
+
+		trade := ob.recordTrade(false, match)
+		trade.Auction = true
+		if len(ob.listeners) > 0 {
+			ob.publish(TradeExecuted{Trade: *trade})
+			ob.publishFill(ask.order, price, size, ask.order.Size.Add(ask.order.reserve))
+			ob.publishFill(bid.order, price, size, bid.order.Size.Add(bid.order.reserve))
+		}
+
+		if ask.size = ask.size.Sub(size); ask.size.IsZero() {
+			asks = asks[1:]
+		}
+		if bid.size = bid.size.Sub(size); bid.size.IsZero() {
+			bids = bids[1:]
+		}
+	}
+	ob.lastTradePrice = price
+
+	seen := make(map[*Limit]bool)
+	for _, limit := range touched {
+		if seen[limit] {
+			continue
+		}
+		seen[limit] = true
+
+		bid := ob.BidLimits[limit.Price] == limit
+		if limit.count == 0 {
+			ob.clearLimits(bid, limit)
+		}
+		// as in a sweep, a level of hidden orders only is not published
+		if visible[limit] || limit.VisibleLen() > 0 {
+			ob.publishLevel(bid, limit)
+		}
+	}
+
+	return matches
+}
+
+// cancelCrossing cancels the orders the auction left out for their
+// conditions that would otherwise rest crossing the book once it is back to
+// continuous trading.
+func (ob *Orderbook) cancelCrossing(excluded map[*Order]bool) {
+	if len(excluded) == 0 {
+		return
+	}
+
+	// best price first, so an order that crosses only the ones behind it
+	// stays
+	orders := make([]*Order, 0, len(excluded))
+	for o := range excluded {
+		orders = append(orders, o)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		a, b := orders[i], orders[j]
+		if a.Bid != b.Bid {
+			return a.Bid
+		}
+		if !a.Limit.Price.Equal(b.Limit.Price) {
+			return a.Limit.Price.GreaterThan(b.Limit.Price) == a.Bid
+		}
+		return a.Timestamp < b.Timestamp
+	})
+
+	for _, o := range orders {
+		opposite := ob.asks.Best()
+		if !o.Bid {
+			opposite = ob.bids.Best()
+		}
+		if opposite == nil || o.Limit == nil {
+			continue
+		}
+		if o.Bid && !o.Limit.Price.LessThan(opposite.Price) || !o.Bid && !o.Limit.Price.GreaterThan(opposite.Price) {
+			ob.cancelOrder(o, CancelWouldCross)
+		}
+	}
+}
+
+type auctionFill struct {
+	order *Order
+	size  Decimal
+}
+
+// auctionAllocation shares volume out over the orders of one side, best
+// price first and then in time order, leaving out the excluded orders.
+func (ob *Orderbook) auctionAllocation(levels *priceLevels, volume Decimal, excluded map[*Order]bool) []auctionFill {
+	var fills []auctionFill
+	levels.Each(func(l *Limit) bool {
+		for o := l.head; o != nil; o = o.next {
+			if volume.IsZero() {
+				return false
+			}
+			if excluded[o] {
+				continue
+			}
+			size := MinDecimal(volume, o.Size.Add(o.reserve))
+			fills = append(fills, auctionFill{order: o, size: size})
+			volume = volume.Sub(size)
+		}
+		return !volume.IsZero()
+	})
+	return fills
+}
+
+// fillAuctionOrder takes size off a resting order, from its visible slice
+// first. An iceberg that runs out of visible size is replenished from its
+// reserve at the back of the queue, and a filled order leaves the book.
+func (ob *Orderbook) fillAuctionOrder(o *Order, size Decimal) {
+	limit := o.Limit
+	for size.IsPositive() {
+		fill := MinDecimal(size, o.Size)
+		o.Size = o.Size.Sub(fill)
+		limit.addVolume(o, fill.Neg())
+		size = size.Sub(fill)
+
+		if o.Size.IsZero() {
+			limit.DeleteOrder(o)
+			if !o.reserve.IsPositive() {
+				delete(ob.Orders, o.ID)
+				return
+			}
+			limit.replenish(o)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package orderbook
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuctionAccumulatesWithoutMatching(t *testing.T) {
+	ob := NewOrderbook()
+	ob.StartAuction(0)
+
+	matches, err := ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(5), 0))
+	assert(t, err, nil)
+	matches, err = ob.PlaceLimitOrder(dec(105), NewOrder(true, dec(3), 0))
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, ob.BestBid().Price, dec(105))
+	assert(t, ob.BestAsk().Price, dec(100))
+
+	_, err = ob.PlaceMarketOrder(NewOrder(true, dec(1), 0))
+	assert(t, err, ErrAuctionMarketOrder)
+
+	ioc := NewOrder(true, dec(1), 0)
+	ioc.TimeInForce = ImmediateOrCancel
+	_, err = ob.PlaceLimitOrder(dec(105), ioc)
+	assert(t, err, ErrAuctionTimeInForce)
+
+	state := ob.State()
+	assert(t, state.Status, StatusAuction)
+	assert(t, state.IndicativeVolume, dec(3))
+}
+
+func TestClearingPriceMaximisesVolume(t *testing.T) {
+	ob := NewOrderbook()
+	ob.StartAuction(0)
+
+	ob.PlaceLimitOrder(dec(99), NewOrder(false, dec(2), 0))
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(3), 0))
+	ob.PlaceLimitOrder(dec(102), NewOrder(false, dec(4), 0))
+	ob.PlaceLimitOrder(dec(103), NewOrder(true, dec(3), 0))
+	ob.PlaceLimitOrder(dec(101), NewOrder(true, dec(2), 0))
+	ob.PlaceLimitOrder(dec(98), NewOrder(true, dec(5), 0))
+
+	// at 100 and 101 five trade with no surplus, the tie goes to the lower
+	// price
+	price, volume := ob.Indicative()
+	assert(t, price, dec(100))
+	assert(t, volume, dec(5))
+}
+
+func TestClearingPriceNearestLastTrade(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(dec(104), NewOrder(false, dec(1), 0))
+	ob.PlaceMarketOrder(NewOrder(true, dec(1), 0))
+	ob.StartAuction(0)
+
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(2), 0))
+	ob.PlaceLimitOrder(dec(105), NewOrder(true, dec(2), 0))
+
+	price, volume := ob.Indicative()
+	assert(t, price, dec(105))
+	assert(t, volume, dec(2))
+}
+
+func TestUncross(t *testing.T) {
+	ob := NewOrderbook()
+	ob.StartAuction(0)
+
+	askA := NewOrder(false, dec(2), 0)
+	askB := NewOrder(false, dec(3), 0)
+	askC := NewOrder(false, dec(4), 0)
+	bidA := NewOrder(true, dec(3), 0)
+	bidB := NewOrder(true, dec(2), 0)
+	bidC := NewOrder(true, dec(5), 0)
+	ob.PlaceLimitOrder(dec(99), askA)
+	ob.PlaceLimitOrder(dec(100), askB)
+	ob.PlaceLimitOrder(dec(102), askC)
+	ob.PlaceLimitOrder(dec(103), bidA)
+	ob.PlaceLimitOrder(dec(101), bidB)
+	ob.PlaceLimitOrder(dec(98), bidC)
+
+	matches, err := ob.Uncross()
+	assert(t, err, nil)
+	assert(t, len(matches), 3)
+	for _, match := range matches {
+		assert(t, match.Price, dec(100))
+	}
+	assert(t, matches[0].Ask, askA)
+	assert(t, matches[0].Bid, bidA)
+	assert(t, matches[2].Ask, askB)
+	assert(t, matches[2].Bid, bidB)
+
+	assert(t, askA.IsFilled(), true)
+	assert(t, askB.IsFilled(), true)
+	assert(t, bidA.IsFilled(), true)
+	assert(t, bidB.IsFilled(), true)
+	assert(t, ob.BestAsk().Price, dec(102))
+	assert(t, ob.BestBid().Price, dec(98))
+	assert(t, ob.AskTotalVolume(), dec(4))
+	assert(t, ob.BidTotalVolume(), dec(5))
+	assert(t, len(ob.Orders), 2)
+	assert(t, ob.LastTradePrice(), dec(100))
+	assert(t, ob.Trades[0].Auction, true)
+	assert(t, ob.State().Status, StatusTrading)
+
+	_, err = ob.Uncross()
+	assert(t, err, ErrNoAuction)
+
+	// continuous trading again
+	matches, _ = ob.PlaceLimitOrder(dec(102), NewOrder(true, dec(1), 0))
+	assert(t, len(matches), 1)
+}
+
+func TestUncrossIceberg(t *testing.T) {
+	ob := NewOrderbook()
+	ob.StartAuction(0)
+
+	iceberg := NewOrder(false, dec(5), 0)
+	iceberg.DisplaySize = dec(2)
+	ob.PlaceLimitOrder(dec(100), iceberg)
+	ob.PlaceLimitOrder(dec(100), NewOrder(true, dec(3), 0))
+
+	_, volume := ob.Indicative()
+	assert(t, volume, dec(3))
+
+	ob.Uncross()
+	assert(t, iceberg.Size, dec(1))
+	assert(t, iceberg.Reserve(), dec(1))
+	assert(t, ob.AskTotalVolume(), dec(1))
+	assert(t, ob.bids.Len(), 0)
+}
+
+func TestUncrossConditions(t *testing.T) {
+	ob := NewOrderbook()
+	ob.StartAuction(0)
+
+	// three of the all-or-none ask would trade, so it is left out
+	aon := NewOrder(false, dec(10), 1)
+	aon.AllOrNone = true
+	ob.PlaceLimitOrder(dec(100), aon)
+	ob.PlaceLimitOrder(dec(101), NewOrder(false, dec(2), 2))
+	ob.PlaceLimitOrder(dec(101), NewOrder(true, dec(3), 3))
+
+	price, volume := ob.Indicative()
+	assert(t, price, dec(101))
+	assert(t, volume, dec(2))
+
+	matches, err := ob.Uncross()
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Ask.UserID, int64(2))
+	assert(t, aon.Size, dec(10))
+	// resting at 100 it would cross the rest of the bid, so it is canceled
+	assert(t, ob.Order(aon.ID), (*Order)(nil))
+	assert(t, ob.BestBid().Price, dec(101))
+	assert(t, ob.asks.Len(), 0)
+}
+
+func TestUncrossMinQty(t *testing.T) {
+	ob := NewOrderbook()
+	ob.StartAuction(0)
+
+	minQty := NewOrder(true, dec(10), 1)
+	minQty.MinQty = dec(4)
+	ob.PlaceLimitOrder(dec(100), minQty)
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(5), 2))
+
+	// 5 of 10 meets the MinQty, in one auction fill or several
+	matches, err := ob.Uncross()
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, minQty.Size, dec(5))
+
+	ob.StartAuction(0)
+	ob.PlaceLimitOrder(dec(99), NewOrder(false, dec(3), 2))
+	ob.PlaceLimitOrder(dec(98), NewOrder(true, dec(2), 3))
+
+	// 3 does not, the order is left out and then canceled since it would
+	// rest crossing the ask
+	var canceled []OrderCanceled
+	ob.AddListener(ListenerFunc(func(e Event) {
+		if c, ok := e.(OrderCanceled); ok {
+			canceled = append(canceled, c)
+		}
+	}))
+	matches, err = ob.Uncross()
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, minQty.Size, dec(5))
+	assert(t, ob.Order(minQty.ID), (*Order)(nil))
+	assert(t, len(canceled), 1)
+	assert(t, canceled[0].Reason, CancelWouldCross)
+	assert(t, ob.BestBid().Price, dec(98))
+}
+
+func TestUncrossSelfTradePrevention(t *testing.T) {
+	ob := NewOrderbook()
+	ob.StartAuction(0)
+
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(5), 1))
+	bid := NewOrder(true, dec(5), 1)
+	bid.SelfTradePrevention = CancelNewest
+	ob.PlaceLimitOrder(dec(101), bid)
+
+	// the bid came in later, its mode cancels it rather than trade
+	matches, err := ob.Uncross()
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, len(ob.Trades), 0)
+	assert(t, ob.Order(bid.ID), (*Order)(nil))
+	assert(t, ob.AskTotalVolume(), dec(5))
+
+	ob.StartAuction(0)
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(3), 2))
+	bid = NewOrder(true, dec(3), 1)
+	bid.SelfTradePrevention = CancelOldest
+	ob.PlaceLimitOrder(dec(101), bid)
+
+	// the user's own ask is canceled and the bid trades with the other one
+	matches, err = ob.Uncross()
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Ask.UserID, int64(2))
+	assert(t, matches[0].SizeFilled, dec(3))
+	assert(t, bid.IsFilled(), true)
+	assert(t, ob.asks.Len(), 0)
+
+	ob.StartAuction(0)
+	ask := NewOrder(false, dec(4), 1)
+	ob.PlaceLimitOrder(dec(100), ask)
+	bid = NewOrder(true, dec(3), 1)
+	bid.SelfTradePrevention = DecrementAndCancel
+	ob.PlaceLimitOrder(dec(100), bid)
+
+	matches, err = ob.Uncross()
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, ob.Order(bid.ID), (*Order)(nil))
+	assert(t, ask.Size, dec(1))
+}
+
+func TestCircuitBreakerReopenAuction(t *testing.T) {
+	ob := NewOrderbook(WithCircuitBreaker(CircuitBreaker{
+		MaxMove:       dec(0.05),
+		Window:        time.Minute,
+		Halt:          time.Hour,
+		ReopenAuction: true,
+	}))
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(1), 0))
+	ob.PlaceLimitOrder(dec(11_000), NewOrder(false, dec(1), 0))
+	ob.PlaceMarketOrder(NewOrder(true, dec(2), 0))
+
+	state := ob.State()
+	assert(t, state.Status, StatusAuction)
+	assert(t, state.AuctionEnd > time.Now().UnixNano(), true)
+
+	ob.PlaceLimitOrder(dec(10_500), NewOrder(false, dec(1), 0))
+	ob.PlaceLimitOrder(dec(10_600), NewOrder(true, dec(1), 0))
+	matches, err := ob.Uncross()
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Price, dec(10_600))
+}
@@ -0,0 +1,289 @@
+package orderbook
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+var (
+	ErrOutsidePriceBand = errors.New("price is outside the market's price band")
+	ErrTradingHalted    = errors.New("trading in the market is halted")
+)
+
+// PriceBand keeps trading within Width, a fraction like 0.05 for 5%, of a
+// reference price. The reference is the last trade price, or the volume
+// weighted average price of the trades in the last Window when Window is
+// set. Until the first trade there is no band.
+type PriceBand struct {
+	Width  Decimal
+	Window time.Duration
+}
+
+// CircuitBreaker halts trading for Halt when the price moves more than
+// MaxMove, a fraction like 0.1 for 10%, within Window. With ReopenAuction
+// the halt is an auction instead: orders keep coming in without matching
+// and the market reopens by uncrossing once the halt is over.
+type CircuitBreaker struct {
+	MaxMove       Decimal
+	Window        time.Duration
+	Halt          time.Duration
+	ReopenAuction bool
+}
+
+// TradingStatus says whether a market is open for trading.
+type TradingStatus string
+
+const (
+	StatusTrading TradingStatus = "TRADING"
+	StatusHalted  TradingStatus = "HALTED"
+)
+
+// MarketState is the trading state of an orderbook. HaltedUntil and
+// AuctionEnd are in unix nanoseconds and only set while halted or in an
+// auction with a set end. IndicativePrice and IndicativeVolume are what the
+// auction would uncross at right now. BandLow and BandHigh are zero when the
+// book has no price band.
+type MarketState struct {
+	Status           TradingStatus `json:"status"`
+	HaltedUntil      int64         `json:"haltedUntil,omitempty"`
+	AuctionEnd       int64         `json:"auctionEnd,omitempty"`
+	IndicativePrice  Decimal       `json:"indicativePrice"`
+	IndicativeVolume Decimal       `json:"indicativeVolume"`
+	ReferencePrice   Decimal       `json:"referencePrice"`
+	BandLow          Decimal       `json:"bandLow"`
+	BandHigh         Decimal       `json:"bandHigh"`
+}
+
+// WithPriceBand rejects orders priced outside the band and stops market
+// orders from filling outside it.
+func WithPriceBand(band PriceBand) Option {
+	return func(ob *Orderbook) {
+		ob.band = &band
+	}
+}
+
+// WithCircuitBreaker halts the book when the price moves too fast.
+func WithCircuitBreaker(breaker CircuitBreaker) Option {
+	return func(ob *Orderbook) {
+		ob.breaker = &breaker
+	}
+}
+
+// State returns the trading state of the book.
+func (ob *Orderbook) State() MarketState {
+	// not a read lock, the reference price drops the trades that left the
+	// band's window
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	state := MarketState{Status: StatusTrading}
+	switch {
+	case ob.auction:
+		state.Status = StatusAuction
+		state.AuctionEnd = ob.auctionEnd
+		state.IndicativePrice, state.IndicativeVolume = ob.clearingPrice()
+	case ob.halted(now):
+		state.Status = StatusHalted
+		state.HaltedUntil = ob.haltedUntil
+	}
+	if ob.band != nil {
+		state.ReferencePrice = ob.referencePrice(now)
+		state.BandLow, state.BandHigh = ob.bandLimits(now)
+	}
+	return state
+}
+
+func (ob *Orderbook) halted(now int64) bool {
+	return now < ob.haltedUntil
+}
+
+// halt halts the book with no end, see Engine.Do.
+func (ob *Orderbook) halt() {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.haltedUntil = math.MaxInt64
+}
+
+// referencePrice returns the price the band is centred on, or zero before
+// the first trade.
+func (ob *Orderbook) referencePrice(now int64) Decimal {
+	if ob.band.Window == 0 {
+		return ob.lastTradePrice
+	}
+
+	ob.bandTrades.evict(now - int64(ob.band.Window))
+	if ob.bandTrades.volume.IsZero() {
+		return ob.lastTradePrice
+	}
+	return ob.bandTrades.notional.Div(ob.bandTrades.volume)
+}
+
+// bandLimits returns the lowest and highest price the band allows, both
+// zero when there is no band yet.
+func (ob *Orderbook) bandLimits(now int64) (Decimal, Decimal) {
+	if ob.band == nil {
+		return Zero, Zero
+	}
+	ref := ob.referencePrice(now)
+	if ref.IsZero() {
+		return Zero, Zero
+	}
+	offset := ref.Mul(ob.band.Width)
+	return ref.Sub(offset), ref.Add(offset)
+}
+
+// checkBand rejects a limit price outside the band.
+func (ob *Orderbook) checkBand(price Decimal, now int64) error {
+	low, high := ob.bandLimits(now)
+	if high.IsZero() {
+		return nil
+	}
+	if price.LessThan(low) || price.GreaterThan(high) {
+		return ErrOutsidePriceBand
+	}
+	return nil
+}
+
+// bandGuard returns which limits a market order may take from without
+// leaving the band.
+func (ob *Orderbook) bandGuard(o *Order, now int64) func(*Limit) bool {
+	low, high := ob.bandLimits(now)
+	if high.IsZero() {
+		return func(*Limit) bool { return true }
+	}
+	if o.Bid {
+		return func(l *Limit) bool { return l.Price.LessThanOrEqual(high) }
+	}
+	return func(l *Limit) bool { return l.Price.GreaterThanOrEqual(low) }
+}
+
+// checkCircuitBreaker halts the book when the last trade price is more than
+// the breaker's MaxMove away from any price traded within its window. The
+// price furthest from it either way is the lowest or the highest traded.
+func (ob *Orderbook) checkCircuitBreaker(now int64) {
+	if ob.breaker == nil || len(ob.Trades) == 0 {
+		return
+	}
+
+	ob.breakerTrades.evict(now - int64(ob.breaker.Window))
+	if ob.breakerTrades.empty() {
+		return
+	}
+
+	last := ob.lastTradePrice
+	for _, price := range [2]Decimal{ob.breakerTrades.low(), ob.breakerTrades.high()} {
+		if last.Sub(price).Abs().GreaterThan(price.Mul(ob.breaker.MaxMove)) {
+			if ob.breaker.ReopenAuction {
+				ob.startAuction(now + int64(ob.breaker.Halt))
+			} else {
+				ob.haltedUntil = now + int64(ob.breaker.Halt)
+			}
+			return
+		}
+	}
+}
+
+// addWindowTrades adds new trades to the trade windows the book keeps: the
+// band's for its moving average and the breaker's for the prices it moved
+// from.
+func (ob *Orderbook) addWindowTrades(trades []*Trade) {
+	band, breaker := ob.band != nil && ob.band.Window > 0, ob.breaker != nil
+	for _, t := range trades {
+		if band {
+			ob.bandTrades.add(t)
+		}
+		if breaker {
+			ob.breakerTrades.add(t)
+		}
+	}
+}
+
+// resetWindowTrades refills the trade windows from the tape, after it was
+// replaced by a restored one.
+func (ob *Orderbook) resetWindowTrades() {
+	ob.bandTrades, ob.breakerTrades = tradeWindow{}, tradeWindow{}
+	ob.addWindowTrades(ob.Trades)
+}
+
+// tradeWindow holds the trades of a moving time window in time order. It
+// keeps the sums of their notional and size and, in two monotonic queues,
+// the trades that are or may become the lowest and the highest priced, so
+// none of them is ever looked at again once it was added.
+type tradeWindow struct {
+	trades   tradeQueue
+	notional Decimal
+	volume   Decimal
+	lows     tradeQueue
+	highs    tradeQueue
+}
+
+func (w *tradeWindow) add(t *Trade) {
+	w.trades.push(t)
+	w.notional = w.notional.Add(t.Price.Mul(t.Size))
+	w.volume = w.volume.Add(t.Size)
+	for !w.lows.empty() && w.lows.back().Price.GreaterThanOrEqual(t.Price) {
+		w.lows.popBack()
+	}
+	w.lows.push(t)
+	for !w.highs.empty() && w.highs.back().Price.LessThanOrEqual(t.Price) {
+		w.highs.popBack()
+	}
+	w.highs.push(t)
+}
+
+// evict drops the trades from before from.
+func (w *tradeWindow) evict(from int64) {
+	for !w.trades.empty() && w.trades.front().Timestamp < from {
+		t := w.trades.popFront()
+		w.notional = w.notional.Sub(t.Price.Mul(t.Size))
+		w.volume = w.volume.Sub(t.Size)
+	}
+	for !w.lows.empty() && w.lows.front().Timestamp < from {
+		w.lows.popFront()
+	}
+	for !w.highs.empty() && w.highs.front().Timestamp < from {
+		w.highs.popFront()
+	}
+}
+
+func (w *tradeWindow) empty() bool   { return w.trades.empty() }
+func (w *tradeWindow) low() Decimal  { return w.lows.front().Price }
+func (w *tradeWindow) high() Decimal { return w.highs.front().Price }
+
+// tradeQueue is a double ended queue of trades. Trades are taken from the
+// front by moving head, the space before it is reused once it is half of
+// the slice.
+type tradeQueue struct {
+	trades []*Trade
+	head   int
+}
+
+func (q *tradeQueue) empty() bool   { return q.head == len(q.trades) }
+func (q *tradeQueue) front() *Trade { return q.trades[q.head] }
+func (q *tradeQueue) back() *Trade  { return q.trades[len(q.trades)-1] }
+
+func (q *tradeQueue) push(t *Trade) {
+	if q.head > 0 && q.head >= len(q.trades)/2 {
+		n := copy(q.trades, q.trades[q.head:])
+		for i := n; i < len(q.trades); i++ {
+			q.trades[i] = nil
+		}
+		q.trades, q.head = q.trades[:n], 0
+	}
+	q.trades = append(q.trades, t)
+}
+
+func (q *tradeQueue) popBack() {
+	q.trades[len(q.trades)-1] = nil
+	q.trades = q.trades[:len(q.trades)-1]
+}
+
+func (q *tradeQueue) popFront() *Trade {
+	t := q.trades[q.head]
+	q.trades[q.head] = nil
+	q.head++
+	return t
+}
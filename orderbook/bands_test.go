@@ -0,0 +1,147 @@
+package orderbook
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestPriceBandRejectsLimitOrders(t *testing.T) {
+	ob := NewOrderbook(WithPriceBand(PriceBand{Width: dec(0.1)}))
+
+	// no band before the first trade
+	_, err := ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(1), 0))
+	assert(t, err, nil)
+	ob.PlaceMarketOrder(NewOrder(true, dec(1), 0))
+
+	_, err = ob.PlaceLimitOrder(dec(11_001), NewOrder(false, dec(1), 0))
+	assert(t, err, ErrOutsidePriceBand)
+	_, err = ob.PlaceLimitOrder(dec(8_999), NewOrder(true, dec(1), 0))
+	assert(t, err, ErrOutsidePriceBand)
+	_, err = ob.PlaceLimitOrder(dec(11_000), NewOrder(false, dec(1), 0))
+	assert(t, err, nil)
+
+	state := ob.State()
+	assert(t, state.ReferencePrice, dec(10_000))
+	assert(t, state.BandLow, dec(9_000))
+	assert(t, state.BandHigh, dec(11_000))
+}
+
+func TestPriceBandStopsMarketOrders(t *testing.T) {
+	ob := NewOrderbook(WithPriceBand(PriceBand{Width: dec(0.1)}))
+
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(1), 0))
+	ob.PlaceMarketOrder(NewOrder(true, dec(1), 0))
+
+	ob.PlaceLimitOrder(dec(10_500), NewOrder(false, dec(1), 0))
+	ob.PlaceLimitOrder(dec(11_000), NewOrder(false, dec(1), 0))
+	ob.band = nil
+	ob.PlaceLimitOrder(dec(20_000), NewOrder(false, dec(5), 0))
+	ob.band = &PriceBand{Width: dec(0.1)}
+
+	buyOrder := NewOrder(true, dec(4), 0)
+	_, err := ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, ErrOutsidePriceBand)
+
+	buyOrder.AllowPartialFill = true
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, buyOrder.Size, dec(2))
+	assert(t, ob.BestAsk().Price, dec(20_000))
+}
+
+func TestPriceBandMovingAverage(t *testing.T) {
+	ob := NewOrderbook(WithPriceBand(PriceBand{Width: dec(0.1), Window: time.Hour}))
+
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(3), 0))
+	ob.PlaceMarketOrder(NewOrder(true, dec(3), 0))
+	ob.PlaceLimitOrder(dec(10_400), NewOrder(false, dec(1), 0))
+	ob.PlaceMarketOrder(NewOrder(true, dec(1), 0))
+
+	assert(t, ob.State().ReferencePrice, dec(10_100))
+}
+
+func TestCircuitBreakerHaltsTrading(t *testing.T) {
+	ob := NewOrderbook(WithCircuitBreaker(CircuitBreaker{
+		MaxMove: dec(0.05),
+		Window:  time.Minute,
+		Halt:    time.Hour,
+	}))
+
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(1), 0))
+	ob.PlaceLimitOrder(dec(10_600), NewOrder(false, dec(1), 0))
+	stop := NewOrder(true, dec(1), 0)
+	stop.StopPrice = dec(10_500)
+	ob.PlaceStopOrder(stop)
+	ob.PlaceLimitOrder(dec(11_000), NewOrder(false, dec(1), 0))
+
+	_, err := ob.PlaceMarketOrder(NewOrder(true, dec(2), 0))
+	assert(t, err, nil)
+	assert(t, ob.State().Status, StatusHalted)
+	// the stop the move reached waits for the halt to end
+	assert(t, stop.IsStopPending(), true)
+
+	_, err = ob.PlaceLimitOrder(dec(11_000), NewOrder(true, dec(1), 0))
+	assert(t, err, ErrTradingHalted)
+	_, err = ob.PlaceMarketOrder(NewOrder(true, dec(1), 0))
+	assert(t, err, ErrTradingHalted)
+
+	// the matches a caller appends to are handed back
+	dst := make([]Match, 1, 4)
+	matches, err := ob.AppendLimitOrder(dst, dec(11_000), NewOrder(true, dec(1), 0))
+	assert(t, err, ErrTradingHalted)
+	assert(t, len(matches), 1)
+	matches, err = ob.AppendMarketOrder(dst, NewOrder(true, dec(1), 0))
+	assert(t, err, ErrTradingHalted)
+	assert(t, len(matches), 1)
+
+	ob.haltedUntil = time.Now().UnixNano()
+	assert(t, ob.State().Status, StatusTrading)
+	_, err = ob.PlaceLimitOrder(dec(9_000), NewOrder(true, dec(1), 0))
+	assert(t, err, nil)
+}
+
+// TestTradeWindow checks the running sums and the lowest and highest price
+// of a moving window against adding up the trades in it.
+func TestTradeWindow(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	var (
+		w      tradeWindow
+		trades []*Trade
+	)
+	for now := int64(0); now < 2_000; now++ {
+		for n := r.Intn(3); n > 0; n-- {
+			trade := &Trade{Price: RequireFromInt(int64(90 + r.Intn(20))), Size: RequireFromInt(int64(1 + r.Intn(5))), Timestamp: now}
+			trades = append(trades, trade)
+			w.add(trade)
+		}
+
+		from := now - int64(1+r.Intn(100))
+		w.evict(from)
+		for len(trades) > 0 && trades[0].Timestamp < from {
+			trades = trades[1:]
+		}
+
+		notional, volume := Zero, Zero
+		low, high := Zero, Zero
+		for i, trade := range trades {
+			notional = notional.Add(trade.Price.Mul(trade.Size))
+			volume = volume.Add(trade.Size)
+			if i == 0 || trade.Price.LessThan(low) {
+				low = trade.Price
+			}
+			if i == 0 || trade.Price.GreaterThan(high) {
+				high = trade.Price
+			}
+		}
+		assert(t, w.notional, notional)
+		assert(t, w.volume, volume)
+		assert(t, w.empty(), len(trades) == 0)
+		if len(trades) > 0 {
+			assert(t, w.low(), low)
+			assert(t, w.high(), high)
+		}
+	}
+}
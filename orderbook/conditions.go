@@ -0,0 +1,66 @@
+package orderbook
+
+import "errors"
+
+var (
+	ErrInvalidMinQty        = errors.New("minimum quantity must not be negative")
+	ErrAllOrNoneIceberg     = errors.New("all-or-none orders cannot have a display size")
+	ErrConditionsWouldCross = errors.New("order would rest crossing orders it cannot trade with for their or its own MinQty or all-or-none conditions")
+)
+
+// checkConditions validates the MinQty and AllOrNone conditions of o.
+func (o *Order) checkConditions() error {
+	if o.MinQty.IsNegative() {
+		return ErrInvalidMinQty
+	}
+	if o.AllOrNone && o.DisplaySize.IsPositive() {
+		return ErrAllOrNoneIceberg
+	}
+	return nil
+}
+
+// conditional reports whether o limits the size of its fills.
+func (o *Order) conditional() bool {
+	return o.AllOrNone || o.MinQty.IsPositive()
+}
+
+// fillAllowed reports whether the incoming order o and resting can trade
+// size with each other without breaking the conditions of either. An order
+// with less than its MinQty left takes the rest in one fill. A resting
+// all-or-none order only fills completely against one incoming order, an
+// incoming one can fill against several, see planLimitOrder.
+func fillAllowed(o, resting *Order, size Decimal) bool {
+	if size.LessThan(MinDecimal(o.MinQty, o.Size)) {
+		return false
+	}
+	if resting.AllOrNone {
+		return size.Equal(resting.Size)
+	}
+	return size.GreaterThanOrEqual(MinDecimal(resting.MinQty, resting.Size))
+}
+
+// planLimitOrder decides, before the book changes, whether the limit order
+// o can trade with the levels the acceptable func allows. It returns
+// ErrFillOrKill for a fill or kill order that would not fill completely and
+// ErrConditionsWouldCross when what is left of o would rest crossing orders
+// it passed over. It reports false when o must not trade at all, like an
+// all-or-none order that would not fill completely.
+func (ob *Orderbook) planLimitOrder(o *Order, acceptable func(*Limit) bool) (bool, error) {
+	volume, blocked := ob.planFill(o, acceptable)
+	short := volume.LessThan(o.Size)
+	immediate := o.TimeInForce == ImmediateOrCancel || o.TimeInForce == FillOrKill
+
+	switch {
+	case o.TimeInForce == FillOrKill && short:
+		return false, ErrFillOrKill
+	case o.AllOrNone && short:
+		// it does not trade at all, so it must not rest crossing anything
+		if best := ob.bestOpposite(o); !immediate && best != nil && acceptable(best) {
+			return false, ErrConditionsWouldCross
+		}
+		return false, nil
+	case blocked && !immediate:
+		return false, ErrConditionsWouldCross
+	}
+	return true, nil
+}
@@ -0,0 +1,218 @@
+package orderbook
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAllOrNoneRestingOrderPassedOver(t *testing.T) {
+	ob := NewOrderbook()
+
+	aon := NewOrder(false, dec(5), 1)
+	aon.AllOrNone = true
+	ob.PlaceLimitOrder(dec(100), aon)
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(2), 2))
+
+	// too small for the all-or-none order, the one behind it fills
+	matches, err := ob.PlaceLimitOrder(dec(100), NewOrder(true, dec(2), 3))
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Ask.UserID, int64(2))
+
+	// the all-or-none order kept its place and fills in one go
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(1), 2))
+	matches, err = ob.PlaceMarketOrder(NewOrder(true, dec(6), 3))
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, matches[0].Ask, aon)
+	assert(t, matches[0].SizeFilled, dec(5))
+	assert(t, ob.asks.Len(), 0)
+}
+
+func TestMinQtyRestingOrder(t *testing.T) {
+	ob := NewOrderbook()
+
+	minQty := NewOrder(false, dec(10), 1)
+	minQty.MinQty = dec(3)
+	ob.PlaceLimitOrder(dec(100), minQty)
+	ob.PlaceLimitOrder(dec(101), NewOrder(false, dec(5), 2))
+
+	matches, err := ob.PlaceMarketOrder(NewOrder(true, dec(2), 3))
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Price, dec(101))
+
+	matches, err = ob.PlaceMarketOrder(NewOrder(true, dec(8), 3))
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Ask, minQty)
+	assert(t, minQty.Size, dec(2))
+
+	// with less than its MinQty left it takes any fill of the rest
+	matches, err = ob.PlaceMarketOrder(NewOrder(true, dec(2), 3))
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, minQty.IsFilled(), true)
+}
+
+func TestMinQtyIncomingOrder(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(1), 1))
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(5), 2))
+
+	buyOrder := NewOrder(true, dec(4), 3)
+	buyOrder.MinQty = dec(2)
+	matches, err := ob.PlaceLimitOrder(dec(100), buyOrder)
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Ask.UserID, int64(2))
+	assert(t, matches[0].SizeFilled, dec(4))
+	assert(t, ob.AskTotalVolume(), dec(2))
+}
+
+func TestConditionsWouldCross(t *testing.T) {
+	ob := NewOrderbook()
+
+	aon := NewOrder(false, dec(10), 1)
+	aon.AllOrNone = true
+	ob.PlaceLimitOrder(dec(99), aon)
+
+	// resting at 100 would cross the all-or-none order
+	buyOrder := NewOrder(true, dec(1), 2)
+	matches, err := ob.PlaceLimitOrder(dec(100), buyOrder)
+	assert(t, err, ErrConditionsWouldCross)
+	assert(t, len(matches), 0)
+	assert(t, ob.Order(buyOrder.ID), (*Order)(nil))
+	assert(t, ob.AskTotalVolume(), dec(10))
+
+	// an immediate or cancel order just does not trade
+	ioc := NewOrder(true, dec(1), 2)
+	ioc.TimeInForce = ImmediateOrCancel
+	_, err = ob.PlaceLimitOrder(dec(100), ioc)
+	assert(t, err, nil)
+	assert(t, ob.AskTotalVolume(), dec(10))
+
+	// below it the order rests as usual
+	_, err = ob.PlaceLimitOrder(dec(98), NewOrder(true, dec(1), 2))
+	assert(t, err, nil)
+	assert(t, ob.BestBid().Price, dec(98))
+
+	// and amending it across is rejected, leaving it where it was
+	resting := ob.BestBid().Orders()[0]
+	_, err = ob.AmendOrder(resting, dec(99), dec(1))
+	assert(t, err, ErrConditionsWouldCross)
+	assert(t, resting.Limit.Price, dec(98))
+}
+
+func TestAllOrNoneIncomingOrder(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(5), 1))
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(5), 2))
+
+	// an incoming all-or-none order fills across several resting orders
+	buyOrder := NewOrder(true, dec(10), 3)
+	buyOrder.AllOrNone = true
+	matches, err := ob.PlaceLimitOrder(dec(100), buyOrder)
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, buyOrder.IsFilled(), true)
+
+	// but not partially
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(5), 1))
+	buyOrder = NewOrder(true, dec(10), 3)
+	buyOrder.AllOrNone = true
+	_, err = ob.PlaceLimitOrder(dec(100), buyOrder)
+	assert(t, err, ErrConditionsWouldCross)
+
+	buyOrder.TimeInForce = ImmediateOrCancel
+	matches, err = ob.PlaceLimitOrder(dec(100), buyOrder)
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+
+	marketOrder := NewOrder(true, dec(10), 3)
+	marketOrder.AllOrNone = true
+	marketOrder.AllowPartialFill = true
+	_, err = ob.PlaceMarketOrder(marketOrder)
+	assert(t, errors.Is(err, ErrInsufficientLiquidity), true)
+	assert(t, ob.AskTotalVolume(), dec(5))
+
+	// with nothing to cross it rests
+	buyOrder = NewOrder(true, dec(10), 3)
+	buyOrder.AllOrNone = true
+	_, err = ob.PlaceLimitOrder(dec(99), buyOrder)
+	assert(t, err, nil)
+	assert(t, ob.BestBid().Price, dec(99))
+}
+
+func TestConditionsFillOrKill(t *testing.T) {
+	ob := NewOrderbook()
+
+	aon := NewOrder(false, dec(5), 1)
+	aon.AllOrNone = true
+	ob.PlaceLimitOrder(dec(100), aon)
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(2), 2))
+
+	fok := NewOrder(true, dec(3), 3)
+	fok.TimeInForce = FillOrKill
+	_, err := ob.PlaceLimitOrder(dec(100), fok)
+	assert(t, err, ErrFillOrKill)
+
+	_, err = ob.PlaceMarketOrder(NewOrder(true, dec(3), 3))
+	assert(t, err != nil, true)
+	assert(t, ob.AskTotalVolume(), dec(7))
+}
+
+func TestConditionsProRata(t *testing.T) {
+	ob := NewOrderbook(WithMatchingPolicy(ProRata{Lot: dec(1)}))
+
+	aon := NewOrder(false, dec(4), 1)
+	aon.AllOrNone = true
+	ob.PlaceLimitOrder(dec(100), aon)
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(4), 2))
+
+	// half of 4 is too little for the all-or-none order, the other one
+	// takes it all
+	matches, err := ob.PlaceMarketOrder(NewOrder(true, dec(4), 3))
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Ask.UserID, int64(2))
+	assert(t, aon.Size, dec(4))
+}
+
+func TestConditionsFillOrKillProRata(t *testing.T) {
+	ob := NewOrderbook(WithMatchingPolicy(ProRata{Lot: dec(1)}))
+
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(5), 1))
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(5), 2))
+
+	// in time priority the first order would fill all 4, pro rata shares
+	// out 2 each, too little for the MinQty
+	fok := NewOrder(true, dec(4), 3)
+	fok.MinQty = dec(3)
+	fok.TimeInForce = FillOrKill
+	_, err := ob.PlaceLimitOrder(dec(100), fok)
+	assert(t, err, ErrFillOrKill)
+	assert(t, ob.AskTotalVolume(), dec(10))
+}
+
+func TestConditionsInvalid(t *testing.T) {
+	ob := NewOrderbook()
+
+	o := NewOrder(true, dec(1), 1)
+	o.MinQty = dec(-1)
+	_, err := ob.PlaceLimitOrder(dec(100), o)
+	assert(t, err, ErrInvalidMinQty)
+
+	o = NewOrder(true, dec(5), 1)
+	o.AllOrNone = true
+	o.DisplaySize = dec(1)
+	_, err = ob.PlaceLimitOrder(dec(100), o)
+	assert(t, err, ErrAllOrNoneIceberg)
+
+	o = NewOrder(true, dec(1), 1)
+	o.MinQty = dec(-1)
+	o.StopPrice = dec(110)
+	assert(t, ob.PlaceStopOrder(o), ErrInvalidMinQty)
+}
@@ -0,0 +1,356 @@
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// MaxDecimalPlaces is the number of fractional digits a Decimal can hold. It
+// is the precision the engine computes in, not that of any market: each
+// market quotes its prices and sizes at its own Scale of at most this many
+// places.
+const MaxDecimalPlaces = 8
+
+const decimalScale int64 = 100_000_000
+
+// Decimal is a fixed-point number stored as an integer count of 10^-8 units,
+// so adding and subtracting prices and sizes is always exact. The zero value
+// is 0. Decimals are comparable and can be used as map keys.
+type Decimal struct {
+	units int64
+}
+
+var Zero = Decimal{}
+
+// ErrDecimalOverflow is returned by the checked operations when the result
+// does not fit in a Decimal. The plain operations panic instead: sums and
+// products of values already in the book never get near the limit, values
+// straight from a request go through the checked ones.
+var ErrDecimalOverflow = errors.New("decimal overflow")
+
+// NewDecimalFromUnits returns the Decimal made of the given count of 10^-8
+// units.
+func NewDecimalFromUnits(units int64) Decimal {
+	return Decimal{units: units}
+}
+
+// NewDecimalFromInt returns i as a Decimal, or ErrDecimalOverflow if it is
+// too large to hold.
+func NewDecimalFromInt(i int64) (Decimal, error) {
+	units, ok := mulDiv(i, decimalScale, 1)
+	if !ok {
+		return Zero, ErrDecimalOverflow
+	}
+	return Decimal{units: units}, nil
+}
+
+// NewDecimalFromFloat rounds f to the nearest 10^-8. It returns
+// ErrDecimalOverflow if the result is too large to hold, or f is not a
+// number.
+func NewDecimalFromFloat(f float64) (Decimal, error) {
+	units := math.Round(f * float64(decimalScale))
+	// float64(math.MaxInt64) rounds up to 2^63, which does not fit
+	if math.IsNaN(units) || units >= math.MaxInt64 || units < math.MinInt64 {
+		return Zero, ErrDecimalOverflow
+	}
+	return Decimal{units: int64(units)}, nil
+}
+
+// NewDecimalFromString parses a plain decimal string like "-12.345". More than
+// MaxDecimalPlaces fractional digits is an error rather than being rounded away.
+func NewDecimalFromString(s string) (Decimal, error) {
+	str := s
+	neg := false
+	if strings.HasPrefix(str, "-") || strings.HasPrefix(str, "+") {
+		neg = str[0] == '-'
+		str = str[1:]
+	}
+
+	intPart, fracPart, hasDot := strings.Cut(str, ".")
+	if intPart == "" && fracPart == "" || hasDot && fracPart == "" {
+		return Zero, fmt.Errorf("invalid decimal %q", s)
+	}
+	if len(fracPart) > MaxDecimalPlaces {
+		return Zero, fmt.Errorf("invalid decimal %q: more than %d decimal places", s, MaxDecimalPlaces)
+	}
+	for _, r := range intPart + fracPart {
+		if r < '0' || r > '9' {
+			return Zero, fmt.Errorf("invalid decimal %q", s)
+		}
+	}
+
+	var units int64
+	if intPart != "" {
+		i, err := strconv.ParseInt(intPart, 10, 64)
+		if err != nil || i > math.MaxInt64/decimalScale {
+			return Zero, fmt.Errorf("invalid decimal %q: out of range", s)
+		}
+		units = i * decimalScale
+	}
+	if fracPart != "" {
+		frac, err := strconv.ParseInt(fracPart+strings.Repeat("0", MaxDecimalPlaces-len(fracPart)), 10, 64)
+		if err != nil {
+			return Zero, fmt.Errorf("invalid decimal %q", s)
+		}
+		// the fraction can still carry the whole number past the limit
+		if frac > math.MaxInt64-units {
+			return Zero, fmt.Errorf("invalid decimal %q: out of range", s)
+		}
+		units += frac
+	}
+
+	if neg {
+		units = -units
+	}
+	return Decimal{units: units}, nil
+}
+
+var ErrInvalidScale = fmt.Errorf("scale must be between 0 and %d decimal places", MaxDecimalPlaces)
+
+// Scale is the number of fractional digits a market quotes a price or a size
+// in, so its values are whole multiples of 10^-Scale. Decimals carry the
+// full MaxDecimalPlaces inside the engine, values coming in from a market
+// are checked against its scale at the edge.
+type Scale int
+
+// Validate returns ErrInvalidScale unless s is between 0 and
+// MaxDecimalPlaces.
+func (s Scale) Validate() error {
+	if s < 0 || s > MaxDecimalPlaces {
+		return ErrInvalidScale
+	}
+	return nil
+}
+
+// Step returns the smallest value at scale s, 10^-s.
+func (s Scale) Step() Decimal {
+	step := decimalScale
+	for i := Scale(0); i < s; i++ {
+		step /= 10
+	}
+	return Decimal{units: step}
+}
+
+// Fits reports whether d has no more than s fractional digits.
+func (s Scale) Fits(d Decimal) bool {
+	return d.units%s.Step().units == 0
+}
+
+// RequireFromString is like NewDecimalFromString but panics on error. It is
+// meant for constants in code and tests.
+func RequireFromString(s string) Decimal {
+	d, err := NewDecimalFromString(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// RequireFromInt is like NewDecimalFromInt but panics on error. It is meant
+// for constants in code and tests.
+func RequireFromInt(i int64) Decimal {
+	d, err := NewDecimalFromInt(i)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// RequireFromFloat is like NewDecimalFromFloat but panics on error. It is
+// meant for constants in code and tests.
+func RequireFromFloat(f float64) Decimal {
+	d, err := NewDecimalFromFloat(f)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// Units returns the value as a count of 10^-8 units.
+func (d Decimal) Units() int64 { return d.units }
+
+// Add returns d + o. It panics if the result does not fit.
+func (d Decimal) Add(o Decimal) Decimal {
+	sum := d.units + o.units
+	if (d.units^sum)&(o.units^sum) < 0 {
+		panic("orderbook: decimal overflow")
+	}
+	return Decimal{units: sum}
+}
+
+// Sub returns d - o. It panics if the result does not fit.
+func (d Decimal) Sub(o Decimal) Decimal {
+	return d.Add(o.Neg())
+}
+
+func (d Decimal) Neg() Decimal { return Decimal{units: -d.units} }
+
+// CheckedAdd is Add returning ErrDecimalOverflow instead of panicking.
+func (d Decimal) CheckedAdd(o Decimal) (Decimal, error) {
+	sum := d.units + o.units
+	// the sum of two numbers of the same sign has that sign too
+	if (d.units^sum)&(o.units^sum) < 0 {
+		return Zero, ErrDecimalOverflow
+	}
+	return Decimal{units: sum}, nil
+}
+
+func (d Decimal) Abs() Decimal {
+	if d.units < 0 {
+		return d.Neg()
+	}
+	return d
+}
+
+// Mul returns d * o truncated towards zero to 10^-8. It panics if the result
+// does not fit.
+func (d Decimal) Mul(o Decimal) Decimal {
+	product, err := d.CheckedMul(o)
+	if err != nil {
+		panic("orderbook: decimal overflow")
+	}
+	return product
+}
+
+// CheckedMul is Mul returning ErrDecimalOverflow instead of panicking.
+func (d Decimal) CheckedMul(o Decimal) (Decimal, error) {
+	units, ok := mulDiv(d.units, o.units, decimalScale)
+	if !ok {
+		return Zero, ErrDecimalOverflow
+	}
+	return Decimal{units: units}, nil
+}
+
+// Div returns d / o truncated towards zero to 10^-8. It panics if o is zero or
+// the result does not fit.
+func (d Decimal) Div(o Decimal) Decimal {
+	if o.units == 0 {
+		panic("orderbook: decimal division by zero")
+	}
+	units, ok := mulDiv(d.units, decimalScale, o.units)
+	if !ok {
+		panic("orderbook: decimal overflow")
+	}
+	return Decimal{units: units}
+}
+
+// mulDiv computes a*b/c with a 128 bit intermediate product. It reports false
+// when the result does not fit in an int64.
+func mulDiv(a, b, c int64) (int64, bool) {
+	neg := (a < 0) != (b < 0) != (c < 0)
+	hi, lo := bits.Mul64(abs64(a), abs64(b))
+	if hi >= abs64(c) {
+		return 0, false
+	}
+	q, _ := bits.Div64(hi, lo, abs64(c))
+	if q > math.MaxInt64 {
+		return 0, false
+	}
+	if neg {
+		return -int64(q), true
+	}
+	return int64(q), true
+}
+
+func abs64(i int64) uint64 {
+	if i < 0 {
+		return uint64(-i)
+	}
+	return uint64(i)
+}
+
+// Cmp returns -1, 0 or +1 depending on whether d is less than, equal to or
+// greater than o.
+func (d Decimal) Cmp(o Decimal) int {
+	switch {
+	case d.units < o.units:
+		return -1
+	case d.units > o.units:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (d Decimal) Equal(o Decimal) bool              { return d.units == o.units }
+func (d Decimal) LessThan(o Decimal) bool           { return d.units < o.units }
+func (d Decimal) LessThanOrEqual(o Decimal) bool    { return d.units <= o.units }
+func (d Decimal) GreaterThan(o Decimal) bool        { return d.units > o.units }
+func (d Decimal) GreaterThanOrEqual(o Decimal) bool { return d.units >= o.units }
+func (d Decimal) IsZero() bool                      { return d.units == 0 }
+func (d Decimal) IsPositive() bool                  { return d.units > 0 }
+func (d Decimal) IsNegative() bool                  { return d.units < 0 }
+
+func MinDecimal(a, b Decimal) Decimal {
+	if a.units < b.units {
+		return a
+	}
+	return b
+}
+
+func MaxDecimal(a, b Decimal) Decimal {
+	if a.units > b.units {
+		return a
+	}
+	return b
+}
+
+// Float64 returns the nearest float64, for display only.
+func (d Decimal) Float64() float64 {
+	return float64(d.units) / float64(decimalScale)
+}
+
+// BigInt returns d scaled by 10^decimals as an exact integer, truncating any
+// digits beyond that precision.
+func (d Decimal) BigInt(decimals int) *big.Int {
+	v := big.NewInt(d.units)
+	if decimals >= MaxDecimalPlaces {
+		exp := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals-MaxDecimalPlaces)), nil)
+		return v.Mul(v, exp)
+	}
+	exp := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(MaxDecimalPlaces-decimals)), nil)
+	return v.Quo(v, exp)
+}
+
+// String formats d without trailing fractional zeros, e.g. "10000" or "0.25".
+func (d Decimal) String() string {
+	u := abs64(d.units)
+	intPart := u / uint64(decimalScale)
+	fracPart := u % uint64(decimalScale)
+
+	s := strconv.FormatUint(intPart, 10)
+	if fracPart != 0 {
+		frac := fmt.Sprintf("%08d", fracPart)
+		s += "." + strings.TrimRight(frac, "0")
+	}
+	if d.units < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// MarshalJSON encodes d as a JSON number with its exact decimal digits.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON accepts either a JSON number or a quoted decimal string.
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	s = strings.Trim(s, `"`)
+
+	v, err := NewDecimalFromString(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
@@ -0,0 +1,137 @@
+package orderbook
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestNewDecimalFromString(t *testing.T) {
+	testCases := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "10000", want: 10_000 * decimalScale},
+		{in: "0.2", want: 20_000_000},
+		{in: "-1.5", want: -150_000_000},
+		{in: ".00000001", want: 1},
+		{in: "0.000000001", wantErr: true},
+		{in: "1e3", wantErr: true},
+		{in: "1.", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "99999999999999999999", wantErr: true},
+		{in: "92233720368.54775807", want: 1<<63 - 1},
+		{in: "92233720368.99999999", wantErr: true},
+		{in: "-92233720368.99999999", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := NewDecimalFromString(tc.in)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("NewDecimalFromString(%q) = %v; want error", tc.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert(t, got.Units(), tc.want)
+		})
+	}
+}
+
+func TestDecimalString(t *testing.T) {
+	assert(t, RequireFromString("10000").String(), "10000")
+	assert(t, RequireFromString("0.25").String(), "0.25")
+	assert(t, RequireFromString("-0.00000001").String(), "-0.00000001")
+	assert(t, Zero.String(), "0")
+}
+
+func TestDecimalMulDiv(t *testing.T) {
+	price := RequireFromString("10000.5")
+	size := RequireFromString("0.3")
+
+	assert(t, price.Mul(size), RequireFromString("3000.15"))
+	assert(t, price.Mul(size).Div(size), price)
+	// 10 / 3 truncates to 8 places
+	assert(t, dec(10).Div(dec(3)), RequireFromString("3.33333333"))
+	// would overflow int64 without the 128 bit intermediate product
+	assert(t, dec(1_000_000).Mul(dec(1_000)), dec(1_000_000_000))
+}
+
+func TestDecimalOverflow(t *testing.T) {
+	max := NewDecimalFromUnits(1<<63 - 1)
+
+	_, err := max.CheckedAdd(NewDecimalFromUnits(1))
+	assert(t, err, ErrDecimalOverflow)
+	_, err = max.Neg().CheckedAdd(NewDecimalFromUnits(-2))
+	assert(t, err, ErrDecimalOverflow)
+	_, err = dec(90_000_000_000).CheckedMul(dec(1000))
+	assert(t, err, ErrDecimalOverflow)
+
+	product, err := dec(1000).CheckedMul(dec(-2))
+	assert(t, err, nil)
+	assert(t, product, dec(-2000))
+
+	// 92233720368 is the largest whole number that fits
+	_, err = NewDecimalFromInt(92_233_720_369)
+	assert(t, err, ErrDecimalOverflow)
+	_, err = NewDecimalFromInt(-92_233_720_369)
+	assert(t, err, ErrDecimalOverflow)
+	_, err = NewDecimalFromFloat(1e11)
+	assert(t, err, ErrDecimalOverflow)
+	_, err = NewDecimalFromFloat(math.NaN())
+	assert(t, err, ErrDecimalOverflow)
+	d, err := NewDecimalFromInt(92_233_720_368)
+	assert(t, err, nil)
+	assert(t, d, RequireFromString("92233720368"))
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Add did not panic on overflow")
+		}
+	}()
+	max.Add(NewDecimalFromUnits(1))
+}
+
+func TestDecimalRepeatedFills(t *testing.T) {
+	size := dec(1)
+	for i := 0; i < 10; i++ {
+		size = size.Sub(RequireFromString("0.1"))
+	}
+	assert(t, size.IsZero(), true)
+}
+
+func TestDecimalJSON(t *testing.T) {
+	var v struct {
+		Price Decimal `json:"price"`
+		Size  Decimal `json:"size"`
+	}
+
+	if err := json.Unmarshal([]byte(`{"price": 10000.5, "size": "0.2"}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, v.Price, RequireFromString("10000.5"))
+	assert(t, v.Size, RequireFromString("0.2"))
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, string(b), `{"price":10000.5,"size":0.2}`)
+}
+
+func TestScale(t *testing.T) {
+	assert(t, Scale(2).Step(), RequireFromString("0.01"))
+	assert(t, Scale(0).Step(), dec(1))
+	assert(t, Scale(2).Fits(RequireFromString("10000.25")), true)
+	assert(t, Scale(2).Fits(RequireFromString("10000.255")), false)
+	assert(t, Scale(0).Fits(RequireFromString("-3")), true)
+
+	assert(t, Scale(MaxDecimalPlaces).Validate(), nil)
+	assert(t, Scale(MaxDecimalPlaces+1).Validate(), ErrInvalidScale)
+	assert(t, Scale(-1).Validate(), ErrInvalidScale)
+}
@@ -0,0 +1,66 @@
+package orderbook
+
+// DepthLevel is the visible size and number of orders at one price, or in
+// one price bucket of an aggregated book.
+type DepthLevel struct {
+	Price  Decimal `json:"price"`
+	Size   Decimal `json:"size"`
+	Orders int     `json:"orders"`
+}
+
+// Depth is the aggregated book, best price first on both sides.
+type Depth struct {
+	Bids []DepthLevel `json:"bids"`
+	Asks []DepthLevel `json:"asks"`
+}
+
+// Depth returns up to levels price levels on each side, all of them when
+// levels is zero. Hidden orders are left out. A positive group buckets
+// prices to multiples of group: bids round down and asks round up, so a
+// bucket never shows a better price than the orders in it.
+func (ob *Orderbook) Depth(levels int, group Decimal) Depth {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	return Depth{
+		Bids: aggregate(ob.bids, levels, group, true),
+		Asks: aggregate(ob.asks, levels, group, false),
+	}
+}
+
+func aggregate(pl *priceLevels, levels int, group Decimal, bid bool) []DepthLevel {
+	depth := []DepthLevel{}
+	pl.Each(func(l *Limit) bool {
+		visible := l.VisibleLen()
+		if visible == 0 {
+			return true
+		}
+		price := l.Price
+		if group.IsPositive() {
+			price = bucket(price, group, bid)
+		}
+
+		if n := len(depth); n > 0 && depth[n-1].Price.Equal(price) {
+			depth[n-1].Size = depth[n-1].Size.Add(l.TotalVolume)
+			depth[n-1].Orders += visible
+			return true
+		}
+		if levels > 0 && len(depth) == levels {
+			return false
+		}
+		depth = append(depth, DepthLevel{Price: price, Size: l.TotalVolume, Orders: visible})
+		return true
+	})
+	return depth
+}
+
+// bucket rounds price to a multiple of group, down for bids and up for
+// asks.
+func bucket(price, group Decimal, down bool) Decimal {
+	units, step := price.Units(), group.Units()
+	floor := units - units%step
+	if down || floor == units {
+		return NewDecimalFromUnits(floor)
+	}
+	return NewDecimalFromUnits(floor + step)
+}
@@ -0,0 +1,50 @@
+package orderbook
+
+import "testing"
+
+func TestDepth(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(dec(101), NewOrder(false, dec(1), 0))
+	ob.PlaceLimitOrder(dec(101), NewOrder(false, dec(2), 0))
+	ob.PlaceLimitOrder(dec(109), NewOrder(false, dec(3), 0))
+	ob.PlaceLimitOrder(dec(112), NewOrder(false, dec(4), 0))
+	ob.PlaceLimitOrder(dec(99), NewOrder(true, dec(1), 0))
+	ob.PlaceLimitOrder(dec(95), NewOrder(true, dec(2), 0))
+	ob.PlaceLimitOrder(dec(89.5), NewOrder(true, dec(5), 0))
+
+	depth := ob.Depth(0, Zero)
+	assert(t, depth.Asks, []DepthLevel{
+		{Price: dec(101), Size: dec(3), Orders: 2},
+		{Price: dec(109), Size: dec(3), Orders: 1},
+		{Price: dec(112), Size: dec(4), Orders: 1},
+	})
+	assert(t, len(depth.Bids), 3)
+
+	depth = ob.Depth(2, Zero)
+	assert(t, len(depth.Asks), 2)
+	assert(t, depth.Bids, []DepthLevel{
+		{Price: dec(99), Size: dec(1), Orders: 1},
+		{Price: dec(95), Size: dec(2), Orders: 1},
+	})
+
+	depth = ob.Depth(0, dec(10))
+	assert(t, depth.Asks, []DepthLevel{
+		{Price: dec(110), Size: dec(6), Orders: 3},
+		{Price: dec(120), Size: dec(4), Orders: 1},
+	})
+	assert(t, depth.Bids, []DepthLevel{
+		{Price: dec(90), Size: dec(3), Orders: 2},
+		{Price: dec(80), Size: dec(5), Orders: 1},
+	})
+
+	depth = ob.Depth(1, dec(10))
+	assert(t, depth.Asks, []DepthLevel{{Price: dec(110), Size: dec(6), Orders: 3}})
+}
+
+func TestDepthEmpty(t *testing.T) {
+	ob := NewOrderbook()
+	depth := ob.Depth(5, Zero)
+	assert(t, depth.Bids, []DepthLevel{})
+	assert(t, depth.Asks, []DepthLevel{})
+}
@@ -0,0 +1,183 @@
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrEngineStopped   = errors.New("engine stopped")
+	ErrCommandPanicked = errors.New("engine command panicked")
+)
+
+// command is one unit of work for an engine. done is closed once fn has run,
+// anything fn writes is visible to the sender after that, as is err.
+type command struct {
+	fn   func(*Orderbook)
+	err  error
+	done chan struct{}
+}
+
+// Engine drives an orderbook from a goroutine of its own. Places, cancels
+// and queries are sent to it as commands and run one at a time in the order
+// they arrive, so nothing touching the book can race with a match.
+//
+// Orders handed to the engine belong to it. Their fields change as they
+// trade, read them from a command rather than after it returns.
+type Engine struct {
+	ob       *Orderbook
+	commands chan *command
+	quit     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// NewEngine starts the goroutine driving ob. Call Stop to end it.
+func NewEngine(ob *Orderbook) *Engine {
+	e := &Engine{
+		ob:       ob,
+		commands: make(chan *command),
+		quit:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *Engine) run() {
+	defer close(e.stopped)
+	for {
+		// the channel is unbuffered, a command is only taken once the
+		// previous one is done and is never dropped by Stop
+		select {
+		case cmd := <-e.commands:
+			if cmd.run(e.ob) {
+				e.ob.halt()
+			}
+		case <-e.quit:
+			return
+		}
+	}
+}
+
+// run runs the command and reports whether fn panicked. The panic is
+// returned to the sender rather than taking the engine, and every market of
+// the process, down with it.
+func (cmd *command) run(ob *Orderbook) (panicked bool) {
+	defer close(cmd.done)
+	defer func() {
+		if r := recover(); r != nil {
+			cmd.err = fmt.Errorf("%w: %v", ErrCommandPanicked, r)
+			panicked = true
+		}
+	}()
+	cmd.fn(ob)
+	return false
+}
+
+// Do runs fn on the engine goroutine and waits for it to finish. fn has the
+// book to itself and must not call back into the engine. If fn panics, Do
+// returns an error wrapping ErrCommandPanicked and the engine halts the
+// market for good: whatever fn changed before it panicked stays changed, so
+// the book may be left part way through a match. Orders are then rejected
+// with ErrTradingHalted, cancels and queries still run, and the market
+// trades again once it is restored from a snapshot taken before the panic.
+func (e *Engine) Do(fn func(ob *Orderbook)) error {
+	cmd := &command{fn: fn, done: make(chan struct{})}
+	select {
+	case e.commands <- cmd:
+	case <-e.quit:
+		return ErrEngineStopped
+	}
+	<-cmd.done
+	return cmd.err
+}
+
+// Stop ends the engine goroutine once the command it is running is done.
+// Commands sent afterwards fail with ErrEngineStopped.
+func (e *Engine) Stop() {
+	e.stopOnce.Do(func() { close(e.quit) })
+	<-e.stopped
+}
+
+// PlaceLimitOrder places o on the engine, see Orderbook.PlaceLimitOrder.
+func (e *Engine) PlaceLimitOrder(price Decimal, o *Order) ([]Match, error) {
+	var (
+		matches []Match
+		err     error
+	)
+	if doErr := e.Do(func(ob *Orderbook) {
+		matches, err = ob.PlaceLimitOrder(price, o)
+	}); doErr != nil {
+		return nil, doErr
+	}
+	return matches, err
+}
+
+// PlaceMarketOrder places o on the engine, see Orderbook.PlaceMarketOrder.
+func (e *Engine) PlaceMarketOrder(o *Order) ([]Match, error) {
+	var (
+		matches []Match
+		err     error
+	)
+	if doErr := e.Do(func(ob *Orderbook) {
+		matches, err = ob.PlaceMarketOrder(o)
+	}); doErr != nil {
+		return nil, doErr
+	}
+	return matches, err
+}
+
+// PlaceStopOrder places o on the engine, see Orderbook.PlaceStopOrder.
+func (e *Engine) PlaceStopOrder(o *Order) error {
+	var err error
+	if doErr := e.Do(func(ob *Orderbook) {
+		err = ob.PlaceStopOrder(o)
+	}); doErr != nil {
+		return doErr
+	}
+	return err
+}
+
+// PlacePeggedOrder places o on the engine, see Orderbook.PlacePeggedOrder.
+func (e *Engine) PlacePeggedOrder(o *Order) error {
+	var err error
+	if doErr := e.Do(func(ob *Orderbook) {
+		err = ob.PlacePeggedOrder(o)
+	}); doErr != nil {
+		return doErr
+	}
+	return err
+}
+
+// CancelOrder cancels the resting or stop order with the given ID. It
+// reports whether there was one.
+func (e *Engine) CancelOrder(id int64) (bool, error) {
+	found := false
+	err := e.Do(func(ob *Orderbook) {
+		if o := ob.Order(id); o != nil {
+			ob.CancelOrder(o)
+			found = true
+		}
+	})
+	return found, err
+}
+
+// Depth returns the aggregated book, see Orderbook.Depth.
+func (e *Engine) Depth(levels int, group Decimal) (Depth, error) {
+	var depth Depth
+	err := e.Do(func(ob *Orderbook) {
+		depth = ob.Depth(levels, group)
+	})
+	return depth, err
+}
+
+// State returns the market's trading state, see Orderbook.State.
+func (e *Engine) State() (MarketState, error) {
+	var state MarketState
+	err := e.Do(func(ob *Orderbook) {
+		state = ob.State()
+	})
+	return state, err
+}
@@ -0,0 +1,154 @@
+package orderbook
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestEngineDo(t *testing.T) {
+	e := NewEngine(NewOrderbook())
+
+	sellOrder := NewOrder(false, dec(5), 1)
+	if _, err := e.PlaceLimitOrder(dec(10_000), sellOrder); err != nil {
+		t.Fatal(err)
+	}
+	matches, err := e.PlaceMarketOrder(NewOrder(true, dec(2), 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, len(matches), 1)
+
+	var size Decimal
+	e.Do(func(ob *Orderbook) {
+		size = ob.Order(sellOrder.ID).Size
+	})
+	assert(t, size, dec(3))
+
+	found, err := e.CancelOrder(sellOrder.ID)
+	assert(t, found, true)
+	assert(t, err, nil)
+	found, _ = e.CancelOrder(sellOrder.ID)
+	assert(t, found, false)
+
+	e.Stop()
+	assert(t, e.Do(func(*Orderbook) {}), ErrEngineStopped)
+	_, err = e.PlaceMarketOrder(NewOrder(true, dec(1), 2))
+	assert(t, err, ErrEngineStopped)
+}
+
+func TestEngineRecoversPanic(t *testing.T) {
+	e := NewEngine(NewOrderbook())
+	defer e.Stop()
+
+	err := e.Do(func(ob *Orderbook) {
+		dec(90_000_000_000).Mul(dec(1_000))
+	})
+	assert(t, errors.Is(err, ErrCommandPanicked), true)
+
+	// the engine carries on, but the book may be broken so the market stays
+	// halted
+	_, err = e.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(1), 1))
+	assert(t, err, ErrTradingHalted)
+	state, err := e.State()
+	assert(t, err, nil)
+	assert(t, state.Status, StatusHalted)
+}
+
+// checkBook fails t if ob is crossed or a level's total volume is not the
+// sum of its orders. It has to run on the engine.
+func checkBook(t *testing.T, ob *Orderbook) {
+	if bestBid, bestAsk := ob.BestBid(), ob.BestAsk(); bestBid != nil && bestAsk != nil && !bestBid.Price.LessThan(bestAsk.Price) {
+		t.Errorf("book crossed, best bid %s best ask %s", bestBid.Price, bestAsk.Price)
+	}
+	for _, limits := range [][]*Limit{ob.Bids(), ob.Asks()} {
+		for _, limit := range limits {
+			volume := Zero
+			for _, o := range limit.Orders() {
+				volume = volume.Add(o.Size)
+			}
+			if !volume.Equal(limit.TotalVolume) {
+				t.Errorf("level %s has total volume %s, orders add up to %s", limit.Price, limit.TotalVolume, volume)
+			}
+		}
+	}
+}
+
+// TestEngineConcurrentStress places, cancels, amends and queries from many
+// goroutines at once. Run it with -race.
+func TestEngineConcurrentStress(t *testing.T) {
+	e := NewEngine(NewOrderbook())
+	defer e.Stop()
+
+	const (
+		workers = 8
+		ops     = 500
+	)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			r := rand.New(rand.NewSource(int64(w)))
+			var placed []int64
+
+			for i := 0; i < ops; i++ {
+				switch r.Intn(6) {
+				case 0, 1:
+					o := NewOrder(r.Intn(2) == 0, RequireFromInt(int64(1+r.Intn(5))), int64(w))
+					if _, err := e.PlaceLimitOrder(RequireFromInt(int64(95+r.Intn(11))), o); err != nil {
+						t.Errorf("place limit order: %v", err)
+						continue
+					}
+					placed = append(placed, o.ID)
+				case 2:
+					o := NewOrder(r.Intn(2) == 0, RequireFromInt(int64(1+r.Intn(5))), int64(w))
+					o.AllowPartialFill = true
+					if _, err := e.PlaceMarketOrder(o); err != nil {
+						t.Errorf("place market order: %v", err)
+					}
+				case 3:
+					if len(placed) == 0 {
+						continue
+					}
+					if _, err := e.CancelOrder(placed[r.Intn(len(placed))]); err != nil {
+						t.Errorf("cancel order: %v", err)
+					}
+				case 4:
+					if len(placed) == 0 {
+						continue
+					}
+					id := placed[r.Intn(len(placed))]
+					e.Do(func(ob *Orderbook) {
+						o := ob.Order(id)
+						if o == nil {
+							return
+						}
+						if _, err := ob.AmendOrder(o, o.Limit.Price, o.Size.Add(dec(1))); err != nil {
+							t.Errorf("amend order: %v", err)
+						}
+					})
+				case 5:
+					if _, err := e.Depth(5, Zero); err != nil {
+						t.Errorf("depth: %v", err)
+					}
+					e.Do(func(ob *Orderbook) { checkBook(t, ob) })
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	e.Do(func(ob *Orderbook) {
+		checkBook(t, ob)
+
+		resting := Zero
+		for _, o := range ob.Orders {
+			resting = resting.Add(o.Size)
+		}
+		assert(t, ob.BidTotalVolume().Add(ob.AskTotalVolume()), resting)
+	})
+}
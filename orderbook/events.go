@@ -0,0 +1,220 @@
+package orderbook
+
+// Event is something that happened to an orderbook. Listeners receive one of
+// the event types below, in the order the changes were made. Events carry
+// copies of the values at the time, not the orders themselves.
+type Event interface {
+	event()
+}
+
+// OrderAccepted is published when an order passes validation and enters
+// the book, before any of its fills. Price is zero for market orders. A stop
+// order is accepted when it is placed and again when it triggers.
+type OrderAccepted struct {
+	OrderID   int64
+	UserID    int64
+	Bid       bool
+	Price     Decimal
+	Size      Decimal
+	StopPrice Decimal
+}
+
+// CancelReason says why an order, or part of it, left the book without
+// trading.
+type CancelReason string
+
+const (
+	// CancelRequested is a cancel asked for by the user.
+	CancelRequested CancelReason = "CANCELED"
+	// CancelExpired is a good til date order reaching its expiry.
+	CancelExpired CancelReason = "EXPIRED"
+	// CancelSelfTrade is size taken off by self-trade prevention.
+	CancelSelfTrade CancelReason = "SELF_TRADE"
+	// CancelUnfilled is what an immediate or cancel or market order could
+	// not fill on arrival.
+	CancelUnfilled CancelReason = "UNFILLED"
+	// CancelAmended is size taken off by an amend. An amend that moves the
+	// order cancels all of it and accepts it again under the same ID.
+	CancelAmended CancelReason = "AMENDED"
+	// CancelRejected is a triggered stop order that could not be placed.
+	CancelRejected CancelReason = "REJECTED"
+	// CancelWouldCross is an order an auction left out for its MinQty or
+	// AllOrNone condition that would have rested crossing the book after
+	// it.
+	CancelWouldCross CancelReason = "WOULD_CROSS"
+)
+
+// OrderCanceled is published when Size is taken off an order without a
+// trade. The order is still in the book when Remaining is positive.
+type OrderCanceled struct {
+	OrderID   int64
+	UserID    int64
+	Bid       bool
+	Size      Decimal
+	Remaining Decimal
+	Reason    CancelReason
+}
+
+// OrderPartiallyFilled is published when a trade fills part of an order.
+// Remaining includes the reserve of an iceberg order.
+type OrderPartiallyFilled struct {
+	OrderID   int64
+	UserID    int64
+	Bid       bool
+	Price     Decimal
+	Size      Decimal
+	Remaining Decimal
+}
+
+// OrderFilled is published when a trade fills the rest of an order.
+type OrderFilled struct {
+	OrderID int64
+	UserID  int64
+	Bid     bool
+	Price   Decimal
+	Size    Decimal
+}
+
+// TradeExecuted is published for every trade, before the fills of the two
+// orders in it.
+type TradeExecuted struct {
+	Trade Trade
+}
+
+// LevelChanged is published when the visible volume or number of orders at
+// a price changes. An empty level has been removed from the book.
+type LevelChanged struct {
+	Bid         bool
+	Price       Decimal
+	TotalVolume Decimal
+	Orders      int
+}
+
+func (OrderAccepted) event()        {}
+func (OrderCanceled) event()        {}
+func (OrderPartiallyFilled) event() {}
+func (OrderFilled) event()          {}
+func (TradeExecuted) event()        {}
+func (LevelChanged) event()         {}
+
+// Listener receives the events of an orderbook. OnEvent is called while the
+// orderbook is locked, so it must not call back into the orderbook and
+// should hand slow work off to another goroutine.
+type Listener interface {
+	OnEvent(e Event)
+}
+
+// ListenerFunc lets an ordinary function be used as a Listener.
+type ListenerFunc func(e Event)
+
+func (f ListenerFunc) OnEvent(e Event) {
+	f(e)
+}
+
+// AddListener registers l for every event published from now on.
+func (ob *Orderbook) AddListener(l Listener) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.listeners = append(ob.listeners, l)
+}
+
+func (ob *Orderbook) publish(e Event) {
+	for _, l := range ob.listeners {
+		l.OnEvent(e)
+	}
+}
+
+func (ob *Orderbook) publishAccepted(price Decimal, o *Order) {
+	if len(ob.listeners) == 0 {
+		return
+	}
+	ob.publish(OrderAccepted{
+		OrderID:   o.ID,
+		UserID:    o.UserID,
+		Bid:       o.Bid,
+		Price:     price,
+		Size:      o.Size.Add(o.reserve),
+		StopPrice: o.StopPrice,
+	})
+}
+
+func (ob *Orderbook) publishCanceled(o *Order, size, remaining Decimal, reason CancelReason) {
+	if len(ob.listeners) == 0 {
+		return
+	}
+	ob.publish(OrderCanceled{
+		OrderID:   o.ID,
+		UserID:    o.UserID,
+		Bid:       o.Bid,
+		Size:      size,
+		Remaining: remaining,
+		Reason:    reason,
+	})
+}
+
+func (ob *Orderbook) publishLevel(bid bool, l *Limit) {
+	if len(ob.listeners) == 0 {
+		return
+	}
+	ob.publish(LevelChanged{
+		Bid:         bid,
+		Price:       l.Price,
+		TotalVolume: l.TotalVolume,
+		Orders:      l.VisibleLen(),
+	})
+}
+
+func (ob *Orderbook) publishFill(o *Order, price, size, remaining Decimal) {
+	if remaining.IsZero() {
+		ob.publish(OrderFilled{
+			OrderID: o.ID,
+			UserID:  o.UserID,
+			Bid:     o.Bid,
+			Price:   price,
+			Size:    size,
+		})
+		return
+	}
+	ob.publish(OrderPartiallyFilled{
+		OrderID:   o.ID,
+		UserID:    o.UserID,
+		Bid:       o.Bid,
+		Price:     price,
+		Size:      size,
+		Remaining: remaining,
+	})
+}
+
+// executed records the trade of the incoming order o against resting and
+// publishes it, followed by the fills of both orders.
+func (l *Limit) executed(o, resting *Order, match Match) {
+	ob := l.ob
+	if ob == nil {
+		return
+	}
+
+	trade := ob.recordTrade(o.Bid, match)
+	if len(ob.listeners) == 0 {
+		return
+	}
+	ob.publish(TradeExecuted{Trade: *trade})
+	ob.publishFill(resting, match.Price, match.SizeFilled, resting.Size.Add(resting.reserve))
+	ob.publishFill(o, match.Price, match.SizeFilled, o.Size)
+}
+
+// publishSelfTrade publishes the self-trade prevention cancels o just made.
+// canceledResting says whether the resting order was cancelled outright.
+func (ob *Orderbook) publishSelfTrade(o *Order, cancels []SelfTradeCancel, canceledResting bool) {
+	if len(ob.listeners) == 0 {
+		return
+	}
+
+	for _, canceled := range cancels {
+		remaining := canceled.Order.Size.Add(canceled.Order.reserve)
+		if canceled.Order == o && o.selfTradeCanceled || canceled.Order != o && canceledResting {
+			remaining = Zero
+		}
+		ob.publishCanceled(canceled.Order, canceled.Size, remaining, CancelSelfTrade)
+	}
+}
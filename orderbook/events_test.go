@@ -0,0 +1,141 @@
+package orderbook
+
+import (
+	"fmt"
+	"testing"
+)
+
+func recordEvents(ob *Orderbook) *[]Event {
+	var events []Event
+	ob.AddListener(ListenerFunc(func(e Event) {
+		events = append(events, e)
+	}))
+	return &events
+}
+
+func eventTypes(events []Event) []string {
+	types := make([]string, len(events))
+	for i, e := range events {
+		types[i] = fmt.Sprintf("%T", e)
+	}
+	return types
+}
+
+func TestEventsPlaceFillCancel(t *testing.T) {
+	ob := NewOrderbook()
+	events := recordEvents(ob)
+
+	sellOrder := NewOrder(false, dec(5), 1)
+	ob.PlaceLimitOrder(dec(10_000), sellOrder)
+	assert(t, *events, []Event{
+		OrderAccepted{OrderID: sellOrder.ID, UserID: 1, Price: dec(10_000), Size: dec(5)},
+		LevelChanged{Price: dec(10_000), TotalVolume: dec(5), Orders: 1},
+	})
+
+	*events = nil
+	buyOrder := NewOrder(true, dec(2), 2)
+	ob.PlaceMarketOrder(buyOrder)
+	assert(t, eventTypes(*events), []string{
+		"orderbook.OrderAccepted",
+		"orderbook.TradeExecuted",
+		"orderbook.OrderPartiallyFilled",
+		"orderbook.OrderFilled",
+		"orderbook.LevelChanged",
+	})
+	assert(t, (*events)[1].(TradeExecuted).Trade, *ob.Trades[0])
+	assert(t, (*events)[2], Event(OrderPartiallyFilled{OrderID: sellOrder.ID, UserID: 1, Price: dec(10_000), Size: dec(2), Remaining: dec(3)}))
+	assert(t, (*events)[3], Event(OrderFilled{OrderID: buyOrder.ID, UserID: 2, Bid: true, Price: dec(10_000), Size: dec(2)}))
+	assert(t, (*events)[4], Event(LevelChanged{Price: dec(10_000), TotalVolume: dec(3), Orders: 1}))
+
+	*events = nil
+	ob.CancelOrder(sellOrder)
+	assert(t, *events, []Event{
+		OrderCanceled{OrderID: sellOrder.ID, UserID: 1, Size: dec(3), Reason: CancelRequested},
+		LevelChanged{Price: dec(10_000)},
+	})
+}
+
+func TestEventsImmediateOrCancelRemainder(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(1), 1))
+	events := recordEvents(ob)
+
+	buyOrder := NewOrder(true, dec(3), 2)
+	buyOrder.TimeInForce = ImmediateOrCancel
+	ob.PlaceLimitOrder(dec(10_000), buyOrder)
+
+	assert(t, eventTypes(*events), []string{
+		"orderbook.OrderAccepted",
+		"orderbook.TradeExecuted",
+		"orderbook.OrderFilled",
+		"orderbook.OrderPartiallyFilled",
+		"orderbook.LevelChanged",
+		"orderbook.OrderCanceled",
+	})
+	assert(t, (*events)[5], Event(OrderCanceled{OrderID: buyOrder.ID, UserID: 2, Bid: true, Size: dec(2), Reason: CancelUnfilled}))
+}
+
+func TestEventsIcebergRefills(t *testing.T) {
+	ob := NewOrderbook()
+
+	iceberg := NewOrder(false, dec(5), 1)
+	iceberg.DisplaySize = dec(2)
+	ob.PlaceLimitOrder(dec(10_000), iceberg)
+	events := recordEvents(ob)
+
+	ob.PlaceMarketOrder(NewOrder(true, dec(4), 2))
+
+	var remaining []Decimal
+	for _, e := range *events {
+		if fill, ok := e.(OrderPartiallyFilled); ok && fill.OrderID == iceberg.ID {
+			remaining = append(remaining, fill.Remaining)
+		}
+	}
+	assert(t, remaining, []Decimal{dec(3), dec(1)})
+	assert(t, (*events)[len(*events)-1], Event(LevelChanged{Price: dec(10_000), TotalVolume: dec(1), Orders: 1}))
+}
+
+func TestEventsStopRejected(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(1), 1))
+	ob.PlaceLimitOrder(dec(10_100), NewOrder(false, dec(1), 1))
+
+	stop := NewOrder(true, dec(5), 2)
+	stop.StopPrice = dec(10_000)
+	ob.PlaceStopOrder(stop)
+	events := recordEvents(ob)
+
+	ob.PlaceMarketOrder(NewOrder(true, dec(1), 3))
+
+	assert(t, (*events)[len(*events)-1], Event(OrderCanceled{OrderID: stop.ID, UserID: 2, Bid: true, Size: dec(5), Reason: CancelRejected}))
+}
+
+func TestEventsSweepInterleaved(t *testing.T) {
+	ob := NewOrderbook()
+	own := NewOrder(false, dec(1), 1)
+	other := NewOrder(false, dec(2), 2)
+	next := NewOrder(false, dec(1), 3)
+	ob.PlaceLimitOrder(dec(10_000), own)
+	ob.PlaceLimitOrder(dec(10_000), other)
+	ob.PlaceLimitOrder(dec(10_100), next)
+	events := recordEvents(ob)
+
+	buyOrder := NewOrder(true, dec(3), 1)
+	buyOrder.SelfTradePrevention = CancelOldest
+	ob.PlaceLimitOrder(dec(10_100), buyOrder)
+
+	// the cancel of the order ahead in the queue comes before the trade
+	// behind it, and each level changes once its fills are done
+	assert(t, *events, []Event{
+		OrderAccepted{OrderID: buyOrder.ID, UserID: 1, Bid: true, Price: dec(10_100), Size: dec(3)},
+		OrderCanceled{OrderID: own.ID, UserID: 1, Size: dec(1), Reason: CancelSelfTrade},
+		TradeExecuted{Trade: *ob.Trades[0]},
+		OrderFilled{OrderID: other.ID, UserID: 2, Price: dec(10_000), Size: dec(2)},
+		OrderPartiallyFilled{OrderID: buyOrder.ID, UserID: 1, Bid: true, Price: dec(10_000), Size: dec(2), Remaining: dec(1)},
+		LevelChanged{Price: dec(10_000)},
+		TradeExecuted{Trade: *ob.Trades[1]},
+		OrderFilled{OrderID: next.ID, UserID: 3, Price: dec(10_100), Size: dec(1)},
+		OrderFilled{OrderID: buyOrder.ID, UserID: 1, Bid: true, Price: dec(10_100), Size: dec(1)},
+		LevelChanged{Price: dec(10_100)},
+	})
+}
@@ -0,0 +1,5 @@
+package orderbook
+
+import "errors"
+
+var ErrHiddenIceberg = errors.New("hidden orders cannot have a display size")
@@ -0,0 +1,73 @@
+package orderbook
+
+import "testing"
+
+func TestHiddenOrderMatches(t *testing.T) {
+	ob := NewOrderbook()
+
+	hidden := NewOrder(false, dec(3), 1)
+	hidden.Hidden = true
+	ob.PlaceLimitOrder(dec(100), hidden)
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(2), 2))
+
+	// time priority at the price, hidden or not
+	matches, err := ob.PlaceMarketOrder(NewOrder(true, dec(4), 3))
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, matches[0].Ask, hidden)
+	assert(t, matches[0].SizeFilled, dec(3))
+	assert(t, matches[1].SizeFilled, dec(1))
+	assert(t, ob.AskTotalVolume(), dec(1))
+}
+
+func TestHiddenOrderNotVisible(t *testing.T) {
+	ob := NewOrderbook()
+
+	var levels []LevelChanged
+	ob.AddListener(ListenerFunc(func(e Event) {
+		if l, ok := e.(LevelChanged); ok {
+			levels = append(levels, l)
+		}
+	}))
+
+	hidden := NewOrder(false, dec(3), 1)
+	hidden.Hidden = true
+	ob.PlaceLimitOrder(dec(99), hidden)
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(2), 2))
+	behind := NewOrder(false, dec(1), 1)
+	behind.Hidden = true
+	ob.PlaceLimitOrder(dec(100), behind)
+
+	assert(t, ob.AskTotalVolume(), dec(2))
+	assert(t, ob.BestAsk().Price, dec(100))
+	assert(t, len(levels), 1)
+	assert(t, levels[0].Orders, 1)
+
+	depth := ob.Depth(0, Zero)
+	assert(t, len(depth.Asks), 1)
+	assert(t, depth.Asks[0], DepthLevel{Price: dec(100), Size: dec(2), Orders: 1})
+
+	// the hidden volume still counts for fill or kill
+	fok := NewOrder(true, dec(6), 3)
+	fok.TimeInForce = FillOrKill
+	matches, err := ob.PlaceLimitOrder(dec(100), fok)
+	assert(t, err, nil)
+	assert(t, len(matches), 3)
+	assert(t, ob.asks.Len(), 0)
+
+	// the level of hidden orders only never shows, not even as it clears
+	assert(t, levels, []LevelChanged{
+		{Price: dec(100), TotalVolume: dec(2), Orders: 1},
+		{Price: dec(100)},
+	})
+}
+
+func TestHiddenIcebergRejected(t *testing.T) {
+	ob := NewOrderbook()
+
+	o := NewOrder(true, dec(5), 1)
+	o.Hidden = true
+	o.DisplaySize = dec(1)
+	_, err := ob.PlaceLimitOrder(dec(100), o)
+	assert(t, err, ErrHiddenIceberg)
+}
@@ -0,0 +1,180 @@
+package orderbook
+
+const maxLevelHeight = 24
+
+// priceLevels keeps the limits of one side of the book ordered best price
+// first. It is a skip list, so the best limit is always at the front and
+// inserting or removing a limit takes O(log n) on average.
+type priceLevels struct {
+	head   levelNode
+	height int
+	length int
+	// better reports whether price a should come before price b
+	better func(a, b Decimal) bool
+	seed   uint64
+	// free holds removed nodes for Insert to reuse
+	free []*levelNode
+	// visible holds the limits of the side that have a visible order, in
+	// the same order, so the best visible price is at its front too. It is
+	// nil for the visible list itself.
+	visible *priceLevels
+}
+
+type levelNode struct {
+	limit *Limit
+	next  []*levelNode
+}
+
+func newAskLevels() *priceLevels {
+	return newSideLevels(func(a, b Decimal) bool { return a.LessThan(b) })
+}
+
+func newBidLevels() *priceLevels {
+	return newSideLevels(func(a, b Decimal) bool { return a.GreaterThan(b) })
+}
+
+// newSideLevels returns the levels of one side of the book along with their
+// visible list.
+func newSideLevels(better func(a, b Decimal) bool) *priceLevels {
+	pl := newPriceLevels(better)
+	pl.visible = newPriceLevels(better)
+	return pl
+}
+
+func newPriceLevels(better func(a, b Decimal) bool) *priceLevels {
+	return &priceLevels{
+		head:   levelNode{next: make([]*levelNode, maxLevelHeight)},
+		height: 1,
+		better: better,
+		seed:   0x9e3779b97f4a7c15,
+	}
+}
+
+func (pl *priceLevels) Len() int {
+	return pl.length
+}
+
+// Best returns the limit with the best price or nil when the side is empty.
+func (pl *priceLevels) Best() *Limit {
+	if n := pl.head.next[0]; n != nil {
+		return n.limit
+	}
+	return nil
+}
+
+// BestVisible returns the limit with the best price that has a visible
+// order, or nil when there is none.
+func (pl *priceLevels) BestVisible() *Limit {
+	return pl.visible.Best()
+}
+
+// randomHeight picks a node height with a 1/4 chance of each extra level.
+func (pl *priceLevels) randomHeight() int {
+	// xorshift64, deterministic so the shape of the list is reproducible
+	pl.seed ^= pl.seed << 13
+	pl.seed ^= pl.seed >> 7
+	pl.seed ^= pl.seed << 17
+
+	h := 1
+	for r := pl.seed; h < maxLevelHeight && r&3 == 0; r >>= 2 {
+		h++
+	}
+	return h
+}
+
+// findPrev fills prev with the last node on each level whose price is better
+// than price.
+func (pl *priceLevels) findPrev(price Decimal, prev []*levelNode) {
+	n := &pl.head
+	for i := pl.height - 1; i >= 0; i-- {
+		for n.next[i] != nil && pl.better(n.next[i].limit.Price, price) {
+			n = n.next[i]
+		}
+		prev[i] = n
+	}
+}
+
+// Insert adds a limit. The caller makes sure no limit with the same price is
+// already in the list.
+func (pl *priceLevels) Insert(l *Limit) {
+	var prev [maxLevelHeight]*levelNode
+	pl.findPrev(l.Price, prev[:])
+
+	h := pl.randomHeight()
+	for i := pl.height; i < h; i++ {
+		prev[i] = &pl.head
+	}
+	if h > pl.height {
+		pl.height = h
+	}
+
+	n := pl.newNode(l, h)
+	for i := 0; i < h; i++ {
+		n.next[i] = prev[i].next[i]
+		prev[i].next[i] = n
+	}
+	pl.length++
+}
+
+// Remove deletes the limit and reports whether it was found.
+func (pl *priceLevels) Remove(l *Limit) bool {
+	var prev [maxLevelHeight]*levelNode
+	pl.findPrev(l.Price, prev[:])
+
+	n := prev[0].next[0]
+	if n == nil || n.limit != l {
+		return false
+	}
+
+	for i := 0; i < len(n.next); i++ {
+		prev[i].next[i] = n.next[i]
+	}
+	for pl.height > 1 && pl.head.next[pl.height-1] == nil {
+		pl.height--
+	}
+	pl.length--
+
+	n.limit = nil
+	pl.free = append(pl.free, n)
+	return true
+}
+
+// newNode returns a node of height h for l, reusing the last removed one
+// when it is tall enough. One that is too short is dropped.
+func (pl *priceLevels) newNode(l *Limit, h int) *levelNode {
+	if n := len(pl.free); n > 0 {
+		node := pl.free[n-1]
+		pl.free[n-1] = nil
+		pl.free = pl.free[:n-1]
+		if cap(node.next) < h {
+			return &levelNode{limit: l, next: make([]*levelNode, h)}
+		}
+
+		node.limit = l
+		node.next = node.next[:h]
+		for i := range node.next {
+			node.next[i] = nil
+		}
+		return node
+	}
+	return &levelNode{limit: l, next: make([]*levelNode, h)}
+}
+
+// Each calls fn for every limit, best price first, until fn returns false.
+func (pl *priceLevels) Each(fn func(*Limit) bool) {
+	for n := pl.head.next[0]; n != nil; n = n.next[0] {
+		if !fn(n.limit) {
+			return
+		}
+	}
+}
+
+// Limits returns every limit, best price first.
+func (pl *priceLevels) Limits() []*Limit {
+	limits := make([]*Limit, 0, pl.length)
+	pl.Each(func(l *Limit) bool {
+		limits = append(limits, l)
+		return true
+	})
+	return limits
+}
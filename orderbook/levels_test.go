@@ -0,0 +1,176 @@
+package orderbook
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestPriceLevelsOrdering(t *testing.T) {
+	asks := newAskLevels()
+	bids := newBidLevels()
+
+	prices := []float64{10_000, 9_000, 11_000, 9_500, 10_500}
+	for _, p := range prices {
+		asks.Insert(NewLimit(dec(p)))
+		bids.Insert(NewLimit(dec(p)))
+	}
+
+	assert(t, asks.Len(), 5)
+	assert(t, asks.Best().Price, dec(9_000))
+	assert(t, bids.Best().Price, dec(11_000))
+
+	var askPrices, bidPrices []Decimal
+	for _, l := range asks.Limits() {
+		askPrices = append(askPrices, l.Price)
+	}
+	for _, l := range bids.Limits() {
+		bidPrices = append(bidPrices, l.Price)
+	}
+	assert(t, askPrices, []Decimal{dec(9_000), dec(9_500), dec(10_000), dec(10_500), dec(11_000)})
+	assert(t, bidPrices, []Decimal{dec(11_000), dec(10_500), dec(10_000), dec(9_500), dec(9_000)})
+}
+
+func TestPriceLevelsRemove(t *testing.T) {
+	asks := newAskLevels()
+	limits := map[float64]*Limit{}
+	for _, p := range []float64{3, 1, 2} {
+		limits[p] = NewLimit(dec(p))
+		asks.Insert(limits[p])
+	}
+
+	assert(t, asks.Remove(limits[1]), true)
+	assert(t, asks.Remove(limits[1]), false)
+	// a different limit at a price that is in the list
+	assert(t, asks.Remove(NewLimit(dec(2))), false)
+
+	assert(t, asks.Len(), 2)
+	assert(t, asks.Best(), limits[2])
+
+	asks.Remove(limits[2])
+	asks.Remove(limits[3])
+	assert(t, asks.Len(), 0)
+	assert(t, asks.Best() == nil, true)
+}
+
+func TestPriceLevelsRandomized(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	asks := newAskLevels()
+	live := map[int64]*Limit{}
+
+	for i := 0; i < 5_000; i++ {
+		price := int64(r.Intn(500))
+		if l, ok := live[price]; ok {
+			assert(t, asks.Remove(l), true)
+			delete(live, price)
+		} else {
+			live[price] = NewLimit(RequireFromInt(price))
+			asks.Insert(live[price])
+		}
+	}
+
+	limits := asks.Limits()
+	assert(t, len(limits), len(live))
+	assert(t, asks.Len(), len(live))
+	for i := 1; i < len(limits); i++ {
+		if !limits[i-1].Price.LessThan(limits[i].Price) {
+			t.Fatalf("asks out of order at %d: %s >= %s", i, limits[i-1].Price, limits[i].Price)
+		}
+	}
+}
+
+// sortedLimits is how the book used to keep its levels: an unordered slice
+// with swap removal that is sorted every time the best price is needed. It is
+// kept here as the baseline for the benchmarks.
+type sortedLimits struct{ Limits }
+
+func (s *sortedLimits) insert(l *Limit) { s.Limits = append(s.Limits, l) }
+
+func (s *sortedLimits) remove(l *Limit) {
+	for i := 0; i < len(s.Limits); i++ {
+		if s.Limits[i] == l {
+			s.Limits[i] = s.Limits[len(s.Limits)-1]
+			s.Limits = s.Limits[:len(s.Limits)-1]
+		}
+	}
+}
+
+func (s *sortedLimits) best() *Limit {
+	sort.Sort(ByBestAsk{s.Limits})
+	return s.Limits[0]
+}
+
+var benchLevelCounts = []int{100, 1_000, 10_000}
+
+func BenchmarkBestAsk(b *testing.B) {
+	for _, n := range benchLevelCounts {
+		limits := benchLimits(n)
+
+		b.Run(fmt.Sprintf("skiplist/%d", n), func(b *testing.B) {
+			asks := newAskLevels()
+			for _, l := range limits {
+				asks.Insert(l)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = asks.Best()
+			}
+		})
+
+		b.Run(fmt.Sprintf("sort/%d", n), func(b *testing.B) {
+			asks := &sortedLimits{}
+			for _, l := range limits {
+				asks.insert(l)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = asks.best()
+			}
+		})
+	}
+}
+
+// BenchmarkLevelChurn removes the best level and adds a new one, which is
+// what a market order that clears the top of the book followed by a new
+// quote looks like.
+func BenchmarkLevelChurn(b *testing.B) {
+	for _, n := range benchLevelCounts {
+		limits := benchLimits(n)
+
+		b.Run(fmt.Sprintf("skiplist/%d", n), func(b *testing.B) {
+			asks := newAskLevels()
+			for _, l := range limits {
+				asks.Insert(l)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				l := asks.Best()
+				asks.Remove(l)
+				asks.Insert(l)
+			}
+		})
+
+		b.Run(fmt.Sprintf("sort/%d", n), func(b *testing.B) {
+			asks := &sortedLimits{}
+			for _, l := range limits {
+				asks.insert(l)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				l := asks.best()
+				asks.remove(l)
+				asks.insert(l)
+			}
+		})
+	}
+}
+
+func benchLimits(n int) []*Limit {
+	r := rand.New(rand.NewSource(1))
+	limits := make([]*Limit, n)
+	for i, p := range r.Perm(n) {
+		limits[i] = NewLimit(RequireFromInt(int64(10_000 + p)))
+	}
+	return limits
+}
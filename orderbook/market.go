@@ -0,0 +1,81 @@
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInsufficientLiquidity = errors.New("not enough volume in the book to fill market order")
+	ErrSlippageExceeded      = errors.New("market order would fill beyond its slippage limit")
+	ErrInvalidSlippage       = errors.New("max slippage must be between 0 and 1 and worst price must not be negative")
+)
+
+// slippageGuard returns which limits a market order may take from. A buy
+// pays at most o.WorstPrice and at most o.MaxSlippage, a fraction like 0.01
+// for 1%, above the best ask. A sell gets at least o.WorstPrice and at most
+// o.MaxSlippage below the best bid. A zero WorstPrice or MaxSlippage is no
+// limit.
+func (ob *Orderbook) slippageGuard(o *Order) (func(*Limit) bool, error) {
+	if o.WorstPrice.IsNegative() || o.MaxSlippage.IsNegative() || o.MaxSlippage.GreaterThan(RequireFromInt(1)) {
+		return nil, ErrInvalidSlippage
+	}
+
+	worst := o.WorstPrice
+	if !o.MaxSlippage.IsZero() {
+		if best := ob.bestOpposite(o); best != nil {
+			// the slippage is at most the best price itself, but the bound
+			// of a buy can still go past the largest Decimal
+			slippage := best.Price.Mul(o.MaxSlippage)
+			if !o.Bid {
+				slippage = slippage.Neg()
+			}
+			bound, err := best.Price.CheckedAdd(slippage)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidSlippage, err)
+			}
+			switch {
+			case worst.IsZero():
+				worst = bound
+			case o.Bid:
+				worst = MinDecimal(worst, bound)
+			default:
+				worst = MaxDecimal(worst, bound)
+			}
+		}
+	}
+
+	if worst.IsZero() {
+		return func(*Limit) bool { return true }, nil
+	}
+	if o.Bid {
+		return func(l *Limit) bool { return l.Price.LessThanOrEqual(worst) }, nil
+	}
+	return func(l *Limit) bool { return l.Price.GreaterThanOrEqual(worst) }, nil
+}
+
+// checkMarketLiquidity makes sure o can be filled completely at prices both
+// its slippage guard and the price band allow.
+func (ob *Orderbook) checkMarketLiquidity(o *Order, slippage, inBand func(*Limit) bool) error {
+	acceptable := func(l *Limit) bool { return slippage(l) && inBand(l) }
+	if ob.fillableVolume(o, acceptable).GreaterThanOrEqual(o.Size) {
+		return nil
+	}
+
+	available := ob.fillableVolume(o, func(*Limit) bool { return true })
+	switch {
+	case available.LessThan(o.Size):
+		return fmt.Errorf("%w [available: %s, size: %s]", ErrInsufficientLiquidity, available, o.Size)
+	case ob.fillableVolume(o, inBand).LessThan(o.Size):
+		return ErrOutsidePriceBand
+	default:
+		return ErrSlippageExceeded
+	}
+}
+
+func (ob *Orderbook) bestOpposite(o *Order) *Limit {
+	if o.Bid {
+		return ob.asks.Best()
+	}
+	return ob.bids.Best()
+}
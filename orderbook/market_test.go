@@ -0,0 +1,126 @@
+package orderbook
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPlaceMarketOrderInsufficientLiquidity(t *testing.T) {
+	ob := NewOrderbook()
+
+	sellOrder := NewOrder(false, dec(5), 0)
+	ob.PlaceLimitOrder(dec(10_000), sellOrder)
+
+	buyOrder := NewOrder(true, dec(8), 0)
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+	assert(t, errors.Is(err, ErrInsufficientLiquidity), true)
+	assert(t, len(matches), 0)
+	assert(t, buyOrder.Size, dec(8))
+	assert(t, ob.AskTotalVolume(), dec(5))
+}
+
+func TestPlaceMarketOrderPartialFill(t *testing.T) {
+	ob := NewOrderbook()
+
+	sellOrder := NewOrder(false, dec(5), 0)
+	ob.PlaceLimitOrder(dec(10_000), sellOrder)
+
+	buyOrder := NewOrder(true, dec(8), 0)
+	buyOrder.AllowPartialFill = true
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, matches[0].SizeFilled, dec(5))
+	assert(t, buyOrder.Size, dec(3))
+	assert(t, buyOrder.Limit == nil, true)
+	assert(t, ob.asks.Len(), 0)
+}
+
+func TestPlaceMarketOrderWorstPrice(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(2), 0))
+	ob.PlaceLimitOrder(dec(10_100), NewOrder(false, dec(2), 0))
+	ob.PlaceLimitOrder(dec(10_500), NewOrder(false, dec(2), 0))
+
+	buyOrder := NewOrder(true, dec(5), 0)
+	buyOrder.WorstPrice = dec(10_100)
+	_, err := ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, ErrSlippageExceeded)
+	assert(t, ob.AskTotalVolume(), dec(6))
+
+	buyOrder.AllowPartialFill = true
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, buyOrder.Size, dec(1))
+	assert(t, ob.BestAsk().Price, dec(10_500))
+}
+
+func TestPlaceMarketOrderMaxSlippage(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(true, dec(2), 0))
+	ob.PlaceLimitOrder(dec(9_950), NewOrder(true, dec(2), 0))
+	ob.PlaceLimitOrder(dec(9_800), NewOrder(true, dec(2), 0))
+
+	// 1% below the best bid is 9_900
+	sellOrder := NewOrder(false, dec(6), 0)
+	sellOrder.MaxSlippage = dec(0.01)
+	sellOrder.AllowPartialFill = true
+	matches, err := ob.PlaceMarketOrder(sellOrder)
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, matches[1].Price, dec(9_950))
+	assert(t, sellOrder.Size, dec(2))
+
+	// the tighter of the two limits wins
+	ob.PlaceLimitOrder(dec(9_900), NewOrder(true, dec(2), 0))
+	sellOrder = NewOrder(false, dec(4), 0)
+	sellOrder.MaxSlippage = dec(0.05)
+	sellOrder.WorstPrice = dec(9_850)
+	_, err = ob.PlaceMarketOrder(sellOrder)
+	assert(t, err, ErrSlippageExceeded)
+}
+
+func TestPlaceMarketOrderInvalid(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(5), 0))
+
+	_, err := ob.PlaceMarketOrder(NewOrder(true, Zero, 0))
+	assert(t, err, ErrInvalidSize)
+
+	buyOrder := NewOrder(true, dec(1), 0)
+	buyOrder.MaxSlippage = dec(-0.01)
+	_, err = ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, ErrInvalidSlippage)
+
+	buyOrder.MaxSlippage = dec(10_000_000_000)
+	_, err = ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, ErrInvalidSlippage)
+
+	// the bound of a buy at full slippage does not fit
+	ob = NewOrderbook()
+	ob.PlaceLimitOrder(dec(60_000_000_000), NewOrder(false, dec(1), 0))
+	buyOrder.MaxSlippage = dec(1)
+	_, err = ob.PlaceMarketOrder(buyOrder)
+	assert(t, errors.Is(err, ErrInvalidSlippage), true)
+}
+
+func TestStopMarketDroppedWithoutLiquidity(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(1), 0))
+	ob.PlaceLimitOrder(dec(10_100), NewOrder(false, dec(1), 0))
+
+	stop := NewOrder(true, dec(5), 0)
+	stop.StopPrice = dec(10_000)
+	assert(t, ob.PlaceStopOrder(stop), nil)
+
+	matches, err := ob.PlaceMarketOrder(NewOrder(true, dec(1), 0))
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, stop.IsStopPending(), false)
+	assert(t, stop.Size, dec(5))
+	assert(t, ob.AskTotalVolume(), dec(1))
+}
@@ -0,0 +1,217 @@
+package orderbook
+
+// MatchingPolicy decides how an incoming order is shared between the orders
+// resting at one price.
+type MatchingPolicy interface {
+	// Allocate splits size between orders, which are in time priority and
+	// together hold at least size. It returns how much each order fills.
+	Allocate(orders []*Order, size Decimal) []Decimal
+}
+
+// PriceTime is first in, first out matching: each order fills completely
+// before the next one in the queue gets anything.
+type PriceTime struct{}
+
+func (PriceTime) Allocate(orders []*Order, size Decimal) []Decimal {
+	allocations := make([]Decimal, len(orders))
+	for i, order := range orders {
+		allocations[i] = MinDecimal(size, order.Size)
+		size = size.Sub(allocations[i])
+	}
+	return allocations
+}
+
+// ProRata shares an incoming order in proportion to the size of each resting
+// order, rounded down to whole lots. What rounding leaves over goes out a lot
+// at a time in time priority.
+type ProRata struct {
+	// Lot is the smallest size allocated, it defaults to the smallest Decimal
+	Lot Decimal
+}
+
+func (p ProRata) Allocate(orders []*Order, size Decimal) []Decimal {
+	allocations := make([]Decimal, len(orders))
+	capacity := make([]Decimal, len(orders))
+	for i, order := range orders {
+		capacity[i] = order.Size
+	}
+
+	allocateProRata(allocations, capacity, size, p.Lot)
+	return allocations
+}
+
+// TopOfQueueProRata is a hybrid: the order at the front of the queue first
+// gets TopShare of the incoming size, up to its own size, and the rest is
+// shared pro rata between all orders, the front one included.
+type TopOfQueueProRata struct {
+	// TopShare is the fraction, between 0 and 1, allocated to the front of
+	// the queue first
+	TopShare Decimal
+	Lot      Decimal
+}
+
+func (p TopOfQueueProRata) Allocate(orders []*Order, size Decimal) []Decimal {
+	allocations := make([]Decimal, len(orders))
+	capacity := make([]Decimal, len(orders))
+	for i, order := range orders {
+		capacity[i] = order.Size
+	}
+	if len(orders) == 0 {
+		return allocations
+	}
+
+	top := MinDecimal(floorToLot(size.Mul(p.TopShare), p.Lot), capacity[0])
+	allocations[0] = top
+	capacity[0] = capacity[0].Sub(top)
+
+	allocateProRata(allocations, capacity, size.Sub(top), p.Lot)
+	return allocations
+}
+
+// allocateProRata adds size to allocations in proportion to capacity, in
+// whole lots, then hands out the remainder a lot at a time in order.
+func allocateProRata(allocations, capacity []Decimal, size, lot Decimal) {
+	if !lot.IsPositive() {
+		lot = NewDecimalFromUnits(1)
+	}
+
+	total := Zero
+	for _, c := range capacity {
+		total = total.Add(c)
+	}
+	if !total.IsPositive() {
+		return
+	}
+
+	left := size
+	for i, c := range capacity {
+		// size is at most total, so the share is at most c and fits
+		units, _ := mulDiv(size.units, c.units, total.units)
+		share := floorToLot(NewDecimalFromUnits(units), lot)
+		allocations[i] = allocations[i].Add(share)
+		capacity[i] = c.Sub(share)
+		left = left.Sub(share)
+	}
+
+	for left.IsPositive() {
+		handedOut := false
+		for i := range capacity {
+			if !left.IsPositive() {
+				break
+			}
+			share := MinDecimal(MinDecimal(lot, capacity[i]), left)
+			if !share.IsPositive() {
+				continue
+			}
+			allocations[i] = allocations[i].Add(share)
+			capacity[i] = capacity[i].Sub(share)
+			left = left.Sub(share)
+			handedOut = true
+		}
+		if !handedOut {
+			return
+		}
+	}
+}
+
+func floorToLot(d, lot Decimal) Decimal {
+	if !lot.IsPositive() {
+		return d
+	}
+	return Decimal{units: d.units - d.units%lot.units}
+}
+
+// fillAllocated fills o against the orders at this limit all at once, with
+// the limit's policy deciding how much each order gets. Orders of o's own
+// user are taken out by self-trade prevention first.
+func (l *Limit) fillAllocated(dst []Match, o *Order) []Match {
+	for !o.done() && l.count > 0 {
+		eligible := l.eligible[:0]
+
+		for order := l.head; order != nil && !o.done(); {
+			next := order.next
+			if o.preventsSelfTrade(order) {
+				if l.preventSelfTrade(o, order) {
+					l.DeleteOrder(order)
+				}
+			} else {
+				eligible = append(eligible, order)
+			}
+			order = next
+		}
+		l.eligible = eligible
+		if o.done() || len(eligible) == 0 {
+			break
+		}
+
+		allocations := l.allocate(o, eligible)
+		eligible = l.eligible
+
+		filled := 0
+		for i, order := range eligible {
+			size := allocations[i]
+			if !size.IsPositive() {
+				continue
+			}
+
+			order.Size = order.Size.Sub(size)
+			o.Size = o.Size.Sub(size)
+			l.addVolume(order, size.Neg())
+			match := l.newMatch(order, o, size)
+			dst = append(dst, match)
+			l.executed(o, order, match)
+
+			if order.Size.IsZero() {
+				l.DeleteOrder(order)
+				if order.reserve.IsPositive() {
+					l.replenish(order)
+				}
+				filled++
+			}
+		}
+
+		if filled == 0 {
+			break
+		}
+	}
+
+	return dst
+}
+
+// allocate shares o out over eligible by the limit's policy. An order whose
+// share would break its own MinQty or AllOrNone condition or o's is left
+// out and the rest shared again, until every share is allowed. l.eligible
+// ends up holding the orders the returned allocations are for.
+func (l *Limit) allocate(o *Order, eligible []*Order) []Decimal {
+	for {
+		available := Zero
+		for _, order := range eligible {
+			available = available.Add(order.Size)
+		}
+		allocations := l.policy.Allocate(eligible, MinDecimal(o.Size, available))
+		if !o.conditional() && l.conditional == 0 {
+			l.eligible = eligible
+			return allocations
+		}
+
+		kept := eligible[:0]
+		for i, order := range eligible {
+			size := allocations[i]
+			if !size.IsPositive() || fillAllowed(o, order, size) {
+				kept = append(kept, order)
+			}
+		}
+		if len(kept) == len(eligible) {
+			l.eligible = eligible
+			return allocations
+		}
+		eligible = kept
+	}
+}
+
+func (l *Limit) newMatch(a, b *Order, size Decimal) Match {
+	if a.Bid {
+		return Match{Bid: a, Ask: b, SizeFilled: size, Price: l.Price}
+	}
+	return Match{Bid: b, Ask: a, SizeFilled: size, Price: l.Price}
+}
@@ -0,0 +1,148 @@
+package orderbook
+
+import "testing"
+
+func ordersOfSize(sizes ...float64) []*Order {
+	orders := make([]*Order, len(sizes))
+	for i, size := range sizes {
+		orders[i] = NewOrder(false, dec(size), int64(i))
+	}
+	return orders
+}
+
+func decs(values ...float64) []Decimal {
+	ds := make([]Decimal, len(values))
+	for i, v := range values {
+		ds[i] = dec(v)
+	}
+	return ds
+}
+
+func TestMatchingPolicyAllocate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy MatchingPolicy
+		sizes  []float64
+		size   float64
+		want   []Decimal
+	}{
+		{
+			name:   "price time fills the front of the queue first",
+			policy: PriceTime{},
+			sizes:  []float64{10, 30, 60},
+			size:   35,
+			want:   decs(10, 25, 0),
+		},
+		{
+			name:   "pro rata shares by size",
+			policy: ProRata{Lot: dec(1)},
+			sizes:  []float64{10, 30, 60},
+			size:   50,
+			want:   decs(5, 15, 30),
+		},
+		{
+			name:   "pro rata hands out rounding in time priority",
+			policy: ProRata{Lot: dec(1)},
+			sizes:  []float64{1, 1, 1},
+			size:   2,
+			want:   decs(1, 1, 0),
+		},
+		{
+			name:   "pro rata rounds down to lots",
+			policy: ProRata{Lot: dec(1)},
+			sizes:  []float64{10, 10, 10},
+			size:   10,
+			// 3.33 each rounds to 3, the last lot goes to the front
+			want: decs(4, 3, 3),
+		},
+		{
+			name:   "pro rata takes everything",
+			policy: ProRata{},
+			sizes:  []float64{2, 3},
+			size:   5,
+			want:   decs(2, 3),
+		},
+		{
+			name:   "top of queue gets its share first",
+			policy: TopOfQueueProRata{TopShare: dec(0.5), Lot: dec(1)},
+			sizes:  []float64{10, 30, 60},
+			size:   40,
+			// 10 to the front, capped by its size, then 30 pro rata over
+			// the 0, 30 and 60 left
+			want: decs(10, 10, 20),
+		},
+		{
+			name:   "top of queue shares the rest with the front order",
+			policy: TopOfQueueProRata{TopShare: dec(0.2), Lot: dec(1)},
+			sizes:  []float64{40, 60},
+			size:   50,
+			// 10 to the front, then 40 pro rata over 30 and 60 is 13 and
+			// 26 with the lot left by rounding going to the front
+			want: decs(24, 26),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.policy.Allocate(ordersOfSize(tc.sizes...), dec(tc.size))
+			assert(t, got, tc.want)
+
+			total := Zero
+			for _, size := range got {
+				total = total.Add(size)
+			}
+			assert(t, total, dec(tc.size))
+		})
+	}
+}
+
+func TestPlaceMarketOrderProRata(t *testing.T) {
+	ob := NewOrderbook(WithMatchingPolicy(ProRata{Lot: dec(1)}))
+
+	sellOrderA := NewOrder(false, dec(10), 1)
+	sellOrderB := NewOrder(false, dec(30), 2)
+	sellOrderC := NewOrder(false, dec(5), 3)
+	ob.PlaceLimitOrder(dec(10_000), sellOrderA)
+	ob.PlaceLimitOrder(dec(10_000), sellOrderB)
+	ob.PlaceLimitOrder(dec(10_100), sellOrderC)
+
+	matches, _ := ob.PlaceMarketOrder(NewOrder(true, dec(20), 0))
+
+	assert(t, len(matches), 2)
+	assert(t, matches[0].Ask, sellOrderA)
+	assert(t, matches[0].SizeFilled, dec(5))
+	assert(t, matches[1].Ask, sellOrderB)
+	assert(t, matches[1].SizeFilled, dec(15))
+	assert(t, ob.AskLimits[dec(10_000)].TotalVolume, dec(20))
+
+	// takes the rest of the first level, then moves on to the next one
+	matches, _ = ob.PlaceMarketOrder(NewOrder(true, dec(22), 0))
+
+	assert(t, len(matches), 3)
+	assert(t, sellOrderA.IsFilled(), true)
+	assert(t, sellOrderB.IsFilled(), true)
+	assert(t, matches[2].Ask, sellOrderC)
+	assert(t, matches[2].SizeFilled, dec(2))
+	assert(t, ob.asks.Len(), 1)
+	assert(t, len(ob.Orders), 1)
+}
+
+func TestProRataSelfTradePrevention(t *testing.T) {
+	ob := NewOrderbook(WithMatchingPolicy(ProRata{Lot: dec(1)}))
+
+	ownAsk := NewOrder(false, dec(10), 7)
+	otherAsk := NewOrder(false, dec(10), 8)
+	ob.PlaceLimitOrder(dec(10_000), ownAsk)
+	ob.PlaceLimitOrder(dec(10_000), otherAsk)
+
+	buy := NewOrder(true, dec(4), 7)
+	buy.SelfTradePrevention = CancelOldest
+	matches, _ := ob.PlaceMarketOrder(buy)
+
+	// the own order is cancelled and everything goes to the other one
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Ask, otherAsk)
+	assert(t, matches[0].SizeFilled, dec(4))
+	assert(t, ownAsk.Limit == nil, true)
+	assert(t, ob.AskTotalVolume(), dec(6))
+}
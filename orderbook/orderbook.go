@@ -1,34 +1,102 @@
 package orderbook
 
 import (
+	"container/heap"
+	"errors"
 	"fmt"
-	"math/rand"
-	"sort"
 	"sync"
 	"time"
 )
 
+var ErrInvalidDisplaySize = errors.New("display size must not be negative")
+
+// Trade is the record of a Match. Bid is the aggressor side, true when the
+// incoming order that caused the trade was a buy. Auction trades have no
+// aggressor.
 type Trade struct {
-	Price     float64 `json:"price"`
-	Bid       bool    `json:"bid"`
-	Timestamp int64   `json:"timestamp"`
-	Size      float64 `json:"size"`
+	ID         int64   `json:"id"`
+	Price      Decimal `json:"price"`
+	Bid        bool    `json:"bid"`
+	Timestamp  int64   `json:"timestamp"`
+	Size       Decimal `json:"size"`
+	BidOrderID int64   `json:"bidOrderId"`
+	AskOrderID int64   `json:"askOrderId"`
+	Auction    bool    `json:"auction,omitempty"`
 }
 
 type Match struct {
 	Ask        *Order
 	Bid        *Order
-	SizeFilled float64
-	Price      float64
+	SizeFilled Decimal
+	Price      Decimal
 }
 
 type Order struct {
 	ID        int64
 	UserID    int64
-	Size      float64
+	Size      Decimal
 	Bid       bool
 	Limit     *Limit
 	Timestamp int64
+	// prev and next link the orders queued at Limit
+	prev, next *Order
+
+	TimeInForce TimeInForce
+	// ExpiresAt is when a good til date order leaves the book, in unix
+	// nanoseconds
+	ExpiresAt int64
+
+	// PostOnly orders never take liquidity, PostOnlyMode says whether one
+	// that would is rejected or repriced
+	PostOnly     bool
+	PostOnlyMode PostOnlyMode
+
+	// StopPrice is the last trade price that triggers a stop order. A
+	// triggered stop order becomes a limit order at StopLimitPrice, or a
+	// market order when StopLimitPrice is zero.
+	StopPrice      Decimal
+	StopLimitPrice Decimal
+	stopPending    bool
+
+	// DisplaySize makes a resting order an iceberg: only a slice of this
+	// size is in Size and visible in the book, the rest waits in reserve
+	// and is shown a slice at a time as the visible one fills
+	DisplaySize Decimal
+	reserve     Decimal
+
+	// SelfTradePrevention stops this order from trading with resting orders
+	// of the same user, SelfTradeCancels lists what that cancelled
+	SelfTradePrevention SelfTradePrevention
+	SelfTradeCancels    []SelfTradeCancel
+	selfTradeCanceled   bool
+
+	// AllowPartialFill lets a market order fill what the book has and drop
+	// the rest instead of being rejected. WorstPrice and MaxSlippage guard
+	// a market order against walking too far into the book, see
+	// slippageGuard.
+	AllowPartialFill bool
+	WorstPrice       Decimal
+	MaxSlippage      Decimal
+
+	// QuoteSize sizes a market order in the quote currency instead of
+	// Size, like buying 500 USD worth, see placeQuoteOrder. quoteLeft is
+	// the part of it that did not trade.
+	QuoteSize Decimal
+	quoteLeft Decimal
+
+	// Hidden orders rest and match at their price like any other but are
+	// left out of the visible book, see Limit.TotalVolume
+	Hidden bool
+	// Peg makes a resting order follow the best bid, the best ask or the
+	// midpoint, PegOffset away from it, see PlacePeggedOrder
+	Peg       PegReference
+	PegOffset Decimal
+
+	// MinQty is the smallest fill the order accepts, AllOrNone only lets
+	// it trade its whole size at once. Resting orders whose conditions an
+	// incoming order cannot meet are passed over, see fillAllowed.
+	MinQty    Decimal
+	AllOrNone bool
 }
 
 type Orders []*Order
@@ -37,29 +105,89 @@ func (o Orders) Len() int           { return len(o) }
 func (o Orders) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
 func (o Orders) Less(i, j int) bool { return o[i].Timestamp < o[j].Timestamp }
 
-func NewOrder(bid bool, size float64, userId int64) *Order {
+// NewOrder returns an order without an ID, the orderbook assigns the next
+// one from its sequencer when the order is placed.
+func NewOrder(bid bool, size Decimal, userId int64) *Order {
 	return &Order{
-		ID:        int64(rand.Intn(10_000_000)),
 		Size:      size,
 		Bid:       bid,
 		Timestamp: time.Now().UnixNano(),
 		UserID:    userId,
+
+		TimeInForce: GoodTilCanceled,
 	}
 }
 
+var orderPool = sync.Pool{
+	New: func() interface{} { return new(Order) },
+}
+
+// AcquireOrder is NewOrder taking the order from a pool instead of
+// allocating it. Hand it back with ReleaseOrder once it is done with.
+func AcquireOrder(bid bool, size Decimal, userId int64) *Order {
+	o := orderPool.Get().(*Order)
+	o.Size = size
+	o.Bid = bid
+	o.Timestamp = time.Now().UnixNano()
+	o.UserID = userId
+	o.TimeInForce = GoodTilCanceled
+	return o
+}
+
+// ReleaseOrder clears o and puts it back in the pool. Only release an order
+// that has left the book, filled or cancelled, once nothing else refers to
+// it, including the matches it was part of. Good til date orders stay in the
+// book's expiry queue until they expire, release those after that.
+func ReleaseOrder(o *Order) {
+	*o = Order{}
+	orderPool.Put(o)
+}
+
 func (o *Order) String() string {
-	return fmt.Sprintf("[size: %.2f]", o.Size)
+	return fmt.Sprintf("[size: %s]", o.Size)
 }
 
 func (o *Order) IsFilled() bool {
-	return o.Size == 0.0
+	return o.Size.IsZero() && o.reserve.IsZero()
+}
+
+// Reserve returns the size of an iceberg order that is not visible in the
+// book yet.
+func (o *Order) Reserve() Decimal {
+	return o.reserve
 }
 
 // a bucket of orders at a specific price with different volumes / sizes
 type Limit struct {
-	Price       float64
-	Orders      Orders
-	TotalVolume float64
+	Price Decimal
+	// the orders are queued in a linked list through the orders themselves,
+	// oldest first, so adding or removing one does not allocate or shift
+	// the others
+	head, tail  *Order
+	count       int
+	TotalVolume Decimal
+	// reserveVolume is the hidden reserve of the iceberg orders at this
+	// price, it is not part of TotalVolume
+	reserveVolume Decimal
+	// hiddenVolume and hidden are the size and number of the hidden orders
+	// at this price, they are not part of TotalVolume either
+	hiddenVolume Decimal
+	hidden       int
+	// conditional is the number of orders at this price with a MinQty or
+	// AllOrNone condition
+	conditional int
+	// policy shares incoming orders between the orders at this price, nil
+	// means PriceTime
+	policy MatchingPolicy
+	// eligible is reused by fillAllocated for the orders it shares out to
+	eligible []*Order
+	// levels is the side of the book the limit is on, the limit is in its
+	// visible list while it has a visible order. It is nil outside a book.
+	levels *priceLevels
+	// ob is the book the limit rests in, fills against the limit are
+	// recorded and published there as they happen. It is nil for limits
+	// outside a book, such as the copies planFill tries fills on.
+	ob *Orderbook
 }
 
 type Limits []*Limit
@@ -70,7 +198,7 @@ func (a ByBestAsk) Len() int      { return len(a.Limits) }
 func (a ByBestAsk) Swap(i, j int) { a.Limits[i], a.Limits[j] = a.Limits[j], a.Limits[i] }
 
 // When sorting asks, we want the lowest price first (ascending)
-func (a ByBestAsk) Less(i, j int) bool { return a.Limits[i].Price < a.Limits[j].Price }
+func (a ByBestAsk) Less(i, j int) bool { return a.Limits[i].Price.LessThan(a.Limits[j].Price) }
 
 type ByBestBid struct{ Limits }
 
@@ -78,67 +206,185 @@ func (a ByBestBid) Len() int      { return len(a.Limits) }
 func (a ByBestBid) Swap(i, j int) { a.Limits[i], a.Limits[j] = a.Limits[j], a.Limits[i] }
 
 // When sorting bids, we want the highest price first (descending)
-func (a ByBestBid) Less(i, j int) bool { return a.Limits[i].Price > a.Limits[j].Price }
+func (a ByBestBid) Less(i, j int) bool { return a.Limits[i].Price.GreaterThan(a.Limits[j].Price) }
 
-func NewLimit(price float64) *Limit {
-	return &Limit{Price: price, Orders: []*Order{}}
+func NewLimit(price Decimal) *Limit {
+	return &Limit{Price: price}
 }
 
 func (l *Limit) String() string {
-	return fmt.Sprintf("{price: %.2f, totalVolume: %.2f, orders: %v}", l.Price, l.TotalVolume, l.Orders)
+	return fmt.Sprintf("{price: %s, totalVolume: %s, orders: %v}", l.Price, l.TotalVolume, l.Orders())
+}
+
+// Len returns the number of orders at this limit.
+func (l *Limit) Len() int {
+	return l.count
 }
 
+// VisibleLen returns the number of orders at this limit that are not
+// hidden.
+func (l *Limit) VisibleLen() int {
+	return l.count - l.hidden
+}
+
+// Orders returns the orders at this limit in queue order. It copies them,
+// the matching code walks the queue itself.
+func (l *Limit) Orders() Orders {
+	orders := make(Orders, 0, l.count)
+	for o := l.head; o != nil; o = o.next {
+		orders = append(orders, o)
+	}
+	return orders
+}
+
+// AddOrder queues o at the back of the limit.
 func (l *Limit) AddOrder(o *Order) {
 	o.Limit = l
-	l.Orders = append(l.Orders, o)
-	l.TotalVolume += o.Size
+	o.prev, o.next = l.tail, nil
+	if l.tail != nil {
+		l.tail.next = o
+	} else {
+		l.head = o
+	}
+	l.tail = o
+	l.count++
+	if o.Hidden {
+		l.hidden++
+	} else if l.VisibleLen() == 1 && l.levels != nil {
+		l.levels.visible.Insert(l)
+	}
+	if o.conditional() {
+		l.conditional++
+	}
+
+	l.addVolume(o, o.Size)
+	l.reserveVolume = l.reserveVolume.Add(o.reserve)
 }
 
+// DeleteOrder takes o out of the queue, the orders around it keep their
+// places.
 func (l *Limit) DeleteOrder(o *Order) {
-	for i, order := range l.Orders {
-		if order == o {
-			l.Orders = append(l.Orders[:i], l.Orders[i+1:]...)
-			// return
-		}
+	if o.Limit != l {
+		return
+	}
+
+	if o.prev != nil {
+		o.prev.next = o.next
+	} else {
+		l.head = o.next
+	}
+	if o.next != nil {
+		o.next.prev = o.prev
+	} else {
+		l.tail = o.prev
+	}
+	o.prev, o.next = nil, nil
+	l.count--
+	if o.Hidden {
+		l.hidden--
+	} else if l.VisibleLen() == 0 && l.levels != nil {
+		l.levels.visible.Remove(l)
+	}
+	if o.conditional() {
+		l.conditional--
 	}
+
 	o.Limit = nil
-	l.TotalVolume -= o.Size
+	l.addVolume(o, o.Size.Neg())
+	l.reserveVolume = l.reserveVolume.Sub(o.reserve)
+}
 
-	sort.Sort(l.Orders)
+// addVolume adds size, or takes it off when negative, to the visible or the
+// hidden volume of the limit, whichever o counts towards.
+func (l *Limit) addVolume(o *Order, size Decimal) {
+	if o.Hidden {
+		l.hiddenVolume = l.hiddenVolume.Add(size)
+		return
+	}
+	l.TotalVolume = l.TotalVolume.Add(size)
 }
 
+// Fill matches o against the orders at this limit, sharing it out according
+// to the limit's matching policy. An iceberg order whose visible slice is
+// filled gets a new slice from its reserve at the back of the queue, which o
+// keeps filling against. Orders of o's own user are handled by o's
+// self-trade prevention mode instead. Orders whose MinQty or AllOrNone
+// condition o cannot meet, or that cannot meet o's, are passed over and keep
+// their place in the queue.
 func (l *Limit) Fill(o *Order) []Match {
-	var (
-		ordersToDelete []*Order
-		matches        []Match
-	)
+	return l.fill(nil, o)
+}
 
-	for _, order := range l.Orders {
-		if o.IsFilled() {
-			break
+// fill is Fill appending the matches to dst.
+func (l *Limit) fill(dst []Match, o *Order) []Match {
+	switch l.policy.(type) {
+	case nil, PriceTime:
+		return l.fillPriceTime(dst, o)
+	default:
+		return l.fillAllocated(dst, o)
+	}
+}
+
+// fillPriceTime fills o against the orders in time priority, each order
+// filling completely before the next one gets anything.
+func (l *Limit) fillPriceTime(dst []Match, o *Order) []Match {
+	for order := l.head; order != nil && !o.done(); {
+		next := order.next
+
+		if o.preventsSelfTrade(order) {
+			if l.preventSelfTrade(o, order) {
+				l.DeleteOrder(order)
+			}
+			order = next
+			continue
+		}
+		if !fillAllowed(o, order, MinDecimal(o.Size, order.Size)) {
+			order = next
+			continue
 		}
 
 		match := l.fillOrder(order, o)
-		matches = append(matches, match)
-		l.TotalVolume -= match.SizeFilled
-
-		// this could cause data corruption
-		if order.IsFilled() {
-			ordersToDelete = append(ordersToDelete, order)
+		dst = append(dst, match)
+		l.addVolume(order, match.SizeFilled.Neg())
+		l.executed(o, order, match)
+
+		if order.Size.IsZero() {
+			l.DeleteOrder(order)
+			if order.reserve.IsPositive() {
+				l.replenish(order)
+				// the new slice is at the back, o gets to it after the
+				// orders queued behind the old one
+				if next == nil {
+					next = order
+				}
+			}
 		}
+		order = next
 	}
 
-	for _, o := range ordersToDelete {
-		l.DeleteOrder(o)
+	return dst
+}
+
+// replenish moves the next display slice of an iceberg order out of its
+// reserve and queues it at the back of the limit.
+func (l *Limit) replenish(o *Order) {
+	slice := MinDecimal(o.DisplaySize, o.reserve)
+	o.reserve = o.reserve.Sub(slice)
+	o.Size = slice
+
+	o.Timestamp = time.Now().UnixNano()
+	if l.tail != nil && l.tail.Timestamp >= o.Timestamp {
+		o.Timestamp = l.tail.Timestamp + 1
 	}
-	return matches
+
+	l.AddOrder(o)
 }
 
 func (l *Limit) fillOrder(a, b *Order) Match {
 	var (
 		bid        *Order
 		ask        *Order
-		sizeFilled float64
+		sizeFilled Decimal
 	)
 
 	if a.Bid {
@@ -149,14 +395,14 @@ func (l *Limit) fillOrder(a, b *Order) Match {
 		ask = a
 	}
 
-	if a.Size >= b.Size {
-		a.Size -= b.Size
+	if a.Size.GreaterThanOrEqual(b.Size) {
+		a.Size = a.Size.Sub(b.Size)
 		sizeFilled = b.Size
-		b.Size = 0.0
+		b.Size = Zero
 	} else {
-		b.Size -= a.Size
+		b.Size = b.Size.Sub(a.Size)
 		sizeFilled = a.Size
-		a.Size = 0.0
+		a.Size = Zero
 	}
 
 	return Match{
@@ -168,67 +414,424 @@ func (l *Limit) fillOrder(a, b *Order) Match {
 }
 
 type Orderbook struct {
-	asks []*Limit
-	bids []*Limit
+	asks *priceLevels
+	bids *priceLevels
 
 	mu        sync.RWMutex
-	AskLimits map[float64]*Limit
-	BidLimits map[float64]*Limit
+	AskLimits map[Decimal]*Limit
+	BidLimits map[Decimal]*Limit
 
 	Orders map[int64]*Order
+	// Stops holds the stop orders waiting for their trigger, they are not
+	// part of the visible book until then
+	Stops map[int64]*Order
+	// Trades is the tape, oldest first. Only the most recent trades are
+	// kept, see WithTradeRetention
 	Trades []*Trade
+
+	expiries expiryQueue
+	tickSize Decimal
+	lotSize  Decimal
+	policy   MatchingPolicy
+
+	stops          stopOrders
+	lastTradePrice Decimal
+
+	orderIDs *Sequencer
+	tradeIDs *Sequencer
+
+	listeners []Listener
+
+	band        *PriceBand
+	breaker     *CircuitBreaker
+	haltedUntil int64
+	// bandTrades and breakerTrades are the trades within the band's and
+	// the breaker's window
+	bandTrades    tradeWindow
+	breakerTrades tradeWindow
+
+	auction    bool
+	auctionEnd int64
+
+	// cleared is reused by every sweep for the levels it emptied
+	cleared []*Limit
+	// freeLimits are cleared levels kept for reuse, see newLimit
+	freeLimits []*Limit
+	// pegged are the pegged orders repeg looks after, including some that
+	// may have left the book since
+	pegged []*Order
+	// tradeSlab is where recordTrade takes new trades from, so the tape
+	// allocates a block of trades at a time rather than one per match
+	tradeSlab      []Trade
+	tradeRetention int
+}
+
+// Option configures an Orderbook when it is created.
+type Option func(*Orderbook)
+
+// WithTickSize sets the price increment used to reprice post-only orders. It
+// defaults to the smallest Decimal.
+func WithTickSize(tick Decimal) Option {
+	return func(ob *Orderbook) {
+		ob.tickSize = tick
+	}
+}
+
+// WithMatchingPolicy sets how an incoming order is shared between the orders
+// resting at one price. It defaults to PriceTime.
+func WithMatchingPolicy(policy MatchingPolicy) Option {
+	return func(ob *Orderbook) {
+		ob.policy = policy
+	}
+}
+
+// WithOrderSequencer sets the sequencer the orderbook takes order IDs from.
+// By default every orderbook has its own, starting at 1.
+func WithOrderSequencer(s *Sequencer) Option {
+	return func(ob *Orderbook) {
+		ob.orderIDs = s
+	}
+}
+
+// WithTradeSequencer sets the sequencer the orderbook takes trade IDs from.
+// Trades are numbered apart from orders, so a gap in the trade IDs of a
+// market means a trade was missed. By default every orderbook has its own,
+// starting at 1.
+func WithTradeSequencer(s *Sequencer) Option {
+	return func(ob *Orderbook) {
+		ob.tradeIDs = s
+	}
 }
 
-func NewOrderbook() *Orderbook {
-	return &Orderbook{
-		asks:      []*Limit{},
-		bids:      []*Limit{},
-		AskLimits: make(map[float64]*Limit),
-		BidLimits: make(map[float64]*Limit),
+func NewOrderbook(opts ...Option) *Orderbook {
+	ob := &Orderbook{
+		asks:      newAskLevels(),
+		bids:      newBidLevels(),
+		AskLimits: make(map[Decimal]*Limit),
+		BidLimits: make(map[Decimal]*Limit),
 		Orders:    make(map[int64]*Order),
+		Stops:     make(map[int64]*Order),
 		Trades:    []*Trade{},
+		tickSize:  NewDecimalFromUnits(1),
+		policy:    PriceTime{},
+		orderIDs:  NewSequencer(0),
+		tradeIDs:  NewSequencer(0),
+
+		tradeRetention: DefaultTradeRetention,
+	}
+
+	for _, opt := range opts {
+		opt(ob)
+	}
+
+	return ob
+}
+
+// PlaceMarketOrder fills o against the opposite side of the book, best price
+// first. When the book cannot fill all of o, within the slippage guard if o
+// has one, it returns ErrInsufficientLiquidity or ErrSlippageExceeded and
+// leaves the book untouched, unless o.AllowPartialFill is set, in which case
+// o fills what it can and the rest is dropped. An all-or-none order is
+// never partially filled. The returned matches also include those of any
+// stop orders the fills triggered.
+//
+// An order with a QuoteSize instead of a Size takes as much base as that
+// buys, or sells for, in whole lots. QuoteLeft returns what it left over.
+func (ob *Orderbook) PlaceMarketOrder(o *Order) ([]Match, error) {
+	return ob.AppendMarketOrder(nil, o)
+}
+
+// AppendMarketOrder is PlaceMarketOrder appending the matches to dst, so a
+// caller that reuses dst places orders without allocating for them.
+func (ob *Orderbook) AppendMarketOrder(dst []Match, o *Order) ([]Match, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	ob.expireOrders(now)
+	defer ob.repeg(now)
+
+	if ob.halted(now) {
+		return dst, ErrTradingHalted
+	}
+	if err := ob.assignID(o); err != nil {
+		return dst, err
+	}
+
+	dst, err := ob.placeMarketOrder(dst, o)
+	if err != nil {
+		return dst, err
+	}
+
+	return ob.triggerStops(dst), nil
+}
+
+func (ob *Orderbook) placeMarketOrder(dst []Match, o *Order) ([]Match, error) {
+	if ob.auction {
+		return dst, ErrAuctionMarketOrder
+	}
+	if err := o.SelfTradePrevention.Validate(); err != nil {
+		return dst, err
+	}
+	if !o.QuoteSize.IsZero() {
+		return ob.placeQuoteOrder(dst, o)
+	}
+	return ob.fillMarketOrder(dst, o)
+}
+
+func (ob *Orderbook) fillMarketOrder(dst []Match, o *Order) ([]Match, error) {
+	if !o.Size.IsPositive() {
+		return dst, ErrInvalidSize
+	}
+	if err := o.checkConditions(); err != nil {
+		return dst, err
+	}
+
+	levels := ob.asks
+	if !o.Bid {
+		levels = ob.bids
+	}
+
+	slippage, err := ob.slippageGuard(o)
+	if err != nil {
+		return dst, err
+	}
+	inBand := ob.bandGuard(o, time.Now().UnixNano())
+
+	if !o.AllowPartialFill || o.AllOrNone {
+		if err := ob.checkMarketLiquidity(o, slippage, inBand); err != nil {
+			return dst, err
+		}
+	}
+
+	ob.publishAccepted(Zero, o)
+	dst = ob.sweep(dst, o, levels, func(l *Limit) bool { return slippage(l) && inBand(l) })
+	if !o.done() {
+		ob.publishCanceled(o, o.Size, Zero, CancelUnfilled)
+	}
+
+	return dst, nil
+}
+
+// PlaceLimitOrder matches the order against the opposite side of the book for
+// as long as the best opposite price is at or better than the limit price.
+// What happens to the rest of the order depends on its TimeInForce: good til
+// canceled and good til date orders rest in the book at their price, so the
+// book is never left crossed, while immediate or cancel orders drop it. A
+// fill or kill order that cannot be filled completely returns ErrFillOrKill
+// and leaves the book untouched.
+//
+// An all-or-none order only trades when it fills completely, across as many
+// resting orders as it takes. Resting orders whose MinQty or AllOrNone
+// condition an order cannot meet are passed over, and an order that would
+// rest crossing them is rejected with ErrConditionsWouldCross before it
+// trades.
+//
+// A post-only order never matches. If it would cross the book it is either
+// rejected with ErrPostOnlyWouldCross or rests one tick behind the opposite
+// best price, in which case o.Limit.Price differs from price.
+//
+// The returned matches also include those of any stop orders the fills
+// triggered.
+func (ob *Orderbook) PlaceLimitOrder(price Decimal, o *Order) ([]Match, error) {
+	return ob.AppendLimitOrder(nil, price, o)
+}
+
+// AppendLimitOrder is PlaceLimitOrder appending the matches to dst, so a
+// caller that reuses dst places orders without allocating for them.
+func (ob *Orderbook) AppendLimitOrder(dst []Match, price Decimal, o *Order) ([]Match, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	ob.expireOrders(now)
+	defer ob.repeg(now)
+
+	if ob.halted(now) {
+		return dst, ErrTradingHalted
+	}
+	if err := ob.assignID(o); err != nil {
+		return dst, err
+	}
+	if o.Peg != "" {
+		return dst, ErrPeggedPlacement
+	}
+
+	dst, err := ob.placeLimitOrder(dst, price, o, now)
+	if err != nil {
+		return dst, err
+	}
+
+	return ob.triggerStops(dst), nil
+}
+
+// checkLimitOrder validates a limit order at price on its own, before any
+// of the checks that depend on the orders in the book.
+func (ob *Orderbook) checkLimitOrder(price Decimal, o *Order, now int64) error {
+	if err := ob.checkBand(price, now); err != nil {
+		return err
+	}
+	return ob.checkLimitTerms(o, now)
+}
+
+// checkLimitTerms runs the checks of checkLimitOrder that do not depend on
+// the price, so a stop limit order can be validated before it is armed.
+func (ob *Orderbook) checkLimitTerms(o *Order, now int64) error {
+	if !o.QuoteSize.IsZero() {
+		return ErrQuoteSizeMarketOnly
+	}
+	if err := o.TimeInForce.validate(o, now); err != nil {
+		return err
+	}
+	if err := o.SelfTradePrevention.Validate(); err != nil {
+		return err
 	}
+	if o.DisplaySize.IsNegative() {
+		return ErrInvalidDisplaySize
+	}
+	if o.Hidden && o.DisplaySize.IsPositive() {
+		return ErrHiddenIceberg
+	}
+	if err := o.checkConditions(); err != nil {
+		return err
+	}
+	if ob.auction && (o.TimeInForce == ImmediateOrCancel || o.TimeInForce == FillOrKill) {
+		return ErrAuctionTimeInForce
+	}
+	return o.checkPostOnly()
 }
 
-func (ob *Orderbook) PlaceMarketOrder(o *Order) []Match {
-	matches := []Match{}
+// placeLimitOrder places o at price, now being the time it is placed at.
+func (ob *Orderbook) placeLimitOrder(dst []Match, price Decimal, o *Order, now int64) ([]Match, error) {
+	if err := ob.checkLimitOrder(price, o, now); err != nil {
+		return dst, err
+	}
+
+	// during an auction orders rest without matching, even when they cross
+	if ob.auction {
+		ob.publishAccepted(price, o)
+		ob.restLimitOrder(price, o)
+		ob.publishIndicative()
+		return dst, nil
+	}
+
+	if o.PostOnly {
+		restingPrice, err := ob.postOnlyPrice(price, o)
+		if err != nil {
+			return dst, err
+		}
+		ob.publishAccepted(restingPrice, o)
+		ob.restLimitOrder(restingPrice, o)
+		return dst, nil
+	}
+
+	levels, acceptable := ob.crossingLevels(price, o)
+	trade, err := ob.planLimitOrder(o, acceptable)
+	if err != nil {
+		return dst, err
+	}
+
+	ob.publishAccepted(price, o)
+	if trade {
+		dst = ob.sweep(dst, o, levels, acceptable)
+	}
+
+	if o.done() {
+		return dst, nil
+	}
+	if o.TimeInForce == ImmediateOrCancel || o.TimeInForce == FillOrKill {
+		ob.publishCanceled(o, o.Size, Zero, CancelUnfilled)
+		return dst, nil
+	}
+
+	ob.restLimitOrder(price, o)
 
+	return dst, nil
+}
+
+// crossingLevels returns the opposite side of the book to o and a func
+// reporting whether a level of it crosses price.
+func (ob *Orderbook) crossingLevels(price Decimal, o *Order) (*priceLevels, func(*Limit) bool) {
 	if o.Bid {
-		if o.Size > ob.AskTotalVolume() {
-			panic(fmt.Errorf("not enough volume [size: %.2f] for market order [szie: %.2f]", ob.AskTotalVolume(), o.Size))
+		return ob.asks, func(l *Limit) bool { return l.Price.LessThanOrEqual(price) }
+	}
+	return ob.bids, func(l *Limit) bool { return l.Price.GreaterThanOrEqual(price) }
+}
+
+func (ob *Orderbook) restLimitOrder(price Decimal, o *Order) {
+	if o.DisplaySize.IsPositive() && o.DisplaySize.LessThan(o.Size) {
+		o.reserve = o.Size.Sub(o.DisplaySize)
+		o.Size = o.DisplaySize
+	}
+
+	ob.addLimitOrder(price, o)
+	if !o.Hidden {
+		ob.publishLevel(o.Bid, o.Limit)
+	}
+	if o.TimeInForce == GoodTilDate {
+		heap.Push(&ob.expiries, o)
+	}
+}
+
+// sweep fills o against the given levels, best price first, until the order
+// is filled or a level is no longer acceptable, and appends the matches to
+// dst. Levels left empty are removed from the book once the sweep is done.
+func (ob *Orderbook) sweep(dst []Match, o *Order, levels *priceLevels, acceptable func(*Limit) bool) []Match {
+	var (
+		start   = len(dst)
+		cleared = ob.cleared[:0]
+	)
+
+	levels.Each(func(limit *Limit) bool {
+		if o.done() || !acceptable(limit) {
+			return false
 		}
-		for _, limit := range ob.Asks() {
-			limitMatches := limit.Fill(o)
-			matches = append(matches, limitMatches...)
 
-			if len(limit.Orders) == 0 {
-				ob.clearLimits(false, limit)
-			}
+		visible := limit.VisibleLen() > 0
+		dst = limit.fill(dst, o)
+		// a level that only ever held hidden orders stays out of the events
+		if visible || limit.VisibleLen() > 0 {
+			ob.publishLevel(!o.Bid, limit)
 		}
-	} else {
-		if o.Size > ob.BidTotalVolume() {
-			panic(fmt.Errorf("not enough volume [size: %.2f] for market order [szie: %.2f]", ob.BidTotalVolume(), o.Size))
+
+		if limit.count == 0 {
+			cleared = append(cleared, limit)
 		}
+		return true
+	})
+
+	for _, limit := range cleared {
+		ob.clearLimits(!o.Bid, limit)
+	}
+	ob.cleared = cleared
 
-		for _, limit := range ob.Bids() {
-			limitMatches := limit.Fill(o)
-			matches = append(matches, limitMatches...)
+	matches := dst[start:]
+	if len(matches) > 0 {
+		ob.lastTradePrice = matches[len(matches)-1].Price
+		ob.checkCircuitBreaker(ob.Trades[len(ob.Trades)-1].Timestamp)
+	}
 
-			if len(limit.Orders) == 0 {
-				ob.clearLimits(true, limit)
-			}
+	for _, match := range matches {
+		if match.Ask.IsFilled() {
+			delete(ob.Orders, match.Ask.ID)
+		}
+		if match.Bid.IsFilled() {
+			delete(ob.Orders, match.Bid.ID)
+		}
+	}
+
+	for _, canceled := range o.SelfTradeCancels {
+		if canceled.Order != o && canceled.Order.Limit == nil {
+			delete(ob.Orders, canceled.Order.ID)
 		}
 	}
 
-	return matches
+	return dst
 }
 
-func (ob *Orderbook) PlaceLimitOrder(price float64, o *Order) {
+func (ob *Orderbook) addLimitOrder(price Decimal, o *Order) {
 	var limit *Limit
 
-	ob.mu.Lock()
-	defer ob.mu.Unlock()
-
 	if o.Bid {
 		limit = ob.BidLimits[price]
 	} else {
@@ -236,14 +839,15 @@ func (ob *Orderbook) PlaceLimitOrder(price float64, o *Order) {
 	}
 
 	if limit == nil {
-		limit = NewLimit(price)
+		limit = ob.newLimit(price)
 		if o.Bid {
 			ob.BidLimits[price] = limit
-			ob.bids = append(ob.bids, limit)
+			limit.levels = ob.bids
 		} else {
 			ob.AskLimits[price] = limit
-			ob.asks = append(ob.asks, limit)
+			limit.levels = ob.asks
 		}
+		limit.levels.Insert(limit)
 	}
 
 	limit.AddOrder(o)
@@ -253,62 +857,112 @@ func (ob *Orderbook) PlaceLimitOrder(price float64, o *Order) {
 func (ob *Orderbook) clearLimits(bid bool, l *Limit) {
 	if bid {
 		delete(ob.BidLimits, l.Price)
-		for i := 0; i < len(ob.bids); i++ {
-			if ob.bids[i] == l {
-				ob.bids[i] = ob.bids[len(ob.bids)-1]
-				ob.bids = ob.bids[:len(ob.bids)-1]
-			}
-		}
+		ob.bids.Remove(l)
 	} else {
 		delete(ob.AskLimits, l.Price)
-		for i := 0; i < len(ob.asks); i++ {
-			if ob.asks[i] == l {
-				ob.asks[i] = ob.asks[len(ob.asks)-1]
-				ob.asks = ob.asks[:len(ob.asks)-1]
-			}
-		}
+		ob.asks.Remove(l)
+	}
+	ob.freeLimits = append(ob.freeLimits, l)
+}
+
+// newLimit returns an empty limit, reusing one a cleared level left behind
+// if there is one.
+func (ob *Orderbook) newLimit(price Decimal) *Limit {
+	n := len(ob.freeLimits)
+	if n == 0 {
+		return &Limit{Price: price, policy: ob.policy, ob: ob}
 	}
 
+	l := ob.freeLimits[n-1]
+	ob.freeLimits[n-1] = nil
+	ob.freeLimits = ob.freeLimits[:n-1]
+	*l = Limit{Price: price, policy: ob.policy, eligible: l.eligible[:0], ob: ob}
+	return l
+}
+
+// Order returns the resting or pending stop order with the given id, or nil.
+func (ob *Orderbook) Order(id int64) *Order {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	if o, ok := ob.Orders[id]; ok {
+		return o
+	}
+	return ob.Stops[id]
 }
 
 func (ob *Orderbook) CancelOrder(o *Order) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.cancelOrder(o, CancelRequested)
+	ob.repeg(time.Now().UnixNano())
+}
+
+func (ob *Orderbook) cancelOrder(o *Order, reason CancelReason) {
+	if o.stopPending {
+		ob.stops.remove(o)
+		delete(ob.Stops, o.ID)
+		o.stopPending = false
+		ob.publishCanceled(o, o.Size, Zero, reason)
+		return
+	}
+
 	limit := o.Limit
 	limit.DeleteOrder(o)
 	delete(ob.Orders, o.ID)
 
-	if len(limit.Orders) == 0 {
+	if limit.count == 0 {
 		ob.clearLimits(o.Bid, limit)
 	}
+
+	ob.publishCanceled(o, o.Size.Add(o.reserve), Zero, reason)
+	if !o.Hidden {
+		ob.publishLevel(o.Bid, limit)
+	}
+	ob.publishIndicative()
 }
 
-func (ob *Orderbook) BidTotalVolume() float64 {
-	totalVolume := 0.0
+func (ob *Orderbook) BidTotalVolume() Decimal {
+	totalVolume := Zero
 
-	for _, limit := range ob.bids {
-		totalVolume += limit.TotalVolume
-	}
+	ob.bids.Each(func(limit *Limit) bool {
+		totalVolume = totalVolume.Add(limit.TotalVolume)
+		return true
+	})
 
 	return totalVolume
 }
 
-func (ob *Orderbook) AskTotalVolume() float64 {
-	totalVolume := 0.0
+func (ob *Orderbook) AskTotalVolume() Decimal {
+	totalVolume := Zero
 
-	for _, limit := range ob.asks {
-		totalVolume += limit.TotalVolume
-	}
+	ob.asks.Each(func(limit *Limit) bool {
+		totalVolume = totalVolume.Add(limit.TotalVolume)
+		return true
+	})
 
 	return totalVolume
 }
 
+// Asks returns the ask limits, lowest price first.
 func (ob *Orderbook) Asks() []*Limit {
-	// sorts in place
-	sort.Sort(ByBestAsk{ob.asks})
-	return ob.asks
+	return ob.asks.Limits()
 }
 
+// Bids returns the bid limits, highest price first.
 func (ob *Orderbook) Bids() []*Limit {
-	// sorts in place
-	sort.Sort(ByBestBid{ob.bids})
-	return ob.bids
+	return ob.bids.Limits()
+}
+
+// BestAsk returns the lowest ask limit with visible orders, or nil when
+// there is none.
+func (ob *Orderbook) BestAsk() *Limit {
+	return ob.asks.BestVisible()
+}
+
+// BestBid returns the highest bid limit with visible orders, or nil when
+// there is none.
+func (ob *Orderbook) BestBid() *Limit {
+	return ob.bids.BestVisible()
 }
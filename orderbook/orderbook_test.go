@@ -4,9 +4,12 @@ package orderbook
 // END: 1c2d3e4f5g6hpackage main
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func assert(t *testing.T, actual, expected interface{}) {
@@ -15,11 +18,15 @@ func assert(t *testing.T, actual, expected interface{}) {
 	}
 }
 
+func dec(f float64) Decimal {
+	return RequireFromFloat(f)
+}
+
 func TestLimit(t *testing.T) {
-	l := NewLimit(10_000)
-	buyOrderA := NewOrder(true, 5, 0)
-	buyOrderB := NewOrder(true, 10, 0)
-	buyOrderC := NewOrder(true, 25, 0)
+	l := NewLimit(dec(10_000))
+	buyOrderA := NewOrder(true, dec(5), 0)
+	buyOrderB := NewOrder(true, dec(10), 0)
+	buyOrderC := NewOrder(true, dec(25), 0)
 
 	l.AddOrder(buyOrderA)
 	l.AddOrder(buyOrderB)
@@ -36,10 +43,10 @@ func TestLimit(t *testing.T) {
 func TestPlaceLimitOrder(t *testing.T) {
 	ob := NewOrderbook()
 
-	sellOrderA := NewOrder(false, 10, 0)
-	sellOrderB := NewOrder(false, 5, 0)
-	ob.PlaceLimitOrder(10_000, sellOrderA)
-	ob.PlaceLimitOrder(9_000, sellOrderB)
+	sellOrderA := NewOrder(false, dec(10), 0)
+	sellOrderB := NewOrder(false, dec(5), 0)
+	ob.PlaceLimitOrder(dec(10_000), sellOrderA)
+	ob.PlaceLimitOrder(dec(9_000), sellOrderB)
 
 	assert(t, len(ob.Asks()), 2)
 	assert(t, len(ob.Orders), 2)
@@ -47,22 +54,299 @@ func TestPlaceLimitOrder(t *testing.T) {
 	assert(t, ob.Orders[sellOrderB.ID], sellOrderB)
 }
 
+func TestPlaceLimitOrderCrossing(t *testing.T) {
+	ob := NewOrderbook()
+
+	sellOrderA := NewOrder(false, dec(5), 0)
+	sellOrderB := NewOrder(false, dec(5), 0)
+	sellOrderC := NewOrder(false, dec(5), 0)
+	ob.PlaceLimitOrder(dec(10_000), sellOrderA)
+	ob.PlaceLimitOrder(dec(10_200), sellOrderB)
+	ob.PlaceLimitOrder(dec(11_000), sellOrderC)
+
+	// crosses the first two levels, the rest should rest at 10_500
+	buyOrderA := NewOrder(true, dec(12), 0)
+	matches, _ := ob.PlaceLimitOrder(dec(10_500), buyOrderA)
+
+	assert(t, len(matches), 2)
+	assert(t, matches[0].Price, dec(10_000))
+	assert(t, matches[0].Ask, sellOrderA)
+	assert(t, matches[1].Price, dec(10_200))
+	assert(t, matches[1].Ask, sellOrderB)
+	assert(t, buyOrderA.Size, dec(2))
+
+	assert(t, ob.asks.Len(), 1)
+	assert(t, ob.AskTotalVolume(), dec(5))
+	assert(t, ob.bids.Len(), 1)
+	assert(t, ob.BidTotalVolume(), dec(2))
+	assert(t, ob.Bids()[0].Price, dec(10_500))
+	assert(t, ob.Orders[buyOrderA.ID], buyOrderA)
+
+	_, ok := ob.Orders[sellOrderA.ID]
+	assert(t, ok, false)
+}
+
+func TestPlaceLimitOrderCrossingFullyFilled(t *testing.T) {
+	ob := NewOrderbook()
+
+	buyOrderA := NewOrder(true, dec(10), 0)
+	ob.PlaceLimitOrder(dec(9_000), buyOrderA)
+
+	sellOrderA := NewOrder(false, dec(4), 0)
+	matches, _ := ob.PlaceLimitOrder(dec(8_500), sellOrderA)
+
+	assert(t, len(matches), 1)
+	assert(t, matches[0].Price, dec(9_000))
+	assert(t, matches[0].SizeFilled, dec(4))
+	assert(t, sellOrderA.IsFilled(), true)
+	assert(t, sellOrderA.Limit == nil, true)
+	assert(t, ob.asks.Len(), 0)
+	assert(t, ob.BidTotalVolume(), dec(6))
+}
+
+func TestLimitRepeatedPartialFills(t *testing.T) {
+	ob := NewOrderbook()
+
+	sellOrderA := NewOrder(false, dec(1), 0)
+	ob.PlaceLimitOrder(dec(10_000), sellOrderA)
+
+	for i := 0; i < 10; i++ {
+		ob.PlaceMarketOrder(NewOrder(true, RequireFromString("0.1"), 0))
+	}
+
+	assert(t, sellOrderA.IsFilled(), true)
+	assert(t, ob.asks.Len(), 0)
+	assert(t, ob.AskTotalVolume(), Zero)
+}
+
+func TestPlaceLimitOrderImmediateOrCancel(t *testing.T) {
+	ob := NewOrderbook()
+
+	sellOrderA := NewOrder(false, dec(5), 0)
+	ob.PlaceLimitOrder(dec(10_000), sellOrderA)
+
+	buyOrderA := NewOrder(true, dec(8), 0)
+	buyOrderA.TimeInForce = ImmediateOrCancel
+	matches, err := ob.PlaceLimitOrder(dec(10_000), buyOrderA)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, buyOrderA.Size, dec(3))
+	assert(t, buyOrderA.Limit == nil, true)
+	assert(t, ob.bids.Len(), 0)
+	assert(t, ob.asks.Len(), 0)
+	assert(t, len(ob.Orders), 0)
+}
+
+func TestPlaceLimitOrderFillOrKill(t *testing.T) {
+	ob := NewOrderbook()
+
+	sellOrderA := NewOrder(false, dec(5), 0)
+	sellOrderB := NewOrder(false, dec(5), 0)
+	ob.PlaceLimitOrder(dec(10_000), sellOrderA)
+	ob.PlaceLimitOrder(dec(10_100), sellOrderB)
+
+	// only 5 is available at or below 10_050
+	buyOrderA := NewOrder(true, dec(8), 0)
+	buyOrderA.TimeInForce = FillOrKill
+	matches, err := ob.PlaceLimitOrder(dec(10_050), buyOrderA)
+
+	assert(t, err, ErrFillOrKill)
+	assert(t, len(matches), 0)
+	assert(t, buyOrderA.Size, dec(8))
+	assert(t, ob.AskTotalVolume(), dec(10))
+	assert(t, ob.bids.Len(), 0)
+
+	buyOrderB := NewOrder(true, dec(8), 0)
+	buyOrderB.TimeInForce = FillOrKill
+	matches, err = ob.PlaceLimitOrder(dec(10_100), buyOrderB)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, buyOrderB.IsFilled(), true)
+	assert(t, ob.AskTotalVolume(), dec(2))
+}
+
+func TestPlaceLimitOrderGoodTilDate(t *testing.T) {
+	ob := NewOrderbook()
+	now := time.Now().UnixNano()
+
+	expired := NewOrder(true, dec(5), 0)
+	expired.TimeInForce = GoodTilDate
+	expired.ExpiresAt = now - 1
+	_, err := ob.PlaceLimitOrder(dec(9_000), expired)
+	assert(t, err, ErrOrderExpired)
+
+	buyOrderA := NewOrder(true, dec(5), 0)
+	buyOrderA.TimeInForce = GoodTilDate
+	buyOrderA.ExpiresAt = now + int64(time.Hour)
+	buyOrderB := NewOrder(true, dec(5), 0)
+	buyOrderB.TimeInForce = GoodTilDate
+	buyOrderB.ExpiresAt = now + int64(2*time.Hour)
+	ob.PlaceLimitOrder(dec(9_000), buyOrderA)
+	ob.PlaceLimitOrder(dec(9_000), buyOrderB)
+
+	assert(t, len(ob.ExpireOrders(now)), 0)
+	assert(t, ob.BidTotalVolume(), dec(10))
+
+	assert(t, ob.ExpireOrders(now+int64(time.Hour)), []*Order{buyOrderA})
+	assert(t, ob.BidTotalVolume(), dec(5))
+	_, ok := ob.Orders[buyOrderA.ID]
+	assert(t, ok, false)
+
+	// a cancelled order is skipped when its expiry comes up
+	ob.CancelOrder(buyOrderB)
+	assert(t, len(ob.ExpireOrders(now+int64(3*time.Hour))), 0)
+	assert(t, ob.bids.Len(), 0)
+}
+
+func TestPlaceLimitOrderInvalidTimeInForce(t *testing.T) {
+	ob := NewOrderbook()
+
+	buyOrderA := NewOrder(true, dec(5), 0)
+	buyOrderA.TimeInForce = "DAY"
+	_, err := ob.PlaceLimitOrder(dec(9_000), buyOrderA)
+
+	assert(t, errors.Is(err, ErrInvalidTimeInForce), true)
+	assert(t, ob.bids.Len(), 0)
+}
+
+func TestPlaceLimitOrderPostOnly(t *testing.T) {
+	ob := NewOrderbook(WithTickSize(dec(1)))
+
+	sellOrderA := NewOrder(false, dec(5), 0)
+	buyOrderA := NewOrder(true, dec(5), 0)
+	ob.PlaceLimitOrder(dec(10_000), sellOrderA)
+	ob.PlaceLimitOrder(dec(9_000), buyOrderA)
+
+	// does not cross, rests at its own price
+	buyOrderB := NewOrder(true, dec(1), 0)
+	buyOrderB.PostOnly = true
+	matches, err := ob.PlaceLimitOrder(dec(9_500), buyOrderB)
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, buyOrderB.Limit.Price, dec(9_500))
+
+	buyOrderC := NewOrder(true, dec(1), 0)
+	buyOrderC.PostOnly = true
+	buyOrderC.PostOnlyMode = PostOnlyReject
+	_, err = ob.PlaceLimitOrder(dec(10_000), buyOrderC)
+	assert(t, err, ErrPostOnlyWouldCross)
+	assert(t, buyOrderC.Limit == nil, true)
+	assert(t, ob.AskTotalVolume(), dec(5))
+
+	buyOrderD := NewOrder(true, dec(1), 0)
+	buyOrderD.PostOnly = true
+	buyOrderD.PostOnlyMode = PostOnlySlide
+	matches, err = ob.PlaceLimitOrder(dec(10_500), buyOrderD)
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, buyOrderD.Limit.Price, dec(9_999))
+	assert(t, ob.AskTotalVolume(), dec(5))
+
+	sellOrderB := NewOrder(false, dec(1), 0)
+	sellOrderB.PostOnly = true
+	sellOrderB.PostOnlyMode = PostOnlySlide
+	_, err = ob.PlaceLimitOrder(dec(9_000), sellOrderB)
+	assert(t, err, nil)
+	assert(t, sellOrderB.Limit.Price, dec(10_000))
+
+	buyOrderE := NewOrder(true, dec(1), 0)
+	buyOrderE.PostOnly = true
+	buyOrderE.TimeInForce = ImmediateOrCancel
+	_, err = ob.PlaceLimitOrder(dec(9_000), buyOrderE)
+	assert(t, err, ErrPostOnlyTimeInForce)
+
+	// an unknown mode is rejected even when the order would not cross
+	buyOrderF := NewOrder(true, dec(1), 0)
+	buyOrderF.PostOnly = true
+	buyOrderF.PostOnlyMode = "slide"
+	_, err = ob.PlaceLimitOrder(dec(8_000), buyOrderF)
+	assert(t, errors.Is(err, ErrInvalidPostOnlyMode), true)
+	assert(t, buyOrderF.Limit == nil, true)
+}
+
+func TestIcebergOrder(t *testing.T) {
+	ob := NewOrderbook()
+
+	iceberg := NewOrder(false, dec(10), 8)
+	iceberg.DisplaySize = dec(2)
+	sellOrderA := NewOrder(false, dec(1), 0)
+	ob.PlaceLimitOrder(dec(10_000), iceberg)
+	ob.PlaceLimitOrder(dec(10_000), sellOrderA)
+
+	// only the display slice is visible
+	limit := ob.AskLimits[dec(10_000)]
+	assert(t, iceberg.Size, dec(2))
+	assert(t, iceberg.Reserve(), dec(8))
+	assert(t, limit.TotalVolume, dec(3))
+	assert(t, ob.AskTotalVolume(), dec(3))
+
+	// fills the slice, the next slice goes behind sellOrderA
+	matches, _ := ob.PlaceMarketOrder(NewOrder(true, dec(2), 0))
+	assert(t, len(matches), 1)
+	assert(t, limit.Orders(), Orders{sellOrderA, iceberg})
+	assert(t, iceberg.Size, dec(2))
+	assert(t, iceberg.Reserve(), dec(6))
+	assert(t, limit.TotalVolume, dec(3))
+
+	// takes sellOrderA then keeps going through the replenished slices
+	matches, _ = ob.PlaceMarketOrder(NewOrder(true, dec(6), 0))
+	assert(t, len(matches), 4)
+	assert(t, matches[0].Ask, sellOrderA)
+	assert(t, matches[1].SizeFilled, dec(2))
+	assert(t, matches[2].SizeFilled, dec(2))
+	assert(t, matches[3].SizeFilled, dec(1))
+	assert(t, iceberg.Size, dec(1))
+	assert(t, iceberg.Reserve(), dec(2))
+	assert(t, limit.TotalVolume, dec(1))
+
+	// more than is visible, but the reserve covers it
+	matches, _ = ob.PlaceMarketOrder(NewOrder(true, dec(3), 0))
+	assert(t, len(matches), 2)
+	assert(t, iceberg.IsFilled(), true)
+	assert(t, ob.asks.Len(), 0)
+	_, ok := ob.Orders[iceberg.ID]
+	assert(t, ok, false)
+}
+
+func TestIcebergOrderTakesFullSize(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(3), 0))
+
+	// matches with its full size before resting the rest as an iceberg
+	iceberg := NewOrder(true, dec(10), 0)
+	iceberg.DisplaySize = dec(2)
+	matches, err := ob.PlaceLimitOrder(dec(10_000), iceberg)
+
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, matches[0].SizeFilled, dec(3))
+	assert(t, iceberg.Size, dec(2))
+	assert(t, iceberg.Reserve(), dec(5))
+	assert(t, ob.BidTotalVolume(), dec(2))
+
+	ob.CancelOrder(iceberg)
+	assert(t, ob.bids.Len(), 0)
+}
+
 func TestPlaceMarketOrder(t *testing.T) {
 	ob := NewOrderbook()
 
-	sellOrderA := NewOrder(false, 20, 0)
-	ob.PlaceLimitOrder(10_000, sellOrderA)
+	sellOrderA := NewOrder(false, dec(20), 0)
+	ob.PlaceLimitOrder(dec(10_000), sellOrderA)
 
-	buyOrderA := NewOrder(true, 10, 0)
-	matches := ob.PlaceMarketOrder(buyOrderA)
+	buyOrderA := NewOrder(true, dec(10), 0)
+	matches, _ := ob.PlaceMarketOrder(buyOrderA)
 
 	assert(t, len(matches), 1)
-	assert(t, len(ob.asks), 1)
-	assert(t, len(ob.bids), 0)
-	assert(t, ob.AskTotalVolume(), 10.0)
+	assert(t, ob.asks.Len(), 1)
+	assert(t, ob.bids.Len(), 0)
+	assert(t, ob.AskTotalVolume(), dec(10))
 	assert(t, matches[0].Ask, sellOrderA)
 	assert(t, matches[0].Bid, buyOrderA)
-	assert(t, matches[0].Price, 10_000.0)
+	assert(t, matches[0].Price, dec(10_000))
 	assert(t, buyOrderA.IsFilled(), true)
 
 	fmt.Printf("%+v", matches)
@@ -71,114 +355,202 @@ func TestPlaceMarketOrder(t *testing.T) {
 func TestPlaceMarketOrderMultiFill(t *testing.T) {
 	ob := NewOrderbook()
 
-	buyOrderA := NewOrder(true, 5, 0) // fully filled
-	buyOrderB := NewOrder(true, 8, 0) // partially filled
-	buyOrderC := NewOrder(true, 1, 0) // un filled
-	buyOrderD := NewOrder(true, 1, 0) // un filled
+	buyOrderA := NewOrder(true, dec(5), 0) // fully filled
+	buyOrderB := NewOrder(true, dec(8), 0) // partially filled
+	buyOrderC := NewOrder(true, dec(1), 0) // un filled
+	buyOrderD := NewOrder(true, dec(1), 0) // un filled
 
-	ob.PlaceLimitOrder(5_000, buyOrderC)
-	ob.PlaceLimitOrder(5_000, buyOrderD)
-	ob.PlaceLimitOrder(9_000, buyOrderB)
-	ob.PlaceLimitOrder(10_000, buyOrderA)
+	ob.PlaceLimitOrder(dec(5_000), buyOrderC)
+	ob.PlaceLimitOrder(dec(5_000), buyOrderD)
+	ob.PlaceLimitOrder(dec(9_000), buyOrderB)
+	ob.PlaceLimitOrder(dec(10_000), buyOrderA)
 
 	// when we place a sell market order we want to fill the highest price first
 	// theerfore we should be left with a order at 5_000 for 3
 
-	assert(t, ob.BidTotalVolume(), 15.0)
-	assert(t, len(ob.bids), 3)
+	assert(t, ob.BidTotalVolume(), dec(15))
+	assert(t, ob.bids.Len(), 3)
 
-	sellOrderA := NewOrder(false, 10, 0)
-	matches := ob.PlaceMarketOrder(sellOrderA)
+	sellOrderA := NewOrder(false, dec(10), 0)
+	matches, _ := ob.PlaceMarketOrder(sellOrderA)
 
 	assert(t, len(matches), 2)
 	// need to make sure that the filled orders are removed from the orderbook
-	// assert(t, ob.BidTotalVolume(), 5.0)
+	// assert(t, ob.BidTotalVolume(), dec(5))
 	// assert(t, sellOrderA.IsFilled(), true)
-	assert(t, len(ob.bids), 2)
-	// assert(t, len(ob.bids[0].Orders), 2)
+	assert(t, ob.bids.Len(), 2)
+	// assert(t, ob.Bids()[0].Len(), 2)
 }
 
 func TestPlaceMarketOrderMultiFillWithReversedSamePriceBid(t *testing.T) {
 	ob := NewOrderbook()
 
-	buyOrderA := NewOrder(true, 5, 0)
-	buyOrderB := NewOrder(true, 8, 0)
-	buyOrderC := NewOrder(true, 10, 0)
-	buyOrderD := NewOrder(true, 1, 0)
+	buyOrderA := NewOrder(true, dec(5), 0)
+	buyOrderB := NewOrder(true, dec(8), 0)
+	buyOrderC := NewOrder(true, dec(10), 0)
+	buyOrderD := NewOrder(true, dec(1), 0)
 
-	ob.PlaceLimitOrder(5_000, buyOrderD)
-	ob.PlaceLimitOrder(5_000, buyOrderC)
-	ob.PlaceLimitOrder(9_000, buyOrderB)
-	ob.PlaceLimitOrder(10_000, buyOrderA)
+	ob.PlaceLimitOrder(dec(5_000), buyOrderD)
+	ob.PlaceLimitOrder(dec(5_000), buyOrderC)
+	ob.PlaceLimitOrder(dec(9_000), buyOrderB)
+	ob.PlaceLimitOrder(dec(10_000), buyOrderA)
 
 	// when we place a sell market order we want to fill the highest price first
 	// theerfore we should be left with a order at 5_000 for 3
 
-	assert(t, ob.BidTotalVolume(), 24.0)
-	assert(t, len(ob.bids), 3)
+	assert(t, ob.BidTotalVolume(), dec(24))
+	assert(t, ob.bids.Len(), 3)
 
-	sellOrderA := NewOrder(false, 22, 0)
-	matches := ob.PlaceMarketOrder(sellOrderA)
+	sellOrderA := NewOrder(false, dec(22), 0)
+	matches, _ := ob.PlaceMarketOrder(sellOrderA)
 
 	assert(t, len(matches), 4)
 	// need to make sure that the filled orders are removed from the orderbook
-	assert(t, ob.BidTotalVolume(), 2.0)
+	assert(t, ob.BidTotalVolume(), dec(2))
 	assert(t, sellOrderA.IsFilled(), true)
-	assert(t, len(ob.bids), 1)
-	assert(t, len(ob.bids[0].Orders), 1)
+	assert(t, ob.bids.Len(), 1)
+	assert(t, ob.Bids()[0].Len(), 1)
 
 }
 
 func TestCancelOrder(t *testing.T) {
 	ob := NewOrderbook()
 
-	buyOrderA := NewOrder(true, 5, 0)
-	buyOrderB := NewOrder(true, 8, 0)
-	buyOrderC := NewOrder(true, 10, 0)
-	buyOrderD := NewOrder(true, 1, 0)
+	buyOrderA := NewOrder(true, dec(5), 0)
+	buyOrderB := NewOrder(true, dec(8), 0)
+	buyOrderC := NewOrder(true, dec(10), 0)
+	buyOrderD := NewOrder(true, dec(1), 0)
 
-	ob.PlaceLimitOrder(5_000, buyOrderD)
-	ob.PlaceLimitOrder(5_000, buyOrderC)
-	ob.PlaceLimitOrder(9_000, buyOrderB)
-	ob.PlaceLimitOrder(10_000, buyOrderA)
+	ob.PlaceLimitOrder(dec(5_000), buyOrderD)
+	ob.PlaceLimitOrder(dec(5_000), buyOrderC)
+	ob.PlaceLimitOrder(dec(9_000), buyOrderB)
+	ob.PlaceLimitOrder(dec(10_000), buyOrderA)
 
-	assert(t, len(ob.bids), 3)
-	assert(t, ob.BidTotalVolume(), 24.0)
+	assert(t, ob.bids.Len(), 3)
+	assert(t, ob.BidTotalVolume(), dec(24))
 
 	assert(t, len(ob.Orders), 4)
 	ob.CancelOrder(buyOrderB)
 
-	assert(t, len(ob.bids), 2)
-	assert(t, ob.BidTotalVolume(), 16.0)
+	assert(t, ob.bids.Len(), 2)
+	assert(t, ob.BidTotalVolume(), dec(16))
 
 	assert(t, len(ob.Orders), 3)
 
 	_, ok := ob.Orders[buyOrderB.ID]
 	assert(t, ok, false)
 
-	_, ok = ob.BidLimits[9_000]
+	_, ok = ob.BidLimits[dec(9_000)]
 	assert(t, ok, false)
 }
 
 func TestCancelOrderAsk(t *testing.T) {
 	ob := NewOrderbook()
 
-	sellOrderA := NewOrder(false, 5, 0)
+	sellOrderA := NewOrder(false, dec(5), 0)
 
-	ob.PlaceLimitOrder(10_000, sellOrderA)
+	ob.PlaceLimitOrder(dec(10_000), sellOrderA)
 
-	assert(t, len(ob.asks), 1)
-	assert(t, ob.AskTotalVolume(), 5.0)
+	assert(t, ob.asks.Len(), 1)
+	assert(t, ob.AskTotalVolume(), dec(5))
 
 	assert(t, len(ob.Orders), 1)
 	ob.CancelOrder(sellOrderA)
 
-	assert(t, len(ob.asks), 0)
-	assert(t, ob.AskTotalVolume(), 0.0)
+	assert(t, ob.asks.Len(), 0)
+	assert(t, ob.AskTotalVolume(), dec(0))
 
 	_, ok := ob.Orders[sellOrderA.ID]
 	assert(t, ok, false)
 
-	_, ok = ob.AskLimits[10_000]
+	_, ok = ob.AskLimits[dec(10_000)]
 	assert(t, ok, false)
 }
+
+// BenchmarkOrderFlow is a steady flow of orders against a book a few levels
+// deep: most orders are quotes resting near the spread, some of them are
+// cancelled and the rest cross the spread as IOC limit or market orders.
+// Orders come from the pool and the match buffer is reused, the way a
+// caller that cares about allocations would drive the book. Each op is one
+// order or cancel.
+func BenchmarkOrderFlow(b *testing.B) {
+	benchmarkOrderFlow(b, NewOrderbook())
+}
+
+// BenchmarkOrderFlowBands is BenchmarkOrderFlow in a book with the price
+// band and the circuit breaker the server runs with. Every order works out
+// the moving average of the band's window and every trade checks the move
+// within the breaker's, neither may grow with the trades in them.
+func BenchmarkOrderFlowBands(b *testing.B) {
+	benchmarkOrderFlow(b, NewOrderbook(
+		WithPriceBand(PriceBand{Width: dec(0.2), Window: 5 * time.Minute}),
+		WithCircuitBreaker(CircuitBreaker{MaxMove: dec(0.15), Window: time.Minute, Halt: 5 * time.Minute}),
+	))
+}
+
+func benchmarkOrderFlow(b *testing.B, ob *Orderbook) {
+	r := rand.New(rand.NewSource(1))
+
+	var (
+		matches []Match
+		quotes  [1024]int64
+		err     error
+	)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bid := r.Intn(2) == 0
+		size := RequireFromInt(int64(1 + r.Intn(5)))
+
+		var o *Order
+		matches = matches[:0]
+
+		switch n := r.Intn(20); {
+		case n < 12:
+			o = AcquireOrder(bid, size, 1)
+			price := int64(96 + r.Intn(4))
+			if !bid {
+				price += 5
+			}
+			matches, err = ob.AppendLimitOrder(matches, RequireFromInt(price), o)
+			quotes[i%len(quotes)] = o.ID
+		case n < 14:
+			if resting := ob.Order(quotes[r.Intn(len(quotes))]); resting != nil {
+				ob.CancelOrder(resting)
+				ReleaseOrder(resting)
+			}
+			continue
+		case n < 17:
+			o = AcquireOrder(bid, size, 2)
+			o.TimeInForce = ImmediateOrCancel
+			price := int64(102)
+			if !bid {
+				price = 98
+			}
+			matches, err = ob.AppendLimitOrder(matches, RequireFromInt(price), o)
+		default:
+			o = AcquireOrder(bid, size, 2)
+			o.AllowPartialFill = true
+			matches, err = ob.AppendMarketOrder(matches, o)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for _, m := range matches {
+			resting := m.Ask
+			if !bid {
+				resting = m.Bid
+			}
+			if resting.IsFilled() {
+				ReleaseOrder(resting)
+			}
+		}
+		if o.Limit == nil {
+			ReleaseOrder(o)
+		}
+	}
+
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "orders/s")
+}
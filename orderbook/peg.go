@@ -0,0 +1,230 @@
+package orderbook
+
+import (
+	"errors"
+	"time"
+)
+
+// PegReference is the price a pegged order follows.
+type PegReference string
+
+const (
+	PegBestBid PegReference = "BEST_BID"
+	PegBestAsk PegReference = "BEST_ASK"
+	// PegMid follows the midpoint between the best bid and the best ask.
+	PegMid PegReference = "MID"
+)
+
+var (
+	ErrInvalidPeg        = errors.New("invalid peg reference")
+	ErrNoPegPrice        = errors.New("no reference price to peg the order to")
+	ErrPeggedTimeInForce = errors.New("pegged orders must be GTC or GTD")
+	ErrPeggedAuction     = errors.New("pegged orders are not accepted during an auction")
+	ErrPeggedPlacement   = errors.New("pegged orders are placed with PlacePeggedOrder")
+	ErrPeggedAmendPrice  = errors.New("pegged orders follow their reference, only their size can be amended")
+)
+
+// OrderRepegged is published when a pegged order moves to a new price
+// because the price it follows changed. It goes to the back of the queue
+// there.
+type OrderRepegged struct {
+	OrderID int64
+	UserID  int64
+	Bid     bool
+	Price   Decimal
+}
+
+func (OrderRepegged) event() {}
+
+// PlacePeggedOrder rests o at o.PegOffset from the price o.Peg names,
+// rounded to the tick size away from the other side, and moves it whenever
+// that price changes. Only visible orders that are not pegged themselves
+// set the best bid and ask a peg follows.
+//
+// Pegged orders never take liquidity: a price that would cross the book is
+// moved one tick inside it instead. When the reference is gone, like the
+// midpoint of a book with one side empty, the order stays where it is.
+func (ob *Orderbook) PlacePeggedOrder(o *Order) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	ob.expireOrders(now)
+	defer ob.repeg(now)
+
+	if ob.halted(now) {
+		return ErrTradingHalted
+	}
+	if err := ob.assignID(o); err != nil {
+		return err
+	}
+	if ob.auction {
+		return ErrPeggedAuction
+	}
+
+	switch o.Peg {
+	case PegBestBid, PegBestAsk, PegMid:
+	default:
+		return ErrInvalidPeg
+	}
+	if !o.QuoteSize.IsZero() {
+		return ErrQuoteSizeMarketOnly
+	}
+	if !o.Size.IsPositive() {
+		return ErrInvalidSize
+	}
+	if o.TimeInForce == ImmediateOrCancel || o.TimeInForce == FillOrKill {
+		return ErrPeggedTimeInForce
+	}
+	if err := o.TimeInForce.validate(o, now); err != nil {
+		return err
+	}
+	if o.DisplaySize.IsNegative() {
+		return ErrInvalidDisplaySize
+	}
+	if o.Hidden && o.DisplaySize.IsPositive() {
+		return ErrHiddenIceberg
+	}
+	if err := o.checkConditions(); err != nil {
+		return err
+	}
+	if err := o.SelfTradePrevention.Validate(); err != nil {
+		return err
+	}
+
+	price, ok := ob.pegPrice(o, anchorPrice(ob.bids), anchorPrice(ob.asks))
+	if !ok {
+		return ErrNoPegPrice
+	}
+	if err := ob.checkBand(price, now); err != nil {
+		return err
+	}
+
+	ob.publishAccepted(price, o)
+	ob.restLimitOrder(price, o)
+	ob.pegged = append(ob.pegged, o)
+
+	return nil
+}
+
+// anchorPrice returns the best price of pl with a visible order that is not
+// pegged, or zero when there is none.
+func anchorPrice(pl *priceLevels) Decimal {
+	price := Zero
+	pl.Each(func(l *Limit) bool {
+		for o := l.head; o != nil; o = o.next {
+			if !o.Hidden && o.Peg == "" {
+				price = l.Price
+				return false
+			}
+		}
+		return true
+	})
+	return price
+}
+
+// pegPrice returns the price o should rest at given the best bid and ask
+// it follows, zero when there is none. It reports false when there is no
+// price to peg to.
+func (ob *Orderbook) pegPrice(o *Order, bid, ask Decimal) (Decimal, bool) {
+	var ref Decimal
+	switch o.Peg {
+	case PegBestBid:
+		ref = bid
+	case PegBestAsk:
+		ref = ask
+	case PegMid:
+		if bid.IsZero() || ask.IsZero() {
+			return Zero, false
+		}
+		ref = bid.Add(ask).Div(RequireFromInt(2))
+	}
+	if ref.IsZero() {
+		return Zero, false
+	}
+
+	price := ref.Add(o.PegOffset)
+	if !price.IsPositive() {
+		return Zero, false
+	}
+	if ob.tickSize.IsPositive() {
+		price = bucket(price, ob.tickSize, o.Bid)
+	}
+
+	if o.Bid {
+		if best := ob.asks.Best(); best != nil && price.GreaterThanOrEqual(best.Price) {
+			price = best.Price.Sub(ob.tickSize)
+		}
+	} else if best := ob.bids.Best(); best != nil && price.LessThanOrEqual(best.Price) {
+		price = best.Price.Add(ob.tickSize)
+	}
+	return price, price.IsPositive()
+}
+
+// repeg moves every pegged order whose reference price changed. It runs
+// after everything that can change the book and does nothing during an
+// auction, when the book may be crossed. A pegged order whose new price is
+// outside the price band stays where it is.
+func (ob *Orderbook) repeg(now int64) {
+	if len(ob.pegged) == 0 || ob.auction {
+		return
+	}
+
+	// drop the orders that left the book
+	pegged := ob.pegged[:0]
+	for _, o := range ob.pegged {
+		if o.Limit != nil && ob.Orders[o.ID] == o {
+			pegged = append(pegged, o)
+		}
+	}
+	for i := len(pegged); i < len(ob.pegged); i++ {
+		ob.pegged[i] = nil
+	}
+	ob.pegged = pegged
+
+	// pegged orders do not move the reference, but one that moved can stop
+	// or start keeping an opposite one off its price, so go again until
+	// nothing moves
+	bid, ask := anchorPrice(ob.bids), anchorPrice(ob.asks)
+	for pass := 0; pass <= len(pegged); pass++ {
+		moved := false
+		for _, o := range pegged {
+			price, ok := ob.pegPrice(o, bid, ask)
+			if !ok || price.Equal(o.Limit.Price) || ob.checkBand(price, now) != nil {
+				continue
+			}
+			ob.movePegged(o, price)
+			moved = true
+		}
+		if !moved {
+			return
+		}
+	}
+}
+
+// movePegged requeues o at the back of the level at price.
+func (ob *Orderbook) movePegged(o *Order, price Decimal) {
+	old := o.Limit
+	old.DeleteOrder(o)
+	if old.count == 0 {
+		ob.clearLimits(o.Bid, old)
+	}
+	if !o.Hidden {
+		ob.publishLevel(o.Bid, old)
+	}
+
+	o.Timestamp = time.Now().UnixNano()
+	ob.addLimitOrder(price, o)
+	if !o.Hidden {
+		ob.publishLevel(o.Bid, o.Limit)
+	}
+
+	if len(ob.listeners) > 0 {
+		ob.publish(OrderRepegged{
+			OrderID: o.ID,
+			UserID:  o.UserID,
+			Bid:     o.Bid,
+			Price:   price,
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package orderbook
+
+import "testing"
+
+func TestPeggedOrderFollowsBestBid(t *testing.T) {
+	ob := NewOrderbook(WithTickSize(dec(0.5)))
+	ob.PlaceLimitOrder(dec(99), NewOrder(true, dec(1), 0))
+	ob.PlaceLimitOrder(dec(102), NewOrder(false, dec(1), 0))
+
+	pegged := NewOrder(true, dec(2), 1)
+	pegged.Peg = PegBestBid
+	pegged.PegOffset = dec(0.5)
+	assert(t, ob.PlacePeggedOrder(pegged), nil)
+	assert(t, pegged.Limit.Price, dec(99.5))
+
+	// a better bid moves it up
+	better := NewOrder(true, dec(1), 0)
+	ob.PlaceLimitOrder(dec(100), better)
+	assert(t, pegged.Limit.Price, dec(100.5))
+	assert(t, ob.BidLimits[dec(99.5)] == nil, true)
+
+	// and cancelling that bid moves it back
+	ob.CancelOrder(better)
+	assert(t, pegged.Limit.Price, dec(99.5))
+
+	// an offset past the asks stops one tick inside the book
+	pegged = NewOrder(true, dec(1), 1)
+	pegged.Peg = PegBestBid
+	pegged.PegOffset = dec(5)
+	assert(t, ob.PlacePeggedOrder(pegged), nil)
+	assert(t, pegged.Limit.Price, dec(101.5))
+}
+
+func TestPeggedOrderMidpoint(t *testing.T) {
+	ob := NewOrderbook(WithTickSize(dec(1)))
+	ob.PlaceLimitOrder(dec(100), NewOrder(true, dec(1), 0))
+	ask := NewOrder(false, dec(1), 0)
+	ob.PlaceLimitOrder(dec(105), ask)
+
+	var repegged []OrderRepegged
+	ob.AddListener(ListenerFunc(func(e Event) {
+		if r, ok := e.(OrderRepegged); ok {
+			repegged = append(repegged, r)
+		}
+	}))
+
+	// 102.5 rounds away from the asks for a bid and from the bids for an ask
+	bid := NewOrder(true, dec(1), 1)
+	bid.Peg = PegMid
+	assert(t, ob.PlacePeggedOrder(bid), nil)
+	sell := NewOrder(false, dec(1), 2)
+	sell.Peg = PegMid
+	sell.Hidden = true
+	assert(t, ob.PlacePeggedOrder(sell), nil)
+	assert(t, bid.Limit.Price, dec(102))
+	assert(t, sell.Limit.Price, dec(103))
+	assert(t, ob.AskTotalVolume(), dec(1))
+
+	// filling the ask that anchors the midpoint moves both, the hidden
+	// pegged ask fills first since it is the best ask
+	matches, err := ob.PlaceLimitOrder(dec(105), NewOrder(true, dec(2), 3))
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, matches[0].Ask, sell)
+	assert(t, ob.Order(sell.ID) == nil, true)
+	assert(t, len(repegged), 0)
+
+	ob.PlaceLimitOrder(dec(110), NewOrder(false, dec(1), 0))
+	assert(t, bid.Limit.Price, dec(105))
+	assert(t, len(repegged), 1)
+	assert(t, repegged[0].OrderID, bid.ID)
+
+	// without an ask there is no midpoint and the order stays put
+	ob.PlaceMarketOrder(NewOrder(true, dec(1), 3))
+	assert(t, bid.Limit.Price, dec(105))
+}
+
+func TestPeggedOrderAmend(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(1), 0))
+
+	pegged := NewOrder(false, dec(2), 1)
+	pegged.Peg = PegBestAsk
+	assert(t, ob.PlacePeggedOrder(pegged), nil)
+
+	_, err := ob.AmendOrder(pegged, dec(101), dec(2))
+	assert(t, err, ErrPeggedAmendPrice)
+
+	_, err = ob.AmendOrder(pegged, dec(100), dec(5))
+	assert(t, err, nil)
+	assert(t, pegged.Size, dec(5))
+
+	// still pegged after the amend
+	ob.PlaceLimitOrder(dec(99), NewOrder(false, dec(1), 0))
+	assert(t, pegged.Limit.Price, dec(99))
+}
+
+func TestPlacePeggedOrderInvalid(t *testing.T) {
+	ob := NewOrderbook()
+
+	o := NewOrder(true, dec(1), 1)
+	o.Peg = "LAST"
+	assert(t, ob.PlacePeggedOrder(o), ErrInvalidPeg)
+
+	o = NewOrder(true, dec(1), 1)
+	o.Peg = PegBestBid
+	assert(t, ob.PlacePeggedOrder(o), ErrNoPegPrice)
+
+	ob.PlaceLimitOrder(dec(100), NewOrder(true, dec(1), 0))
+	o = NewOrder(true, dec(1), 1)
+	o.Peg = PegBestBid
+	o.TimeInForce = ImmediateOrCancel
+	assert(t, ob.PlacePeggedOrder(o), ErrPeggedTimeInForce)
+
+	o = NewOrder(true, dec(1), 1)
+	o.Peg = PegBestBid
+	_, err := ob.PlaceLimitOrder(dec(100), o)
+	assert(t, err, ErrPeggedPlacement)
+
+	ob.StartAuction(0)
+	o = NewOrder(true, dec(1), 1)
+	o.Peg = PegBestBid
+	assert(t, ob.PlacePeggedOrder(o), ErrPeggedAuction)
+}
@@ -0,0 +1,70 @@
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PostOnlyMode selects what happens to a post-only order that would take
+// liquidity on arrival.
+type PostOnlyMode string
+
+const (
+	// PostOnlyReject rejects the order with ErrPostOnlyWouldCross.
+	PostOnlyReject PostOnlyMode = "REJECT"
+	// PostOnlySlide reprices the order one tick passive of the opposite best
+	// price so it rests instead.
+	PostOnlySlide PostOnlyMode = "SLIDE"
+)
+
+var (
+	ErrPostOnlyWouldCross  = errors.New("post only order would cross the book")
+	ErrPostOnlyTimeInForce = errors.New("post only orders must be GTC or GTD")
+	ErrInvalidPostOnlyMode = errors.New("invalid post only mode")
+)
+
+// checkPostOnly validates the time in force and mode of a post-only order
+// when it is placed, whether or not it would cross.
+func (o *Order) checkPostOnly() error {
+	if !o.PostOnly {
+		return nil
+	}
+	if o.TimeInForce == ImmediateOrCancel || o.TimeInForce == FillOrKill {
+		return ErrPostOnlyTimeInForce
+	}
+	switch o.PostOnlyMode {
+	case "", PostOnlyReject, PostOnlySlide:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidPostOnlyMode, string(o.PostOnlyMode))
+	}
+}
+
+// postOnlyPrice returns the price a post-only order should rest at, which is
+// price itself unless it would cross the opposite best price. o has passed
+// checkPostOnly.
+func (ob *Orderbook) postOnlyPrice(price Decimal, o *Order) (Decimal, error) {
+	var crosses bool
+	best := ob.bids.Best()
+	if o.Bid {
+		best = ob.asks.Best()
+		crosses = best != nil && price.GreaterThanOrEqual(best.Price)
+	} else {
+		crosses = best != nil && price.LessThanOrEqual(best.Price)
+	}
+
+	if !crosses {
+		return price, nil
+	}
+
+	if o.PostOnlyMode != PostOnlySlide {
+		return price, ErrPostOnlyWouldCross
+	}
+	if !o.Bid {
+		return best.Price.Add(ob.tickSize), nil
+	}
+	if slid := best.Price.Sub(ob.tickSize); slid.IsPositive() {
+		return slid, nil
+	}
+	return price, ErrPostOnlyWouldCross
+}
@@ -0,0 +1,453 @@
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The property tests replay a sequence of random operations against a book
+// and check its invariants after every one. The operations are decoded from
+// bytes, opSize per operation, so the same sequence can come from a seeded
+// generator, from the fuzzer or from a corpus file:
+//
+//	go test -run TestOrderbookProperties ./orderbook
+//	go test -fuzz FuzzOrderbook ./orderbook
+//
+// The first byte picks the matching policy. A failing sequence is shrunk to
+// the fewest operations that still fail and written to
+// testdata/fuzz/FuzzOrderbook, where go test replays it from then on.
+
+const opSize = 6
+
+type opKind byte
+
+const (
+	opLimit opKind = iota
+	opMarket
+	opCancel
+	opAmend
+)
+
+// op is one decoded operation.
+//
+//	byte 0: kind
+//	byte 1: flags, bit 0 bid, bit 1 post-only or partial fill, bit 2 slide,
+//	        bit 3 iceberg, bits 4-5 time in force, bit 6 hidden, bit 7
+//	        pegged, to the reference byte 2 picks at the offset byte 4 does
+//	byte 2: price, 95 to 105
+//	byte 3: size, 0.25 to 10
+//	byte 4: display size and user
+//	byte 5: which open order a cancel or amend picks, for other orders
+//	        MinQty 1 when 0 mod 8 or all-or-none when 1 mod 8, and bits
+//	        3-5 the self-trade prevention mode
+type op struct {
+	kind  opKind
+	flags byte
+	price byte
+	size  byte
+	extra byte
+	pick  byte
+}
+
+func decodeOps(data []byte) []op {
+	ops := make([]op, 0, len(data)/opSize)
+	for ; len(data) >= opSize; data = data[opSize:] {
+		// limit orders are half of all operations so the book does not
+		// drain, the rest are split evenly
+		kind := opLimit
+		if k := data[0] % 6; k >= 3 {
+			kind = opKind(k - 2)
+		}
+		ops = append(ops, op{
+			kind:  kind,
+			flags: data[1],
+			price: data[2],
+			size:  data[3],
+			extra: data[4],
+			pick:  data[5],
+		})
+	}
+	return ops
+}
+
+func (o op) bid() bool { return o.flags&1 != 0 }
+
+func (o op) priceDecimal() Decimal { return RequireFromInt(95 + int64(o.price%11)) }
+
+func (o op) sizeDecimal() Decimal {
+	return RequireFromInt(1 + int64(o.size%40)).Div(RequireFromInt(4))
+}
+
+func (o op) String() string {
+	return fmt.Sprintf("{kind: %d flags: %08b price: %s size: %s extra: %d pick: %d}", o.kind, o.flags, o.priceDecimal(), o.sizeDecimal(), o.extra, o.pick)
+}
+
+func (o op) order() *Order {
+	order := NewOrder(o.bid(), o.sizeDecimal(), int64(o.extra%3))
+	switch o.flags >> 4 % 4 {
+	case 1:
+		order.TimeInForce = ImmediateOrCancel
+	case 2:
+		order.TimeInForce = FillOrKill
+	}
+	if o.flags&2 != 0 && order.TimeInForce == GoodTilCanceled {
+		order.PostOnly = true
+		if o.flags&4 != 0 {
+			order.PostOnlyMode = PostOnlySlide
+		}
+	}
+	if o.flags&8 != 0 {
+		order.DisplaySize = RequireFromInt(1 + int64(o.extra>>2%4)).Div(RequireFromInt(4))
+	} else if o.flags&64 != 0 {
+		order.Hidden = true
+	}
+	if o.flags&128 != 0 {
+		order.Peg = []PegReference{PegBestBid, PegBestAsk, PegMid}[o.price%3]
+		order.PegOffset = RequireFromInt(int64(o.extra>>4%5) - 2)
+	}
+	o.conditions(order)
+	order.SelfTradePrevention = o.selfTradePrevention()
+	return order
+}
+
+var selfTradeModes = []SelfTradePrevention{"", CancelNewest, CancelOldest, CancelBoth, DecrementAndCancel}
+
+func (o op) selfTradePrevention() SelfTradePrevention {
+	return selfTradeModes[int(o.pick>>3%8)%len(selfTradeModes)]
+}
+
+func (o op) conditions(order *Order) {
+	switch o.pick % 8 {
+	case 0:
+		order.MinQty = RequireFromInt(1)
+	case 1:
+		order.AllOrNone = order.DisplaySize.IsZero()
+	}
+}
+
+var policies = []MatchingPolicy{
+	PriceTime{},
+	ProRata{Lot: dec(0.25)},
+	TopOfQueueProRata{TopShare: dec(0.4), Lot: dec(0.25)},
+}
+
+// ledger follows every order's quantity through the events: what was
+// accepted has to end up filled, cancelled or still resting.
+type ledger struct {
+	accepted map[int64]Decimal
+	filled   map[int64]Decimal
+	canceled map[int64]Decimal
+	bought   Decimal
+	sold     Decimal
+}
+
+func newLedger(ob *Orderbook) *ledger {
+	lg := &ledger{
+		accepted: make(map[int64]Decimal),
+		filled:   make(map[int64]Decimal),
+		canceled: make(map[int64]Decimal),
+	}
+	ob.AddListener(ListenerFunc(func(e Event) {
+		switch e := e.(type) {
+		case OrderAccepted:
+			lg.accepted[e.OrderID] = lg.accepted[e.OrderID].Add(e.Size)
+		case OrderCanceled:
+			lg.canceled[e.OrderID] = lg.canceled[e.OrderID].Add(e.Size)
+		case OrderPartiallyFilled:
+			lg.fill(e.OrderID, e.Bid, e.Size)
+		case OrderFilled:
+			lg.fill(e.OrderID, e.Bid, e.Size)
+		}
+	}))
+	return lg
+}
+
+func (lg *ledger) fill(id int64, bid bool, size Decimal) {
+	lg.filled[id] = lg.filled[id].Add(size)
+	if bid {
+		lg.bought = lg.bought.Add(size)
+	} else {
+		lg.sold = lg.sold.Add(size)
+	}
+}
+
+// checkInvariants returns the first invariant ob or lg breaks.
+func checkInvariants(ob *Orderbook, lg *ledger) error {
+	if bestBid, bestAsk := ob.bids.Best(), ob.asks.Best(); bestBid != nil && bestAsk != nil && !bestBid.Price.LessThan(bestAsk.Price) {
+		return fmt.Errorf("book crossed, best bid %s best ask %s", bestBid.Price, bestAsk.Price)
+	}
+
+	resting := 0
+	for _, side := range []struct {
+		bid    bool
+		levels *priceLevels
+		limits []*Limit
+		total  Decimal
+	}{
+		{true, ob.bids, ob.Bids(), ob.BidTotalVolume()},
+		{false, ob.asks, ob.Asks(), ob.AskTotalVolume()},
+	} {
+		sideVolume := Zero
+		visible := side.levels.visible.Limits()
+		for _, limit := range side.limits {
+			if limit.VisibleLen() > 0 {
+				if len(visible) == 0 || visible[0] != limit {
+					return fmt.Errorf("level %s with visible orders is missing from the visible levels", limit.Price)
+				}
+				visible = visible[1:]
+			}
+			if limit.Len() == 0 {
+				return fmt.Errorf("empty level %s left in the book", limit.Price)
+			}
+			volume, reserve, hidden := Zero, Zero, Zero
+			for _, o := range limit.Orders() {
+				if o.Limit != limit || o.Bid != side.bid || ob.Orders[o.ID] != o {
+					return fmt.Errorf("order %d at level %s is not indexed to it", o.ID, limit.Price)
+				}
+				if !o.Size.IsPositive() {
+					return fmt.Errorf("order %d rests with size %s", o.ID, o.Size)
+				}
+				if o.Hidden {
+					hidden = hidden.Add(o.Size)
+				} else {
+					volume = volume.Add(o.Size)
+				}
+				reserve = reserve.Add(o.reserve)
+			}
+			if !volume.Equal(limit.TotalVolume) || !reserve.Equal(limit.reserveVolume) || !hidden.Equal(limit.hiddenVolume) {
+				return fmt.Errorf("level %s has volume %s, reserve %s and hidden %s, its orders add up to %s, %s and %s", limit.Price, limit.TotalVolume, limit.reserveVolume, limit.hiddenVolume, volume, reserve, hidden)
+			}
+			sideVolume = sideVolume.Add(volume)
+			resting += limit.Len()
+		}
+		if len(visible) > 0 {
+			return fmt.Errorf("level %s is in the visible levels without visible orders", visible[0].Price)
+		}
+		if !sideVolume.Equal(side.total) {
+			return fmt.Errorf("bid side %v total volume %s, levels add up to %s", side.bid, side.total, sideVolume)
+		}
+	}
+	if resting != len(ob.Orders) {
+		return fmt.Errorf("%d orders indexed, %d resting in levels", len(ob.Orders), resting)
+	}
+
+	bid, ask := anchorPrice(ob.bids), anchorPrice(ob.asks)
+	for _, o := range ob.Orders {
+		if o.Peg == "" {
+			continue
+		}
+		if price, ok := ob.pegPrice(o, bid, ask); ok && !price.Equal(o.Limit.Price) {
+			return fmt.Errorf("order %d pegged to %s rests at %s instead of %s", o.ID, o.Peg, o.Limit.Price, price)
+		}
+	}
+
+	if !lg.bought.Equal(lg.sold) {
+		return fmt.Errorf("bought %s but sold %s", lg.bought, lg.sold)
+	}
+	for id, accepted := range lg.accepted {
+		left := Zero
+		if o, ok := ob.Orders[id]; ok {
+			left = o.Size.Add(o.reserve)
+		}
+		if accounted := lg.filled[id].Add(lg.canceled[id]).Add(left); !accounted.Equal(accepted) {
+			return fmt.Errorf("order %d accepted %s but filled %s, cancelled %s and has %s left", id, accepted, lg.filled[id], lg.canceled[id], left)
+		}
+	}
+
+	return nil
+}
+
+// checkMatches returns an error if a match of an order with a limit price
+// traded through that price, a match is empty or a match breaks the MinQty
+// or AllOrNone condition of either order.
+func checkMatches(o *Order, limitPrice Decimal, matches []Match) error {
+	for _, m := range matches {
+		if !m.SizeFilled.IsPositive() {
+			return fmt.Errorf("match of size %s", m.SizeFilled)
+		}
+		for _, side := range []*Order{m.Bid, m.Ask} {
+			// a fill below the condition has to be the rest of the order,
+			// an iceberg's slices fill one at a time
+			small := side.AllOrNone || m.SizeFilled.LessThan(side.MinQty) && side.DisplaySize.IsZero()
+			if small && !side.Size.IsZero() {
+				return fmt.Errorf("order %d with MinQty %s and all-or-none %v filled %s and has %s left", side.ID, side.MinQty, side.AllOrNone, m.SizeFilled, side.Size)
+			}
+		}
+		if m.Bid != o && m.Ask != o || limitPrice.IsZero() {
+			continue
+		}
+		if o.Bid && m.Price.GreaterThan(limitPrice) || !o.Bid && m.Price.LessThan(limitPrice) {
+			return fmt.Errorf("order with limit %s traded at %s", limitPrice, m.Price)
+		}
+	}
+	return nil
+}
+
+// runOps replays the operations in data on a new book. It returns the first
+// invariant that breaks, with the step it broke at.
+func runOps(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	ob := NewOrderbook(WithMatchingPolicy(policies[int(data[0])%len(policies)]))
+	lg := newLedger(ob)
+
+	var open []int64
+	for i, o := range decodeOps(data[1:]) {
+		var (
+			placed     *Order
+			limitPrice Decimal
+			matches    []Match
+			err        error
+		)
+
+		switch o.kind {
+		case opLimit:
+			placed, limitPrice = o.order(), o.priceDecimal()
+			if placed.Peg != "" {
+				err = ob.PlacePeggedOrder(placed)
+				break
+			}
+			matches, err = ob.PlaceLimitOrder(limitPrice, placed)
+		case opMarket:
+			placed = NewOrder(o.bid(), o.sizeDecimal(), int64(o.extra%3))
+			placed.AllowPartialFill = o.flags&2 != 0
+			o.conditions(placed)
+			placed.SelfTradePrevention = o.selfTradePrevention()
+			matches, err = ob.PlaceMarketOrder(placed)
+		case opCancel, opAmend:
+			if len(open) == 0 {
+				continue
+			}
+			target := ob.Order(open[int(o.pick)%len(open)])
+			if target == nil {
+				continue
+			}
+			if o.kind == opCancel {
+				ob.CancelOrder(target)
+				break
+			}
+			placed, limitPrice = target, target.Limit.Price
+			if o.flags&2 != 0 {
+				limitPrice = o.priceDecimal()
+			}
+			matches, err = ob.AmendOrder(target, limitPrice, o.sizeDecimal())
+		}
+
+		// rejections are fine, they just must not break anything
+		if err == nil && placed != nil {
+			err = checkMatches(placed, limitPrice, matches)
+			if err == nil && o.kind != opAmend && !placed.IsFilled() &&
+				(placed.TimeInForce == FillOrKill || o.kind == opMarket && !placed.AllowPartialFill) {
+				err = fmt.Errorf("order %d was accepted but has %s left", placed.ID, placed.Size)
+			}
+			if err != nil {
+				return fmt.Errorf("step %d %v: %w", i, o, err)
+			}
+		}
+		if err := checkInvariants(ob, lg); err != nil {
+			return fmt.Errorf("step %d %v: %w", i, o, err)
+		}
+
+		if placed != nil && ob.Orders[placed.ID] == placed {
+			open = append(open, placed.ID)
+		}
+	}
+
+	return nil
+}
+
+// shrink removes operations from a sequence for as long as run keeps
+// failing on it, so what is left is a small case to debug.
+func shrink(data []byte, run func([]byte) error) []byte {
+	for chunk := (len(data) - 1) / opSize; chunk > 0; chunk /= 2 {
+		for start := 1; start+chunk*opSize <= len(data); {
+			candidate := append(append([]byte{}, data[:start]...), data[start+chunk*opSize:]...)
+			if run(candidate) != nil {
+				data = candidate
+				continue
+			}
+			start += chunk * opSize
+		}
+	}
+	return data
+}
+
+// writeCorpusEntry saves data where go test replays it as a FuzzOrderbook
+// seed.
+func writeCorpusEntry(name string, data []byte) (string, error) {
+	dir := filepath.Join("testdata", "fuzz", "FuzzOrderbook")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	return path, os.WriteFile(path, []byte(fmt.Sprintf("go test fuzz v1\n[]byte(%q)\n", data)), 0o644)
+}
+
+func randomOps(r *rand.Rand, n int) []byte {
+	data := make([]byte, 1+n*opSize)
+	r.Read(data)
+	return data
+}
+
+func TestOrderbookProperties(t *testing.T) {
+	seeds := 200
+	if testing.Short() {
+		seeds = 20
+	}
+
+	for seed := 1; seed <= seeds; seed++ {
+		data := randomOps(rand.New(rand.NewSource(int64(seed))), 300)
+		err := runOps(data)
+		if err == nil {
+			continue
+		}
+
+		shrunk := shrink(data, runOps)
+		path, writeErr := writeCorpusEntry(fmt.Sprintf("seed-%d", seed), shrunk)
+		if writeErr != nil {
+			path = writeErr.Error()
+		}
+		t.Fatalf("seed %d: %v\nshrunk to %d operations: %v\nsaved to %s", seed, err, len(shrunk)/opSize, runOps(shrunk), path)
+	}
+}
+
+func TestShrink(t *testing.T) {
+	// a sequence that fails once any two bids have been placed, hidden in
+	// a longer one
+	failing := func(data []byte) error {
+		bids := 0
+		for _, o := range decodeOps(data[1:]) {
+			if o.kind == opLimit && o.bid() {
+				bids++
+			}
+		}
+		if bids >= 2 {
+			return errors.New("two bids")
+		}
+		return nil
+	}
+
+	data := randomOps(rand.New(rand.NewSource(1)), 50)
+	if failing(data) == nil {
+		t.Fatal("random sequence should fail")
+	}
+
+	assert(t, len(decodeOps(shrink(data, failing)[1:])), 2)
+}
+
+func FuzzOrderbook(f *testing.F) {
+	for seed := int64(1); seed <= 8; seed++ {
+		f.Add(randomOps(rand.New(rand.NewSource(seed)), 50))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := runOps(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
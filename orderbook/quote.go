@@ -0,0 +1,121 @@
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrInvalidQuoteSize    = errors.New("quote size must be positive and the order must have no size")
+	ErrQuoteSizeMarketOnly = errors.New("only market orders can be sized in quote")
+)
+
+// WithLotSize sets the base quantity a market order sized in quote is
+// rounded down to. It defaults to the smallest Decimal.
+func WithLotSize(lot Decimal) Option {
+	return func(ob *Orderbook) {
+		ob.lotSize = lot
+	}
+}
+
+// QuoteLeft returns how much of a market order's QuoteSize it did not spend,
+// or did not receive for a sell, once it has been placed.
+func (o *Order) QuoteLeft() Decimal {
+	return o.quoteLeft
+}
+
+// placeQuoteOrder places a market order sized in quote. It works out the
+// base size the quote buys, or sells for, walking the opposite side best
+// price first, and then places the order with that size like any other
+// market order. Without AllowPartialFill the order is rejected when the
+// book cannot take all of the quote.
+func (ob *Orderbook) placeQuoteOrder(dst []Match, o *Order) ([]Match, error) {
+	if !o.QuoteSize.IsPositive() || !o.Size.IsZero() {
+		return dst, ErrInvalidQuoteSize
+	}
+
+	slippage, err := ob.slippageGuard(o)
+	if err != nil {
+		return dst, err
+	}
+	inBand := ob.bandGuard(o, time.Now().UnixNano())
+	acceptable := func(l *Limit) bool { return slippage(l) && inBand(l) }
+
+	size, complete := ob.quoteFillableSize(o, acceptable)
+	if !complete && !o.AllowPartialFill {
+		// same order of checks as checkMarketLiquidity
+		if _, ok := ob.quoteFillableSize(o, func(*Limit) bool { return true }); !ok {
+			return dst, fmt.Errorf("%w [quote size: %s]", ErrInsufficientLiquidity, o.QuoteSize)
+		}
+		if _, ok := ob.quoteFillableSize(o, inBand); !ok {
+			return dst, ErrOutsidePriceBand
+		}
+		return dst, ErrSlippageExceeded
+	}
+
+	o.quoteLeft = o.QuoteSize
+	if size.IsZero() {
+		return dst, nil
+	}
+	o.Size = size
+
+	start := len(dst)
+	dst, err = ob.fillMarketOrder(dst, o)
+	if err != nil {
+		return dst, err
+	}
+	for _, match := range dst[start:] {
+		o.quoteLeft = o.quoteLeft.Sub(match.SizeFilled.Mul(match.Price))
+	}
+	return dst, nil
+}
+
+// quoteFillableSize returns the base size o.QuoteSize buys, or sells for, at
+// prices the acceptable func allows, rounded down to the lot size. It
+// reports whether that takes the whole quote, which it does once what is
+// left would not buy another lot. Like fillableVolume it leaves out the
+// orders of o's own user o would not trade with. MinQty and AllOrNone
+// conditions are left to fillMarketOrder, which checks them once the size
+// is known.
+func (ob *Orderbook) quoteFillableSize(o *Order, acceptable func(*Limit) bool) (Decimal, bool) {
+	levels := ob.asks
+	if !o.Bid {
+		levels = ob.bids
+	}
+
+	var (
+		size     = Zero
+		left     = o.QuoteSize
+		complete = false
+	)
+	levels.Each(func(limit *Limit) bool {
+		if !acceptable(limit) {
+			return false
+		}
+
+		available := limit.TotalVolume.Add(limit.reserveVolume).Add(limit.hiddenVolume)
+		if o.SelfTradePrevention != "" {
+			available = Zero
+			for order := limit.head; order != nil; order = order.next {
+				if !o.preventsSelfTrade(order) {
+					available = available.Add(order.Size).Add(order.reserve)
+				}
+			}
+		}
+
+		take := MinDecimal(available, left.Div(limit.Price))
+		size = size.Add(take)
+		left = left.Sub(take.Mul(limit.Price))
+
+		if floorToLot(left.Div(limit.Price), ob.lotSize).IsZero() {
+			complete = true
+			return false
+		}
+		return true
+	})
+
+	// rounding the total rather than each level down keeps the order a
+	// prefix of the walk, so it never trades more than the quote
+	return floorToLot(size, ob.lotSize), complete
+}
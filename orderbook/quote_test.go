@@ -0,0 +1,126 @@
+package orderbook
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPlaceQuoteMarketOrder(t *testing.T) {
+	ob := NewOrderbook(WithLotSize(dec(0.001)))
+
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(2), 0))
+	ob.PlaceLimitOrder(dec(110), NewOrder(false, dec(3), 0))
+	ob.PlaceLimitOrder(dec(120), NewOrder(false, dec(5), 0))
+
+	// 200 buys the 2 at 100, 300 more buys 2.727 of the 3 at 110
+	buyOrder := NewOrder(true, Zero, 1)
+	buyOrder.QuoteSize = dec(500)
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, matches[0].SizeFilled, dec(2))
+	assert(t, matches[1].SizeFilled, dec(2.727))
+	assert(t, buyOrder.IsFilled(), true)
+	assert(t, buyOrder.QuoteLeft(), dec(0.03))
+	assert(t, ob.BestAsk().Price, dec(110))
+	assert(t, ob.BestAsk().TotalVolume, dec(0.273))
+}
+
+func TestPlaceQuoteMarketOrderSell(t *testing.T) {
+	ob := NewOrderbook(WithLotSize(dec(0.01)))
+
+	ob.PlaceLimitOrder(dec(200), NewOrder(true, dec(1), 0))
+	ob.PlaceLimitOrder(dec(150), NewOrder(true, dec(4), 0))
+
+	sellOrder := NewOrder(false, Zero, 1)
+	sellOrder.QuoteSize = dec(350)
+	matches, err := ob.PlaceMarketOrder(sellOrder)
+	assert(t, err, nil)
+	assert(t, len(matches), 2)
+	assert(t, matches[1].SizeFilled, dec(1))
+	assert(t, sellOrder.QuoteLeft(), Zero)
+	assert(t, ob.BidTotalVolume(), dec(3))
+}
+
+func TestPlaceQuoteMarketOrderInsufficientLiquidity(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(2), 0))
+
+	buyOrder := NewOrder(true, Zero, 1)
+	buyOrder.QuoteSize = dec(500)
+	_, err := ob.PlaceMarketOrder(buyOrder)
+	assert(t, errors.Is(err, ErrInsufficientLiquidity), true)
+	assert(t, ob.AskTotalVolume(), dec(2))
+
+	buyOrder.AllowPartialFill = true
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, matches[0].SizeFilled, dec(2))
+	assert(t, buyOrder.QuoteLeft(), dec(300))
+	assert(t, ob.asks.Len(), 0)
+}
+
+func TestPlaceQuoteMarketOrderWorstPrice(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(1), 0))
+	ob.PlaceLimitOrder(dec(200), NewOrder(false, dec(1), 0))
+
+	buyOrder := NewOrder(true, Zero, 1)
+	buyOrder.QuoteSize = dec(300)
+	buyOrder.WorstPrice = dec(150)
+	_, err := ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, ErrSlippageExceeded)
+	assert(t, ob.AskTotalVolume(), dec(2))
+}
+
+func TestPlaceQuoteMarketOrderBelowLot(t *testing.T) {
+	ob := NewOrderbook(WithLotSize(dec(1)))
+
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(5), 0))
+
+	// 150 buys 1.5, a single lot
+	buyOrder := NewOrder(true, Zero, 1)
+	buyOrder.QuoteSize = dec(150)
+	matches, err := ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, nil)
+	assert(t, len(matches), 1)
+	assert(t, matches[0].SizeFilled, dec(1))
+	assert(t, buyOrder.QuoteLeft(), dec(50))
+
+	// 50 does not buy a lot at all
+	buyOrder = NewOrder(true, Zero, 1)
+	buyOrder.QuoteSize = dec(50)
+	matches, err = ob.PlaceMarketOrder(buyOrder)
+	assert(t, err, nil)
+	assert(t, len(matches), 0)
+	assert(t, buyOrder.QuoteLeft(), dec(50))
+	assert(t, ob.AskTotalVolume(), dec(4))
+}
+
+func TestPlaceQuoteOrderInvalid(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(5), 0))
+
+	o := NewOrder(true, dec(1), 1)
+	o.QuoteSize = dec(100)
+	_, err := ob.PlaceMarketOrder(o)
+	assert(t, err, ErrInvalidQuoteSize)
+
+	o = NewOrder(true, Zero, 1)
+	o.QuoteSize = dec(-100)
+	_, err = ob.PlaceMarketOrder(o)
+	assert(t, err, ErrInvalidQuoteSize)
+
+	o = NewOrder(true, Zero, 1)
+	o.QuoteSize = dec(100)
+	_, err = ob.PlaceLimitOrder(dec(90), o)
+	assert(t, err, ErrQuoteSizeMarketOnly)
+
+	o = NewOrder(true, Zero, 1)
+	o.QuoteSize = dec(100)
+	o.StopPrice = dec(110)
+	assert(t, ob.PlaceStopOrder(o), ErrQuoteSizeMarketOnly)
+}
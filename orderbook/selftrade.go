@@ -0,0 +1,102 @@
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidSelfTradePrevention = errors.New("invalid self-trade prevention mode")
+
+// SelfTradePrevention says what happens when an order would match a resting
+// order of the same user. The incoming order's mode decides, and an empty
+// mode lets the orders trade.
+type SelfTradePrevention string
+
+const (
+	// CancelNewest cancels the rest of the incoming order.
+	CancelNewest SelfTradePrevention = "CN"
+	// CancelOldest cancels the resting order and keeps matching.
+	CancelOldest SelfTradePrevention = "CO"
+	// CancelBoth cancels both orders.
+	CancelBoth SelfTradePrevention = "CB"
+	// DecrementAndCancel takes the smaller size off both orders without a
+	// trade, cancelling whichever of them is left with nothing.
+	DecrementAndCancel SelfTradePrevention = "DC"
+)
+
+// Validate returns ErrInvalidSelfTradePrevention unless stp is one of the
+// modes above or empty.
+func (stp SelfTradePrevention) Validate() error {
+	switch stp {
+	case "", CancelNewest, CancelOldest, CancelBoth, DecrementAndCancel:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidSelfTradePrevention, string(stp))
+	}
+}
+
+// SelfTradeCancel records size taken off an order by self-trade prevention.
+// The order was cancelled if it has nothing left.
+type SelfTradeCancel struct {
+	Order *Order
+	Size  Decimal
+}
+
+// preventSelfTrade applies o's self-trade prevention mode against resting,
+// an order of the same user at this limit. It reports whether resting was
+// cancelled and has to be taken off the limit.
+func (l *Limit) preventSelfTrade(o, resting *Order) bool {
+	cancelResting := false
+	from := len(o.SelfTradeCancels)
+
+	switch o.SelfTradePrevention {
+	case CancelNewest:
+		o.cancelSelfTrade(o, o.Size)
+	case CancelOldest:
+		o.cancelSelfTrade(resting, resting.Size.Add(resting.reserve))
+		cancelResting = true
+	case CancelBoth:
+		o.cancelSelfTrade(resting, resting.Size.Add(resting.reserve))
+		o.cancelSelfTrade(o, o.Size)
+		cancelResting = true
+	case DecrementAndCancel:
+		overlap := MinDecimal(o.Size, resting.Size.Add(resting.reserve))
+		l.reduceOrder(resting, overlap)
+		o.cancelSelfTrade(resting, overlap)
+		cancelResting = resting.IsFilled()
+
+		o.Size = o.Size.Sub(overlap)
+		o.cancelSelfTrade(o, overlap)
+		if o.Size.IsZero() {
+			o.selfTradeCanceled = true
+		}
+	}
+
+	if l.ob != nil {
+		l.ob.publishSelfTrade(o, o.SelfTradeCancels[from:], cancelResting)
+	}
+	return cancelResting
+}
+
+func (o *Order) cancelSelfTrade(canceled *Order, size Decimal) {
+	if canceled == o && o.SelfTradePrevention != DecrementAndCancel {
+		o.selfTradeCanceled = true
+	}
+	o.SelfTradeCancels = append(o.SelfTradeCancels, SelfTradeCancel{Order: canceled, Size: size})
+}
+
+// preventsSelfTrade reports whether o and resting must not trade.
+func (o *Order) preventsSelfTrade(resting *Order) bool {
+	return o.SelfTradePrevention != "" && o.UserID == resting.UserID
+}
+
+// IsSelfTradeCanceled reports whether self-trade prevention cancelled what
+// was left of o.
+func (o *Order) IsSelfTradeCanceled() bool {
+	return o.selfTradeCanceled
+}
+
+// done reports whether an incoming order has nothing left to match.
+func (o *Order) done() bool {
+	return o.IsFilled() || o.selfTradeCanceled
+}
@@ -0,0 +1,206 @@
+package orderbook
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSelfTradePrevention(t *testing.T) {
+	testCases := []struct {
+		name string
+		mode SelfTradePrevention
+		// size of the incoming buy, own resting ask is 3 at 10_000 and the
+		// ask of another user is 2 behind it
+		size            float64
+		wantMatches     int
+		wantBuyLeft     float64
+		wantOwnAskLeft  float64
+		wantOwnResting  bool
+		wantBuyCanceled bool
+		wantCancels     []float64
+	}{
+		{
+			name:           "no prevention trades with itself",
+			size:           4,
+			wantMatches:    2,
+			wantBuyLeft:    0,
+			wantOwnAskLeft: 0,
+		},
+		{
+			name:            "cancel newest",
+			mode:            CancelNewest,
+			size:            4,
+			wantMatches:     0,
+			wantBuyLeft:     4,
+			wantOwnAskLeft:  3,
+			wantOwnResting:  true,
+			wantBuyCanceled: true,
+			wantCancels:     []float64{4},
+		},
+		{
+			name:           "cancel oldest",
+			mode:           CancelOldest,
+			size:           4,
+			wantMatches:    1,
+			wantBuyLeft:    2,
+			wantOwnAskLeft: 3,
+			wantCancels:    []float64{3},
+		},
+		{
+			name:            "cancel both",
+			mode:            CancelBoth,
+			size:            4,
+			wantMatches:     0,
+			wantBuyLeft:     4,
+			wantOwnAskLeft:  3,
+			wantBuyCanceled: true,
+			wantCancels:     []float64{3, 4},
+		},
+		{
+			name:           "decrement and cancel the resting order",
+			mode:           DecrementAndCancel,
+			size:           4,
+			wantMatches:    1,
+			wantBuyLeft:    0,
+			wantOwnAskLeft: 0,
+			wantCancels:    []float64{3, 3},
+		},
+		{
+			name:            "decrement and cancel the incoming order",
+			mode:            DecrementAndCancel,
+			size:            2,
+			wantMatches:     0,
+			wantBuyLeft:     0,
+			wantOwnAskLeft:  1,
+			wantOwnResting:  true,
+			wantBuyCanceled: true,
+			wantCancels:     []float64{2, 2},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ob := NewOrderbook()
+
+			ownAsk := NewOrder(false, dec(3), 7)
+			otherAsk := NewOrder(false, dec(2), 8)
+			ob.PlaceLimitOrder(dec(10_000), ownAsk)
+			ob.PlaceLimitOrder(dec(10_000), otherAsk)
+
+			buy := NewOrder(true, dec(tc.size), 7)
+			buy.SelfTradePrevention = tc.mode
+			buy.TimeInForce = ImmediateOrCancel
+			matches, err := ob.PlaceLimitOrder(dec(10_000), buy)
+
+			assert(t, err, nil)
+			assert(t, len(matches), tc.wantMatches)
+			for _, match := range matches {
+				if match.Ask == ownAsk && tc.mode != "" {
+					t.Errorf("traded with its own order")
+				}
+			}
+			assert(t, buy.Size, dec(tc.wantBuyLeft))
+			assert(t, buy.IsSelfTradeCanceled(), tc.wantBuyCanceled)
+			assert(t, ownAsk.Size.Add(ownAsk.Reserve()), dec(tc.wantOwnAskLeft))
+			_, resting := ob.Orders[ownAsk.ID]
+			assert(t, resting, tc.wantOwnResting)
+			assert(t, ownAsk.Limit != nil, tc.wantOwnResting)
+
+			var cancels []float64
+			for _, c := range buy.SelfTradeCancels {
+				cancels = append(cancels, c.Size.Float64())
+			}
+			assert(t, cancels, tc.wantCancels)
+
+			wantVolume := Zero
+			if ownAsk.Limit != nil {
+				wantVolume = wantVolume.Add(ownAsk.Size)
+			}
+			if otherAsk.Limit != nil {
+				wantVolume = wantVolume.Add(otherAsk.Size)
+			}
+			assert(t, ob.AskTotalVolume(), wantVolume)
+		})
+	}
+}
+
+func TestSelfTradePreventionFillOrKill(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(3), 7))
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(2), 8))
+
+	// only 2 is available from other users
+	buy := NewOrder(true, dec(4), 7)
+	buy.SelfTradePrevention = CancelOldest
+	buy.TimeInForce = FillOrKill
+	_, err := ob.PlaceLimitOrder(dec(10_000), buy)
+
+	assert(t, err, ErrFillOrKill)
+	assert(t, ob.AskTotalVolume(), dec(5))
+}
+
+func TestSelfTradePreventionInvalid(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(1), 7))
+
+	for _, mode := range []SelfTradePrevention{"cn", "XX"} {
+		buy := NewOrder(true, dec(1), 7)
+		buy.SelfTradePrevention = mode
+		_, err := ob.PlaceLimitOrder(dec(10_000), buy)
+		assert(t, errors.Is(err, ErrInvalidSelfTradePrevention), true)
+
+		buy = NewOrder(true, dec(1), 7)
+		buy.SelfTradePrevention = mode
+		_, err = ob.PlaceMarketOrder(buy)
+		assert(t, errors.Is(err, ErrInvalidSelfTradePrevention), true)
+
+		stop := NewOrder(true, dec(1), 7)
+		stop.StopPrice = dec(10_000)
+		stop.SelfTradePrevention = mode
+		assert(t, errors.Is(ob.PlaceStopOrder(stop), ErrInvalidSelfTradePrevention), true)
+	}
+	assert(t, ob.AskTotalVolume(), dec(1))
+}
+
+func TestSelfTradePreventionFillOrKillStops(t *testing.T) {
+	for _, mode := range []SelfTradePrevention{CancelNewest, CancelBoth, DecrementAndCancel} {
+		t.Run(string(mode), func(t *testing.T) {
+			ob := NewOrderbook()
+
+			ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(5), 8))
+			ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(5), 7))
+			ob.PlaceLimitOrder(dec(101), NewOrder(false, dec(10), 8))
+
+			// the order of user 7 stops the buy half way, whatever other
+			// users have behind it
+			buy := NewOrder(true, dec(10), 7)
+			buy.SelfTradePrevention = mode
+			buy.TimeInForce = FillOrKill
+			_, err := ob.PlaceLimitOrder(dec(101), buy)
+			assert(t, err, ErrFillOrKill)
+			assert(t, ob.AskTotalVolume(), dec(20))
+
+			market := NewOrder(true, dec(10), 7)
+			market.SelfTradePrevention = mode
+			_, err = ob.PlaceMarketOrder(market)
+			assert(t, errors.Is(err, ErrInsufficientLiquidity), true)
+			assert(t, ob.AskTotalVolume(), dec(20))
+		})
+	}
+}
+
+func TestSelfTradePreventionFillOrKillProRata(t *testing.T) {
+	ob := NewOrderbook(WithMatchingPolicy(ProRata{Lot: dec(1)}))
+
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(5), 8))
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(5), 7))
+
+	// pro rata cancels the buy on its own order before sharing anything
+	buy := NewOrder(true, dec(2), 7)
+	buy.SelfTradePrevention = CancelNewest
+	buy.TimeInForce = FillOrKill
+	_, err := ob.PlaceLimitOrder(dec(100), buy)
+	assert(t, err, ErrFillOrKill)
+	assert(t, ob.AskTotalVolume(), dec(10))
+}
@@ -0,0 +1,167 @@
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrDuplicateOrderID = errors.New("order id is already in use")
+
+// Sequencer issues strictly increasing IDs, starting after the last one it
+// was created with. It is safe for concurrent use, so one sequencer can hand
+// out the order IDs of every orderbook of an exchange.
+//
+// A sequencer only lives in memory. To never issue an ID twice across
+// restarts, give it a ReserveFunc with Reserve: it then hands out IDs in
+// blocks and records the end of each block before issuing the first ID of
+// it, so a restarted sequencer can carry on above everything that may have
+// been issued.
+type Sequencer struct {
+	last atomic.Int64
+	// reserved is the highest ID that may be issued without reserving
+	// again, math.MaxInt64 while the sequencer does not reserve
+	reserved atomic.Int64
+
+	mu      sync.Mutex
+	block   int64
+	reserve ReserveFunc
+}
+
+// ReserveFunc records mark, the highest ID a sequencer may issue. It must
+// only return once the mark is stored where the next start reads it.
+type ReserveFunc func(mark int64) error
+
+// NewSequencer returns a sequencer whose first ID is last + 1. Pass the last
+// ID issued before a restart to carry on where the sequence left off.
+func NewSequencer(last int64) *Sequencer {
+	s := &Sequencer{}
+	s.last.Store(last)
+	s.reserved.Store(math.MaxInt64)
+	return s
+}
+
+// Next returns the next ID. It reserves the next block when the ID is past
+// the reserved one and returns the error if that fails, without issuing the
+// ID, since issuing it anyway could repeat it after a restart. Calling
+// ReserveAhead ahead of the work that takes IDs keeps that from happening.
+func (s *Sequencer) Next() (int64, error) {
+	for {
+		last := s.last.Load()
+		id := last + 1
+		if id > s.reserved.Load() {
+			if err := s.ensure(id); err != nil {
+				return 0, fmt.Errorf("orderbook: reserving id %d: %w", id, err)
+			}
+		}
+		if s.last.CompareAndSwap(last, id) {
+			return id, nil
+		}
+	}
+}
+
+// Last returns the most recently issued ID, or the starting point when none
+// has been issued yet.
+func (s *Sequencer) Last() int64 {
+	return s.last.Load()
+}
+
+// Advance moves the sequence forward so the next ID is above last. It never
+// moves it back, so several restored books can share one sequencer.
+func (s *Sequencer) Advance(last int64) {
+	for {
+		current := s.last.Load()
+		if current >= last || s.last.CompareAndSwap(current, last) {
+			return
+		}
+	}
+}
+
+// Reserve makes the sequencer hand out IDs in blocks of size block, calling
+// fn with the end of each block before its first ID is issued. It reserves
+// the first block right away. Advance the sequencer past the last mark fn
+// stored before a restart first, every ID up to it may have been issued.
+func (s *Sequencer) Reserve(block int64, fn ReserveFunc) error {
+	if block <= 0 {
+		return fmt.Errorf("orderbook: id block size must be positive, got %d", block)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.block = block
+	s.reserve = fn
+	s.reserved.Store(s.Last())
+	return s.extend(s.Last() + 1)
+}
+
+// ReserveAhead reserves the next block once less than half a block is left,
+// so a failure to record the mark can be returned before any ID is needed.
+// It does nothing for a sequencer that does not reserve.
+func (s *Sequencer) ReserveAhead() error {
+	if s.reserved.Load() == math.MaxInt64 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.extend(s.Last() + s.block/2 + 1)
+}
+
+// ensure makes sure id is reserved.
+func (s *Sequencer) ensure(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.extend(id)
+}
+
+// extend reserves a block starting at id unless id is reserved already. The
+// caller holds s.mu.
+func (s *Sequencer) extend(id int64) error {
+	if id <= s.reserved.Load() {
+		return nil
+	}
+	mark := id - 1 + s.block
+	if err := s.reserve(mark); err != nil {
+		return err
+	}
+	s.reserved.Store(mark)
+	return nil
+}
+
+// reserveIDs reserves order and trade IDs ahead of an operation that may
+// take some, so it fails before it changes the book rather than part way.
+func (ob *Orderbook) reserveIDs() error {
+	if err := ob.orderIDs.ReserveAhead(); err != nil {
+		return fmt.Errorf("reserving order ids: %w", err)
+	}
+	if err := ob.tradeIDs.ReserveAhead(); err != nil {
+		return fmt.Errorf("reserving trade ids: %w", err)
+	}
+	return nil
+}
+
+// assignID gives a new order the next order ID. An order that already has
+// one keeps it, as long as no other order in the book uses it.
+func (ob *Orderbook) assignID(o *Order) error {
+	if err := ob.reserveIDs(); err != nil {
+		return err
+	}
+	if o.ID == 0 {
+		id, err := ob.orderIDs.Next()
+		if err != nil {
+			return err
+		}
+		o.ID = id
+		return nil
+	}
+	if _, ok := ob.Orders[o.ID]; ok {
+		return ErrDuplicateOrderID
+	}
+	if _, ok := ob.Stops[o.ID]; ok {
+		return ErrDuplicateOrderID
+	}
+	return nil
+}
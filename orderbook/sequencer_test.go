@@ -0,0 +1,160 @@
+package orderbook
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestSequencerConcurrent(t *testing.T) {
+	s := NewSequencer(0)
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		seen = make(map[int64]bool)
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			last := int64(0)
+			for j := 0; j < 1000; j++ {
+				id, err := s.Next()
+				if err != nil {
+					t.Error(err)
+				}
+				if id <= last {
+					t.Errorf("id %d not above %d", id, last)
+				}
+				last = id
+				mu.Lock()
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert(t, len(seen), 8000)
+	assert(t, s.Last(), int64(8000))
+}
+
+func TestSequencerResume(t *testing.T) {
+	s := NewSequencer(41)
+	assert(t, s.Last(), int64(41))
+	id, err := s.Next()
+	assert(t, err, nil)
+	assert(t, id, int64(42))
+}
+
+func TestSequencerReserve(t *testing.T) {
+	var marks []int64
+	record := func(mark int64) error {
+		marks = append(marks, mark)
+		return nil
+	}
+
+	s := NewSequencer(0)
+	assert(t, s.Reserve(10, record), nil)
+	assert(t, marks, []int64{10})
+
+	for i := 0; i < 25; i++ {
+		s.Next()
+	}
+	assert(t, marks, []int64{10, 20, 30})
+
+	// ahead of the work it reserves once half a block is used up
+	assert(t, s.ReserveAhead(), nil)
+	assert(t, marks, []int64{10, 20, 30, 40})
+
+	// a restart carries on above the last mark
+	restarted := NewSequencer(0)
+	restarted.Advance(marks[len(marks)-1])
+	assert(t, restarted.Reserve(10, record), nil)
+	id, err := restarted.Next()
+	assert(t, err, nil)
+	assert(t, id, int64(41))
+
+	// an ID that cannot be reserved is not issued
+	errDisk := errors.New("disk full")
+	failing := NewSequencer(0)
+	failing.Reserve(1, func(mark int64) error {
+		if mark > 1 {
+			return errDisk
+		}
+		return nil
+	})
+	failing.Next()
+	_, err = failing.Next()
+	assert(t, errors.Is(err, errDisk), true)
+	assert(t, failing.Last(), int64(1))
+}
+
+func TestOrderbookReserveFails(t *testing.T) {
+	errDisk := errors.New("disk full")
+	failing := false
+
+	s := NewSequencer(0)
+	s.Reserve(4, func(int64) error {
+		if failing {
+			return errDisk
+		}
+		return nil
+	})
+	ob := NewOrderbook(WithOrderSequencer(s))
+
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(1), 1))
+	ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(1), 1))
+
+	// the order is rejected before it takes an ID it could not record
+	failing = true
+	_, err := ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(1), 1))
+	assert(t, errors.Is(err, errDisk), true)
+	assert(t, s.Last(), int64(2))
+	assert(t, ob.AskTotalVolume(), dec(2))
+
+	failing = false
+	_, err = ob.PlaceLimitOrder(dec(100), NewOrder(false, dec(1), 1))
+	assert(t, err, nil)
+	assert(t, s.Last(), int64(3))
+}
+
+func TestOrderbookAssignsOrderIDs(t *testing.T) {
+	ob := NewOrderbook(WithOrderSequencer(NewSequencer(100)))
+
+	sellOrderA := NewOrder(false, dec(5), 0)
+	sellOrderB := NewOrder(false, dec(5), 0)
+	stop := NewOrder(true, dec(1), 0)
+	stop.StopPrice = dec(20_000)
+	assert(t, sellOrderA.ID, int64(0))
+
+	ob.PlaceLimitOrder(dec(10_000), sellOrderA)
+	ob.PlaceLimitOrder(dec(10_000), sellOrderB)
+	ob.PlaceStopOrder(stop)
+	ob.PlaceMarketOrder(NewOrder(true, dec(1), 0))
+
+	assert(t, sellOrderA.ID, int64(101))
+	assert(t, sellOrderB.ID, int64(102))
+	assert(t, stop.ID, int64(103))
+	assert(t, ob.orderIDs.Last(), int64(104))
+
+	duplicate := NewOrder(false, dec(1), 0)
+	duplicate.ID = sellOrderA.ID
+	_, err := ob.PlaceLimitOrder(dec(10_000), duplicate)
+	assert(t, err, ErrDuplicateOrderID)
+	assert(t, ob.Orders[sellOrderA.ID], sellOrderA)
+}
+
+func TestTradeIDsHaveTheirOwnSequence(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(1), 0))
+	ob.PlaceLimitOrder(dec(10_100), NewOrder(false, dec(1), 0))
+	ob.PlaceMarketOrder(NewOrder(true, dec(2), 0))
+
+	assert(t, len(ob.Trades), 2)
+	assert(t, ob.Trades[0].ID, int64(1))
+	assert(t, ob.Trades[1].ID, int64(2))
+	assert(t, ob.Trades[1].BidOrderID, int64(3))
+}
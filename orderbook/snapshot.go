@@ -0,0 +1,320 @@
+package orderbook
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const snapshotVersion = 1
+
+var (
+	snapshotMagic = [4]byte{'O', 'B', 'S', 'N'}
+
+	ErrInvalidSnapshot     = errors.New("not an orderbook snapshot")
+	ErrUnsupportedSnapshot = errors.New("unsupported snapshot version")
+)
+
+// WriteSnapshot writes the state of the book to w: the resting orders level
+// by level in queue order, the pending stop orders, the trades, the last
+// order and trade IDs and the trading state. The book's configuration, like
+// its tick size, matching policy and price band, is not part of it.
+//
+// The format is a magic number and a version followed by little endian
+// fields. A new version is added whenever the layout changes.
+func (ob *Orderbook) WriteSnapshot(w io.Writer) error {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	sw := &snapshotWriter{w: bufio.NewWriter(w)}
+	sw.bytes(snapshotMagic[:])
+	sw.uint16(snapshotVersion)
+
+	sw.int64(ob.orderIDs.Last())
+	sw.int64(ob.tradeIDs.Last())
+	sw.decimal(ob.lastTradePrice)
+	sw.int64(ob.haltedUntil)
+	sw.bool(ob.auction)
+	sw.int64(ob.auctionEnd)
+
+	for _, levels := range []*priceLevels{ob.bids, ob.asks} {
+		sw.uint32(uint32(levels.Len()))
+		levels.Each(func(l *Limit) bool {
+			sw.decimal(l.Price)
+			sw.uint32(uint32(l.count))
+			for o := l.head; o != nil; o = o.next {
+				sw.order(o)
+			}
+			return true
+		})
+	}
+
+	for _, stops := range [][]*Order{ob.stops.buys, ob.stops.sells} {
+		sw.uint32(uint32(len(stops)))
+		for _, o := range stops {
+			sw.order(o)
+		}
+	}
+
+	sw.uint32(uint32(len(ob.Trades)))
+	for _, t := range ob.Trades {
+		sw.int64(t.ID)
+		sw.decimal(t.Price)
+		sw.bool(t.Bid)
+		sw.int64(t.Timestamp)
+		sw.decimal(t.Size)
+		sw.int64(t.BidOrderID)
+		sw.int64(t.AskOrderID)
+		sw.bool(t.Auction)
+	}
+
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.w.Flush()
+}
+
+// RestoreSnapshot replaces the state of the book with a snapshot written by
+// WriteSnapshot. The book keeps its own configuration. Its sequencers are
+// moved forward to the snapshot's last IDs, never back.
+func (ob *Orderbook) RestoreSnapshot(r io.Reader) error {
+	sr := &snapshotReader{r: bufio.NewReader(r)}
+
+	var magic [4]byte
+	sr.bytes(magic[:])
+	if sr.err != nil || magic != snapshotMagic {
+		return ErrInvalidSnapshot
+	}
+	if version := sr.uint16(); version != snapshotVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedSnapshot, version)
+	}
+
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	restored := NewOrderbook()
+	restored.policy = ob.policy
+
+	lastOrderID := sr.int64()
+	lastTradeID := sr.int64()
+	restored.lastTradePrice = sr.decimal()
+	restored.haltedUntil = sr.int64()
+	restored.auction = sr.bool()
+	restored.auctionEnd = sr.int64()
+
+	// bids then asks, best price first
+	for side := 0; side < 2; side++ {
+		levels := sr.uint32()
+		for i := uint32(0); i < levels && sr.err == nil; i++ {
+			price := sr.decimal()
+			orders := sr.uint32()
+			for j := uint32(0); j < orders && sr.err == nil; j++ {
+				o := sr.order()
+				restored.addLimitOrder(price, o)
+				if o.TimeInForce == GoodTilDate {
+					heap.Push(&restored.expiries, o)
+				}
+				if o.Peg != "" {
+					restored.pegged = append(restored.pegged, o)
+				}
+			}
+		}
+	}
+
+	// buy stops then sell stops, in trigger order
+	for side := 0; side < 2; side++ {
+		stops := sr.uint32()
+		for i := uint32(0); i < stops && sr.err == nil; i++ {
+			o := sr.order()
+			o.stopPending = true
+			restored.stops.add(o)
+			restored.Stops[o.ID] = o
+		}
+	}
+
+	trades := sr.uint32()
+	for i := uint32(0); i < trades && sr.err == nil; i++ {
+		restored.Trades = append(restored.Trades, &Trade{
+			ID:         sr.int64(),
+			Price:      sr.decimal(),
+			Bid:        sr.bool(),
+			Timestamp:  sr.int64(),
+			Size:       sr.decimal(),
+			BidOrderID: sr.int64(),
+			AskOrderID: sr.int64(),
+			Auction:    sr.bool(),
+		})
+	}
+
+	if sr.err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, sr.err)
+	}
+
+	ob.asks, ob.bids = restored.asks, restored.bids
+	ob.AskLimits, ob.BidLimits = restored.AskLimits, restored.BidLimits
+	for _, limits := range []map[Decimal]*Limit{ob.AskLimits, ob.BidLimits} {
+		for _, l := range limits {
+			l.ob = ob
+		}
+	}
+	ob.Orders, ob.Stops, ob.Trades = restored.Orders, restored.Stops, restored.Trades
+	ob.retainTrades()
+	ob.resetWindowTrades()
+	ob.expiries, ob.stops, ob.pegged = restored.expiries, restored.stops, restored.pegged
+	ob.lastTradePrice = restored.lastTradePrice
+	ob.haltedUntil = restored.haltedUntil
+	ob.auction, ob.auctionEnd = restored.auction, restored.auctionEnd
+	ob.orderIDs.Advance(lastOrderID)
+	ob.tradeIDs.Advance(lastTradeID)
+
+	return nil
+}
+
+type snapshotWriter struct {
+	w   *bufio.Writer
+	buf [8]byte
+	err error
+}
+
+func (sw *snapshotWriter) bytes(b []byte) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(b)
+	}
+}
+
+func (sw *snapshotWriter) uint16(v uint16) {
+	binary.LittleEndian.PutUint16(sw.buf[:2], v)
+	sw.bytes(sw.buf[:2])
+}
+
+func (sw *snapshotWriter) uint32(v uint32) {
+	binary.LittleEndian.PutUint32(sw.buf[:4], v)
+	sw.bytes(sw.buf[:4])
+}
+
+func (sw *snapshotWriter) int64(v int64) {
+	binary.LittleEndian.PutUint64(sw.buf[:], uint64(v))
+	sw.bytes(sw.buf[:])
+}
+
+func (sw *snapshotWriter) bool(v bool) {
+	b := byte(0)
+	if v {
+		b = 1
+	}
+	sw.bytes([]byte{b})
+}
+
+func (sw *snapshotWriter) decimal(d Decimal) {
+	sw.int64(d.units)
+}
+
+func (sw *snapshotWriter) string(s string) {
+	sw.uint32(uint32(len(s)))
+	sw.bytes([]byte(s))
+}
+
+func (sw *snapshotWriter) order(o *Order) {
+	sw.int64(o.ID)
+	sw.int64(o.UserID)
+	sw.decimal(o.Size)
+	sw.decimal(o.reserve)
+	sw.bool(o.Bid)
+	sw.int64(o.Timestamp)
+	sw.string(string(o.TimeInForce))
+	sw.int64(o.ExpiresAt)
+	sw.bool(o.PostOnly)
+	sw.string(string(o.PostOnlyMode))
+	sw.decimal(o.StopPrice)
+	sw.decimal(o.StopLimitPrice)
+	sw.decimal(o.DisplaySize)
+	sw.string(string(o.SelfTradePrevention))
+	sw.bool(o.AllowPartialFill)
+	sw.decimal(o.WorstPrice)
+	sw.decimal(o.MaxSlippage)
+	sw.bool(o.Hidden)
+	sw.string(string(o.Peg))
+	sw.decimal(o.PegOffset)
+	sw.decimal(o.MinQty)
+	sw.bool(o.AllOrNone)
+}
+
+type snapshotReader struct {
+	r   *bufio.Reader
+	buf [8]byte
+	err error
+}
+
+func (sr *snapshotReader) bytes(b []byte) {
+	if sr.err == nil {
+		_, sr.err = io.ReadFull(sr.r, b)
+	}
+}
+
+func (sr *snapshotReader) uint16() uint16 {
+	sr.bytes(sr.buf[:2])
+	return binary.LittleEndian.Uint16(sr.buf[:2])
+}
+
+func (sr *snapshotReader) uint32() uint32 {
+	sr.bytes(sr.buf[:4])
+	return binary.LittleEndian.Uint32(sr.buf[:4])
+}
+
+func (sr *snapshotReader) int64() int64 {
+	sr.bytes(sr.buf[:])
+	return int64(binary.LittleEndian.Uint64(sr.buf[:]))
+}
+
+func (sr *snapshotReader) bool() bool {
+	sr.bytes(sr.buf[:1])
+	return sr.buf[0] == 1
+}
+
+func (sr *snapshotReader) decimal() Decimal {
+	return Decimal{units: sr.int64()}
+}
+
+func (sr *snapshotReader) string() string {
+	n := sr.uint32()
+	// strings are short enum values, anything longer is a corrupt snapshot
+	if n > 64 {
+		if sr.err == nil {
+			sr.err = fmt.Errorf("string of %d bytes", n)
+		}
+		return ""
+	}
+	b := make([]byte, n)
+	sr.bytes(b)
+	return string(b)
+}
+
+func (sr *snapshotReader) order() *Order {
+	return &Order{
+		ID:                  sr.int64(),
+		UserID:              sr.int64(),
+		Size:                sr.decimal(),
+		reserve:             sr.decimal(),
+		Bid:                 sr.bool(),
+		Timestamp:           sr.int64(),
+		TimeInForce:         TimeInForce(sr.string()),
+		ExpiresAt:           sr.int64(),
+		PostOnly:            sr.bool(),
+		PostOnlyMode:        PostOnlyMode(sr.string()),
+		StopPrice:           sr.decimal(),
+		StopLimitPrice:      sr.decimal(),
+		DisplaySize:         sr.decimal(),
+		SelfTradePrevention: SelfTradePrevention(sr.string()),
+		AllowPartialFill:    sr.bool(),
+		WorstPrice:          sr.decimal(),
+		MaxSlippage:         sr.decimal(),
+		Hidden:              sr.bool(),
+		Peg:                 PegReference(sr.string()),
+		PegOffset:           sr.decimal(),
+		MinQty:              sr.decimal(),
+		AllOrNone:           sr.bool(),
+	}
+}
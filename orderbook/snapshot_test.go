@@ -0,0 +1,171 @@
+package orderbook
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	ob := NewOrderbook()
+
+	iceberg := NewOrder(false, dec(10), 1)
+	iceberg.DisplaySize = dec(2)
+	ob.PlaceLimitOrder(dec(10_000), iceberg)
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(3), 2))
+	ob.PlaceLimitOrder(dec(10_100), NewOrder(false, dec(4), 3))
+
+	gtd := NewOrder(true, dec(2), 4)
+	gtd.TimeInForce = GoodTilDate
+	gtd.ExpiresAt = time.Now().Add(time.Hour).UnixNano()
+	ob.PlaceLimitOrder(dec(9_900), gtd)
+	ob.PlaceLimitOrder(dec(9_900), NewOrder(true, dec(1), 5))
+
+	ob.PlaceMarketOrder(NewOrder(true, dec(3), 6))
+
+	stopA := NewOrder(true, dec(1), 7)
+	stopA.StopPrice = dec(10_100)
+	stopB := NewOrder(true, dec(1), 8)
+	stopB.StopPrice = dec(10_100)
+	stopB.StopLimitPrice = dec(10_300)
+	ob.PlaceStopOrder(stopA)
+	ob.PlaceStopOrder(stopB)
+
+	var buf bytes.Buffer
+	assert(t, ob.WriteSnapshot(&buf), nil)
+
+	restored := NewOrderbook()
+	assert(t, restored.RestoreSnapshot(bytes.NewReader(buf.Bytes())), nil)
+
+	assert(t, restored.Depth(0, Zero), ob.Depth(0, Zero))
+	assert(t, restored.Trades, ob.Trades)
+	assert(t, restored.LastTradePrice(), ob.LastTradePrice())
+	assert(t, restored.orderIDs.Last(), ob.orderIDs.Last())
+	assert(t, restored.tradeIDs.Last(), ob.tradeIDs.Last())
+	assert(t, len(restored.Orders), len(ob.Orders))
+	assert(t, len(restored.Stops), 2)
+	assert(t, restored.expiries.Len(), 1)
+
+	for _, side := range [][2][]*Limit{{ob.Asks(), restored.Asks()}, {ob.Bids(), restored.Bids()}} {
+		for i, limit := range side[0] {
+			other := side[1][i]
+			assert(t, other.reserveVolume, limit.reserveVolume)
+			orders := other.Orders()
+			for j, o := range limit.Orders() {
+				r := orders[j]
+				assert(t, r.ID, o.ID)
+				assert(t, r.Size, o.Size)
+				assert(t, r.reserve, o.reserve)
+				assert(t, r.Timestamp, o.Timestamp)
+				assert(t, r.TimeInForce, o.TimeInForce)
+				assert(t, r.ExpiresAt, o.ExpiresAt)
+				assert(t, r.DisplaySize, o.DisplaySize)
+				assert(t, r.Limit, other)
+				assert(t, restored.Orders[r.ID], r)
+			}
+		}
+	}
+
+	// both books carry on the same way, the restored one keeps the queue
+	// and the stops fire in the same order
+	var fills [2][][3]int64
+	for i, book := range []*Orderbook{ob, restored} {
+		buyOrder := NewOrder(true, dec(12), 9)
+		matches, err := book.PlaceMarketOrder(buyOrder)
+		assert(t, err, nil)
+		for _, match := range matches {
+			fills[i] = append(fills[i], [3]int64{match.Ask.ID, match.Bid.ID, match.SizeFilled.Units()})
+		}
+	}
+	assert(t, len(fills[0]), 8)
+	assert(t, fills[1], fills[0])
+	assert(t, fills[0][7][1], stopB.ID)
+	assert(t, restored.Depth(0, Zero), ob.Depth(0, Zero))
+	assert(t, restored.orderIDs.Last(), ob.orderIDs.Last())
+	assert(t, len(restored.Trades), len(ob.Trades))
+}
+
+func TestRestoreSnapshotInvalid(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(1), 0))
+
+	err := ob.RestoreSnapshot(bytes.NewReader([]byte("nope")))
+	assert(t, err, ErrInvalidSnapshot)
+
+	var buf bytes.Buffer
+	NewOrderbook().WriteSnapshot(&buf)
+	data := buf.Bytes()
+	data[4] = 99
+	err = ob.RestoreSnapshot(bytes.NewReader(data))
+	assert(t, errors.Is(err, ErrUnsupportedSnapshot), true)
+
+	buf.Reset()
+	ob.WriteSnapshot(&buf)
+	err = NewOrderbook().RestoreSnapshot(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	assert(t, errors.Is(err, ErrInvalidSnapshot), true)
+
+	// a failed restore leaves the book alone
+	assert(t, ob.AskTotalVolume(), dec(1))
+}
+
+func TestSnapshotHiddenAndPegged(t *testing.T) {
+	ob := NewOrderbook()
+	ob.PlaceLimitOrder(dec(99), NewOrder(true, dec(1), 0))
+	ob.PlaceLimitOrder(dec(101), NewOrder(false, dec(1), 0))
+
+	hidden := NewOrder(false, dec(2), 1)
+	hidden.Hidden = true
+	ob.PlaceLimitOrder(dec(102), hidden)
+
+	pegged := NewOrder(true, dec(3), 1)
+	pegged.Peg = PegBestBid
+	pegged.PegOffset = dec(0.5)
+	if err := ob.PlacePeggedOrder(pegged); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := ob.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewOrderbook()
+	if err := restored.RestoreSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, restored.Order(hidden.ID).Hidden, true)
+	assert(t, restored.AskTotalVolume(), dec(1))
+
+	// the restored pegged order keeps following the best bid
+	restored.PlaceLimitOrder(dec(100), NewOrder(true, dec(1), 0))
+	o := restored.Order(pegged.ID)
+	assert(t, o.Peg, PegBestBid)
+	assert(t, o.Limit.Price, dec(100.5))
+}
+
+func TestSnapshotOrderConditions(t *testing.T) {
+	ob := NewOrderbook()
+	aon := NewOrder(false, dec(5), 1)
+	aon.AllOrNone = true
+	ob.PlaceLimitOrder(dec(100), aon)
+	minQty := NewOrder(true, dec(4), 1)
+	minQty.MinQty = dec(2)
+	ob.PlaceLimitOrder(dec(90), minQty)
+
+	var buf bytes.Buffer
+	if err := ob.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewOrderbook()
+	if err := restored.RestoreSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, restored.Order(aon.ID).AllOrNone, true)
+	assert(t, restored.Order(minQty.ID).MinQty, dec(2))
+
+	// the restored conditions still hold
+	matches, _ := restored.PlaceMarketOrder(NewOrder(false, dec(1), 0))
+	assert(t, len(matches), 0)
+}
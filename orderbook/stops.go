@@ -0,0 +1,177 @@
+package orderbook
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	ErrInvalidStopPrice = errors.New("stop price must be positive")
+	ErrStopWouldTrigger = errors.New("stop price has already been reached by the last trade")
+)
+
+// stopOrders holds the stop orders waiting for their trigger. Buy stops are
+// kept lowest stop price first and sell stops highest first, so the front of
+// each slice is the stop the price reaches first. Stops with the same price
+// keep their arrival order.
+type stopOrders struct {
+	buys  []*Order
+	sells []*Order
+}
+
+func (s *stopOrders) add(o *Order) {
+	if o.Bid {
+		i := sort.Search(len(s.buys), func(i int) bool { return s.buys[i].StopPrice.GreaterThan(o.StopPrice) })
+		s.buys = insertOrder(s.buys, i, o)
+	} else {
+		i := sort.Search(len(s.sells), func(i int) bool { return s.sells[i].StopPrice.LessThan(o.StopPrice) })
+		s.sells = insertOrder(s.sells, i, o)
+	}
+}
+
+func (s *stopOrders) remove(o *Order) {
+	if o.Bid {
+		s.buys = removeOrder(s.buys, o)
+	} else {
+		s.sells = removeOrder(s.sells, o)
+	}
+}
+
+// next pops the stop that the given last trade price triggers first. Buy
+// stops are checked before sell stops.
+func (s *stopOrders) next(lastPrice Decimal) *Order {
+	if len(s.buys) > 0 && s.buys[0].StopPrice.LessThanOrEqual(lastPrice) {
+		o := s.buys[0]
+		s.buys = s.buys[1:]
+		return o
+	}
+	if len(s.sells) > 0 && s.sells[0].StopPrice.GreaterThanOrEqual(lastPrice) {
+		o := s.sells[0]
+		s.sells = s.sells[1:]
+		return o
+	}
+	return nil
+}
+
+func insertOrder(orders []*Order, i int, o *Order) []*Order {
+	orders = append(orders, nil)
+	copy(orders[i+1:], orders[i:])
+	orders[i] = o
+	return orders
+}
+
+func removeOrder(orders []*Order, o *Order) []*Order {
+	for i, order := range orders {
+		if order == o {
+			return append(orders[:i], orders[i+1:]...)
+		}
+	}
+	return orders
+}
+
+// IsStopPending reports whether o is a stop order still waiting for its
+// trigger.
+func (o *Order) IsStopPending() bool {
+	return o.stopPending
+}
+
+// PlaceStopOrder holds o outside the visible book until the last trade price
+// reaches o.StopPrice: at or above it for a buy stop, at or below it for a
+// sell stop. It is then placed as a market order, or as a limit order at
+// o.StopLimitPrice when that is set. A stop the last trade has already
+// reached is rejected with ErrStopWouldTrigger.
+func (ob *Orderbook) PlaceStopOrder(o *Order) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	if ob.halted(now) {
+		return ErrTradingHalted
+	}
+	if err := ob.assignID(o); err != nil {
+		return err
+	}
+	if !o.StopPrice.IsPositive() {
+		return ErrInvalidStopPrice
+	}
+	if !o.QuoteSize.IsZero() {
+		return ErrQuoteSizeMarketOnly
+	}
+	if !o.Size.IsPositive() {
+		return ErrInvalidSize
+	}
+	if o.Peg != "" {
+		return ErrPeggedPlacement
+	}
+	// the order is checked the way it will be placed once triggered, except
+	// for the price band, which is checked against the band at that time
+	if o.StopLimitPrice.IsZero() {
+		if ob.auction {
+			return ErrAuctionMarketOrder
+		}
+		if err := o.SelfTradePrevention.Validate(); err != nil {
+			return err
+		}
+		if err := o.checkConditions(); err != nil {
+			return err
+		}
+	} else if err := ob.checkLimitTerms(o, now); err != nil {
+		return err
+	}
+
+	if !ob.lastTradePrice.IsZero() {
+		if o.Bid && o.StopPrice.LessThanOrEqual(ob.lastTradePrice) ||
+			!o.Bid && o.StopPrice.GreaterThanOrEqual(ob.lastTradePrice) {
+			return ErrStopWouldTrigger
+		}
+	}
+
+	o.stopPending = true
+	ob.stops.add(o)
+	ob.Stops[o.ID] = o
+	ob.publishAccepted(o.StopLimitPrice, o)
+
+	return nil
+}
+
+// triggerStops fires every stop order the last trade price has reached and
+// returns their matches. The trades of a triggered stop can trigger further
+// stops, so the last price is checked again after each one. When a trade
+// reaches several stops they fire in trigger price order, buy stops first,
+// and stops with the same trigger price fire in arrival order. The matches
+// are appended to dst.
+func (ob *Orderbook) triggerStops(dst []Match) []Match {
+	// stops wait while trading is halted or in an auction
+	if ob.lastTradePrice.IsZero() || ob.halted(time.Now().UnixNano()) || ob.auction {
+		return dst
+	}
+
+	for o := ob.stops.next(ob.lastTradePrice); o != nil; o = ob.stops.next(ob.lastTradePrice) {
+		o.stopPending = false
+		delete(ob.Stops, o.ID)
+
+		var err error
+		if o.StopLimitPrice.IsZero() {
+			dst, err = ob.placeMarketOrder(dst, o)
+		} else {
+			dst, err = ob.placeLimitOrder(dst, o.StopLimitPrice, o, time.Now().UnixNano())
+		}
+		// a stop that cannot be placed any more, like a market stop the
+		// book cannot fill or a FOK stop limit, is dropped
+		if err != nil {
+			ob.publishCanceled(o, o.Size, Zero, CancelRejected)
+		}
+	}
+
+	return dst
+}
+
+// LastTradePrice returns the price of the most recent match, or zero when
+// nothing has traded yet.
+func (ob *Orderbook) LastTradePrice() Decimal {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	return ob.lastTradePrice
+}
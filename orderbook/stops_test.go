@@ -0,0 +1,149 @@
+package orderbook
+
+import "testing"
+
+func newStopOrder(bid bool, size, stopPrice, limitPrice float64) *Order {
+	o := NewOrder(bid, dec(size), 0)
+	o.StopPrice = dec(stopPrice)
+	o.StopLimitPrice = dec(limitPrice)
+	return o
+}
+
+func TestStopMarketOrderTriggers(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(1), 0))
+	ob.PlaceLimitOrder(dec(10_100), NewOrder(false, dec(5), 0))
+
+	stop := newStopOrder(true, 2, 10_100, 0)
+	assert(t, ob.PlaceStopOrder(stop), nil)
+	assert(t, stop.IsStopPending(), true)
+	assert(t, ob.Stops[stop.ID], stop)
+	// stops are not part of the visible book
+	assert(t, ob.bids.Len(), 0)
+
+	// trades at 10_000, below the trigger
+	matches, _ := ob.PlaceMarketOrder(NewOrder(true, dec(1), 0))
+	assert(t, len(matches), 1)
+	assert(t, stop.IsStopPending(), true)
+
+	// trades at 10_100 and triggers the stop, which buys 2 more at 10_100
+	matches, _ = ob.PlaceMarketOrder(NewOrder(true, dec(1), 0))
+	assert(t, len(matches), 2)
+	assert(t, matches[1].Bid, stop)
+	assert(t, matches[1].Price, dec(10_100))
+	assert(t, stop.IsStopPending(), false)
+	assert(t, stop.IsFilled(), true)
+	assert(t, len(ob.Stops), 0)
+	assert(t, ob.AskTotalVolume(), dec(2))
+}
+
+func TestStopLimitOrderRestsWhenTriggered(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(dec(9_000), NewOrder(true, dec(1), 0))
+	ob.PlaceLimitOrder(dec(8_000), NewOrder(true, dec(5), 0))
+
+	stop := newStopOrder(false, 3, 9_000, 8_500)
+	assert(t, ob.PlaceStopOrder(stop), nil)
+
+	matches, _ := ob.PlaceMarketOrder(NewOrder(false, dec(1), 0))
+	// the stop's limit at 8_500 does not cross the 8_000 bid, so it rests
+	assert(t, len(matches), 1)
+	assert(t, stop.IsStopPending(), false)
+	assert(t, stop.Limit.Price, dec(8_500))
+	assert(t, ob.BestAsk(), stop.Limit)
+	assert(t, ob.Orders[stop.ID], stop)
+}
+
+func TestStopOrdersFireInDeterministicOrder(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(1), 0))
+	ob.PlaceLimitOrder(dec(10_500), NewOrder(false, dec(10), 0))
+
+	stopA := newStopOrder(true, 1, 10_000, 0)
+	stopB := newStopOrder(true, 1, 9_900, 0)
+	stopC := newStopOrder(true, 1, 10_000, 0)
+	for _, stop := range []*Order{stopA, stopB, stopC} {
+		assert(t, ob.PlaceStopOrder(stop), nil)
+	}
+
+	matches, _ := ob.PlaceMarketOrder(NewOrder(true, dec(1), 0))
+
+	// lowest trigger first, then arrival order for the same trigger
+	assert(t, len(matches), 4)
+	assert(t, matches[1].Bid, stopB)
+	assert(t, matches[2].Bid, stopA)
+	assert(t, matches[3].Bid, stopC)
+}
+
+func TestStopOrdersCascade(t *testing.T) {
+	ob := NewOrderbook()
+
+	ob.PlaceLimitOrder(dec(9_000), NewOrder(true, dec(1), 0))
+	ob.PlaceLimitOrder(dec(8_900), NewOrder(true, dec(1), 0))
+	ob.PlaceLimitOrder(dec(8_800), NewOrder(true, dec(1), 0))
+
+	// stopA trades at 8_900, which triggers stopB
+	stopA := newStopOrder(false, 1, 9_000, 0)
+	stopB := newStopOrder(false, 1, 8_900, 0)
+	ob.PlaceStopOrder(stopA)
+	ob.PlaceStopOrder(stopB)
+
+	matches, _ := ob.PlaceMarketOrder(NewOrder(false, dec(1), 0))
+
+	assert(t, len(matches), 3)
+	assert(t, matches[1].Ask, stopA)
+	assert(t, matches[1].Price, dec(8_900))
+	assert(t, matches[2].Ask, stopB)
+	assert(t, matches[2].Price, dec(8_800))
+	assert(t, ob.LastTradePrice(), dec(8_800))
+}
+
+func TestPlaceStopOrderRejected(t *testing.T) {
+	ob := NewOrderbook()
+
+	assert(t, ob.PlaceStopOrder(newStopOrder(true, 1, 0, 0)), ErrInvalidStopPrice)
+
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(1), 0))
+	ob.PlaceMarketOrder(NewOrder(true, dec(1), 0))
+
+	assert(t, ob.PlaceStopOrder(newStopOrder(true, 1, 10_000, 0)), ErrStopWouldTrigger)
+	assert(t, ob.PlaceStopOrder(newStopOrder(false, 1, 10_001, 0)), ErrStopWouldTrigger)
+	assert(t, len(ob.Stops), 0)
+}
+
+func TestPlaceStopOrderValidated(t *testing.T) {
+	ob := NewOrderbook()
+
+	assert(t, ob.PlaceStopOrder(newStopOrder(true, 0, 11_000, 0)), ErrInvalidSize)
+	assert(t, ob.PlaceStopOrder(newStopOrder(true, -1, 11_000, 11_100)), ErrInvalidSize)
+
+	ioc := newStopOrder(true, 1, 11_000, 11_100)
+	ioc.TimeInForce = ImmediateOrCancel
+	ob.StartAuction(0)
+	assert(t, ob.PlaceStopOrder(newStopOrder(true, 1, 11_000, 0)), ErrAuctionMarketOrder)
+	assert(t, ob.PlaceStopOrder(ioc), ErrAuctionTimeInForce)
+	assert(t, ob.PlaceStopOrder(newStopOrder(true, 1, 11_000, 11_100)), nil)
+
+	ob.halt()
+	assert(t, ob.PlaceStopOrder(newStopOrder(true, 1, 11_000, 11_100)), ErrTradingHalted)
+	assert(t, len(ob.Stops), 1)
+}
+
+func TestCancelStopOrder(t *testing.T) {
+	ob := NewOrderbook()
+
+	stop := newStopOrder(true, 1, 10_000, 0)
+	ob.PlaceStopOrder(stop)
+	ob.CancelOrder(stop)
+
+	assert(t, stop.IsStopPending(), false)
+	assert(t, len(ob.Stops), 0)
+
+	ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(2), 0))
+	matches, _ := ob.PlaceMarketOrder(NewOrder(true, dec(1), 0))
+	assert(t, len(matches), 1)
+	assert(t, ob.AskTotalVolume(), dec(1))
+}
@@ -0,0 +1,152 @@
+package orderbook
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+)
+
+// TimeInForce controls how long a limit order stays in the book.
+type TimeInForce string
+
+const (
+	// GoodTilCanceled rests until it is filled or cancelled.
+	GoodTilCanceled TimeInForce = "GTC"
+	// ImmediateOrCancel fills what it can on arrival and drops the rest.
+	ImmediateOrCancel TimeInForce = "IOC"
+	// FillOrKill fills completely on arrival or is rejected without touching
+	// the book.
+	FillOrKill TimeInForce = "FOK"
+	// GoodTilDate rests until Order.ExpiresAt.
+	GoodTilDate TimeInForce = "GTD"
+)
+
+var (
+	ErrFillOrKill         = errors.New("fill or kill order cannot be filled completely")
+	ErrOrderExpired       = errors.New("good til date order has already expired")
+	ErrInvalidTimeInForce = errors.New("invalid time in force")
+)
+
+func (tif TimeInForce) validate(o *Order, now int64) error {
+	switch tif {
+	case GoodTilCanceled, ImmediateOrCancel, FillOrKill:
+		return nil
+	case GoodTilDate:
+		if o.ExpiresAt <= now {
+			return ErrOrderExpired
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidTimeInForce, string(tif))
+	}
+}
+
+// expiryQueue is a min-heap of good til date orders by expiry time. Orders
+// that leave the book some other way stay in the queue and are skipped when
+// they are popped.
+type expiryQueue []*Order
+
+func (q expiryQueue) Len() int            { return len(q) }
+func (q expiryQueue) Less(i, j int) bool  { return q[i].ExpiresAt < q[j].ExpiresAt }
+func (q expiryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(*Order)) }
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	o := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return o
+}
+
+// ExpireOrders cancels every good til date order that expired at or before
+// now, given in unix nanoseconds, and returns them.
+func (ob *Orderbook) ExpireOrders(now int64) []*Order {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	defer ob.repeg(now)
+
+	return ob.expireOrders(now)
+}
+
+func (ob *Orderbook) expireOrders(now int64) []*Order {
+	var expired []*Order
+
+	for ob.expiries.Len() > 0 && ob.expiries[0].ExpiresAt <= now {
+		o := heap.Pop(&ob.expiries).(*Order)
+		if o.Limit == nil || ob.Orders[o.ID] != o {
+			continue
+		}
+		ob.cancelOrder(o, CancelExpired)
+		expired = append(expired, o)
+	}
+
+	return expired
+}
+
+// fillableVolume returns how much of the opposite side o could take at
+// prices the acceptable func allows, up to o.Size.
+func (ob *Orderbook) fillableVolume(o *Order, acceptable func(*Limit) bool) Decimal {
+	volume, _ := ob.planFill(o, acceptable)
+	return volume
+}
+
+// planFill works out what sweeping o over the levels the acceptable func
+// allows would do, without changing the book. It returns the volume o would
+// fill and whether o would be left with size after passing orders over for
+// their MinQty or AllOrNone conditions or its own.
+//
+// Self-trade prevention can cancel o, or take size off it without a trade,
+// when it reaches an order of its own user, and conditions pass orders
+// over, so for those the levels are filled for real on copies of o and of
+// their orders. Other levels just add up.
+func (ob *Orderbook) planFill(o *Order, acceptable func(*Limit) bool) (volume Decimal, blocked bool) {
+	levels := ob.asks
+	if !o.Bid {
+		levels = ob.bids
+	}
+
+	var (
+		taker      *Order
+		passedOver bool
+	)
+	levels.Each(func(limit *Limit) bool {
+		if volume.GreaterThanOrEqual(o.Size) || !acceptable(limit) {
+			return false
+		}
+		if taker == nil && o.SelfTradePrevention == "" && !o.conditional() && limit.conditional == 0 {
+			volume = volume.Add(MinDecimal(o.Size.Sub(volume), limit.TotalVolume.Add(limit.reserveVolume).Add(limit.hiddenVolume)))
+			return true
+		}
+
+		if taker == nil {
+			taker = o.scratch()
+			taker.Size = o.Size.Sub(volume)
+		}
+		level := limit.scratch()
+		for _, match := range level.fill(nil, taker) {
+			volume = volume.Add(match.SizeFilled)
+		}
+		passedOver = passedOver || level.count > 0
+		return !taker.done()
+	})
+	return volume, passedOver && !taker.done()
+}
+
+// scratch returns a copy of o to try a fill with, without the book it rests
+// in or the self-trade cancels it has so far.
+func (o *Order) scratch() *Order {
+	c := *o
+	c.Limit, c.prev, c.next = nil, nil, nil
+	c.SelfTradeCancels = nil
+	return &c
+}
+
+// scratch returns a copy of l holding copies of its orders, which can be
+// filled without touching l.
+func (l *Limit) scratch() *Limit {
+	c := &Limit{Price: l.Price, policy: l.policy}
+	for o := l.head; o != nil; o = o.next {
+		c.AddOrder(o.scratch())
+	}
+	return c
+}
@@ -0,0 +1,107 @@
+package orderbook
+
+import (
+	"sort"
+	"time"
+)
+
+const tradeSlabSize = 256
+
+// DefaultTradeRetention is how many trades an orderbook keeps on its tape
+// unless WithTradeRetention says otherwise.
+const DefaultTradeRetention = 100_000
+
+// WithTradeRetention sets how many of the most recent trades the tape keeps,
+// older trades are dropped as new ones are recorded. Zero or less keeps
+// every trade.
+func WithTradeRetention(n int) Option {
+	return func(ob *Orderbook) {
+		ob.tradeRetention = n
+	}
+}
+
+// retainTrades drops the oldest trades beyond the retention limit.
+func (ob *Orderbook) retainTrades() {
+	if ob.tradeRetention <= 0 {
+		return
+	}
+	for len(ob.Trades) > ob.tradeRetention {
+		ob.Trades[0] = nil
+		ob.Trades = ob.Trades[1:]
+	}
+}
+
+// recordTrade appends a Trade for the match and returns it. bid is the side
+// of the incoming order that caused it. The orders have already filled by
+// then, so it panics if the trade cannot get an ID: the engine halts the
+// market rather than carry on with fills missing from the tape. Reserving
+// the IDs ahead, see reserveIDs, keeps that from happening.
+func (ob *Orderbook) recordTrade(bid bool, match Match) *Trade {
+	id, err := ob.tradeIDs.Next()
+	if err != nil {
+		panic(err)
+	}
+
+	now := time.Now().UnixNano()
+	// keep the tape in timestamp order even if the wall clock steps back
+	if n := len(ob.Trades); n > 0 && ob.Trades[n-1].Timestamp > now {
+		now = ob.Trades[n-1].Timestamp
+	}
+
+	if len(ob.tradeSlab) == 0 {
+		ob.tradeSlab = make([]Trade, tradeSlabSize)
+	}
+	trade := &ob.tradeSlab[0]
+	ob.tradeSlab = ob.tradeSlab[1:]
+
+	*trade = Trade{
+		ID:         id,
+		Price:      match.Price,
+		Bid:        bid,
+		Timestamp:  now,
+		Size:       match.SizeFilled,
+		BidOrderID: match.Bid.ID,
+		AskOrderID: match.Ask.ID,
+	}
+	ob.Trades = append(ob.Trades, trade)
+	ob.retainTrades()
+
+	ob.addWindowTrades(ob.Trades[len(ob.Trades)-1:])
+	return trade
+}
+
+// TradeQuery selects a page of trades. From and To bound the trade
+// timestamps in unix nanoseconds, inclusive, and are ignored when zero.
+// After is a cursor: only trades with a higher ID are returned. The tape
+// only keeps the trades within its retention, see WithTradeRetention, so a
+// cursor pointing at a trade that has been dropped returns the page from
+// the oldest trade still kept. The trades in between are gone, which shows
+// as a gap between After and the ID of the first trade returned.
+type TradeQuery struct {
+	From  int64
+	To    int64
+	After int64
+	Limit int
+}
+
+// QueryTrades returns up to q.Limit trades matching q, oldest first.
+func (ob *Orderbook) QueryTrades(q TradeQuery) []*Trade {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	// trades are recorded in ID and timestamp order
+	start := sort.Search(len(ob.Trades), func(i int) bool {
+		t := ob.Trades[i]
+		return t.ID > q.After && t.Timestamp >= q.From
+	})
+
+	trades := []*Trade{}
+	for _, t := range ob.Trades[start:] {
+		if len(trades) >= q.Limit || q.To != 0 && t.Timestamp > q.To {
+			break
+		}
+		trades = append(trades, t)
+	}
+
+	return trades
+}
@@ -0,0 +1,82 @@
+package orderbook
+
+import "testing"
+
+func TestTradesRecorded(t *testing.T) {
+	ob := NewOrderbook()
+
+	sellOrderA := NewOrder(false, dec(2), 1)
+	sellOrderB := NewOrder(false, dec(3), 2)
+	buyOrderA := NewOrder(true, dec(1), 3)
+	ob.PlaceLimitOrder(dec(10_000), sellOrderA)
+	ob.PlaceLimitOrder(dec(10_100), sellOrderB)
+	ob.PlaceLimitOrder(dec(9_000), buyOrderA)
+
+	buyOrderB := NewOrder(true, dec(3), 4)
+	ob.PlaceMarketOrder(buyOrderB)
+	sellOrderC := NewOrder(false, dec(1), 5)
+	ob.PlaceLimitOrder(dec(9_000), sellOrderC)
+
+	assert(t, len(ob.Trades), 3)
+
+	trade := ob.Trades[0]
+	assert(t, trade.ID, int64(1))
+	assert(t, trade.Price, dec(10_000))
+	assert(t, trade.Size, dec(2))
+	assert(t, trade.Bid, true)
+	assert(t, trade.BidOrderID, buyOrderB.ID)
+	assert(t, trade.AskOrderID, sellOrderA.ID)
+
+	assert(t, ob.Trades[1].Price, dec(10_100))
+	assert(t, ob.Trades[1].Size, dec(1))
+
+	trade = ob.Trades[2]
+	assert(t, trade.ID, int64(3))
+	assert(t, trade.Bid, false)
+	assert(t, trade.BidOrderID, buyOrderA.ID)
+	assert(t, trade.AskOrderID, sellOrderC.ID)
+
+	for i := 1; i < len(ob.Trades); i++ {
+		if ob.Trades[i].Timestamp < ob.Trades[i-1].Timestamp {
+			t.Errorf("trade %d is older than the one before it", i)
+		}
+	}
+}
+
+func TestQueryTrades(t *testing.T) {
+	ob := NewOrderbook()
+	for i := int64(1); i <= 10; i++ {
+		ob.Trades = append(ob.Trades, &Trade{ID: i, Timestamp: i * 100})
+	}
+
+	ids := func(trades []*Trade) []int64 {
+		ids := []int64{}
+		for _, t := range trades {
+			ids = append(ids, t.ID)
+		}
+		return ids
+	}
+
+	assert(t, ids(ob.QueryTrades(TradeQuery{Limit: 3})), []int64{1, 2, 3})
+	assert(t, ids(ob.QueryTrades(TradeQuery{After: 3, Limit: 3})), []int64{4, 5, 6})
+	assert(t, ids(ob.QueryTrades(TradeQuery{From: 450, To: 700, Limit: 10})), []int64{5, 6, 7})
+	assert(t, ids(ob.QueryTrades(TradeQuery{From: 450, After: 6, Limit: 10})), []int64{7, 8, 9, 10})
+	assert(t, ids(ob.QueryTrades(TradeQuery{After: 10, Limit: 10})), []int64{})
+}
+
+func TestTradeRetention(t *testing.T) {
+	ob := NewOrderbook(WithTradeRetention(3))
+	for i := 0; i < 5; i++ {
+		ob.PlaceLimitOrder(dec(10_000), NewOrder(false, dec(1), 0))
+		ob.PlaceMarketOrder(NewOrder(true, dec(1), 0))
+	}
+
+	assert(t, len(ob.Trades), 3)
+	assert(t, ob.Trades[0].ID, int64(3))
+	assert(t, ob.Trades[2].ID, int64(5))
+
+	// a cursor at a dropped trade pages on from the oldest trade kept
+	trades := ob.QueryTrades(TradeQuery{After: 1, Limit: 2})
+	assert(t, len(trades), 2)
+	assert(t, trades[0].ID, int64(3))
+}
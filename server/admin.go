@@ -0,0 +1,27 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// adminTokenEnv names the environment variable holding the bearer token the
+// admin routes require. The routes are only registered when it is set.
+const adminTokenEnv = "EXCHANGE_ADMIN_TOKEN"
+
+// requireAdminToken rejects requests that do not carry token as their
+// bearer token.
+func requireAdminToken(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			got, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				return c.JSON(http.StatusUnauthorized, map[string]interface{}{"msg": "admin token required"})
+			}
+			return next(c)
+		}
+	}
+}
@@ -2,7 +2,10 @@ package server
 
 import (
 	"crypto/ecdsa"
+	"errors"
+	"log"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
@@ -15,13 +18,51 @@ type Exchange struct {
 	mu         sync.RWMutex
 	Orders     map[int64][]*orderbook.Order
 	orderbooks map[Market]*orderbook.Orderbook
+	// engines own the orderbooks, every read or write of a book or of the
+	// orders in it goes through its market's engine
+	engines map[Market]*orderbook.Engine
+	markets map[Market]*MarketRules
+	// orderIDs numbers the orders of every market, so an order ID is unique
+	// across the exchange
+	orderIDs *orderbook.Sequencer
+	// tradeIDs numbers the trades of each market
+	tradeIDs   map[Market]*orderbook.Sequencer
 	PrivateKey *ecdsa.PrivateKey
 	Client     *ethclient.Client
 }
 
 func NewExchange(privateKey string, client *ethclient.Client) (*Exchange, error) {
+	for _, rules := range defaultMarketRules {
+		if err := rules.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	orderIDs := orderbook.NewSequencer(0)
+	tradeIDs := map[Market]*orderbook.Sequencer{
+		MarketETH: orderbook.NewSequencer(0),
+	}
 	orderbooks := make(map[Market]*orderbook.Orderbook)
-	orderbooks[MarketETH] = orderbook.NewOrderbook()
+	// each market picks how orders at the same price share fills, see
+	// orderbook.ProRata and orderbook.TopOfQueueProRata
+	orderbooks[MarketETH] = orderbook.NewOrderbook(
+		orderbook.WithMatchingPolicy(orderbook.PriceTime{}),
+		orderbook.WithOrderSequencer(orderIDs),
+		orderbook.WithTradeSequencer(tradeIDs[MarketETH]),
+		orderbook.WithTickSize(defaultMarketRules[MarketETH].TickSize),
+		orderbook.WithLotSize(defaultMarketRules[MarketETH].LotSize),
+		orderbook.WithPriceBand(orderbook.PriceBand{
+			Width:  orderbook.RequireFromString("0.2"),
+			Window: 5 * time.Minute,
+		}),
+		orderbook.WithCircuitBreaker(orderbook.CircuitBreaker{
+			MaxMove: orderbook.RequireFromString("0.15"),
+			Window:  time.Minute,
+			Halt:    5 * time.Minute,
+			// reopen with an auction rather than straight into the old book
+			ReopenAuction: true,
+		}),
+	)
 
 	pk, err := crypto.HexToECDSA(privateKey)
 	if err != nil {
@@ -30,11 +71,103 @@ func NewExchange(privateKey string, client *ethclient.Client) (*Exchange, error)
 
 	// publicAddress := crypto.PubkeyToAddress(pk.PublicKey)
 
+	engines := make(map[Market]*orderbook.Engine)
+	for market, ob := range orderbooks {
+		engines[market] = orderbook.NewEngine(ob)
+	}
+
 	return &Exchange{
 		orderbooks: orderbooks,
+		engines:    engines,
+		markets:    defaultMarketRules,
+		orderIDs:   orderIDs,
+		tradeIDs:   tradeIDs,
 		PrivateKey: pk,
 		users:      make(map[int64]*User),
 		Orders:     make(map[int64][]*orderbook.Order),
 		Client:     client,
 	}, nil
 }
+
+var errMarketNotFound = errors.New("market not found")
+
+// do runs fn on the engine of market, see orderbook.Engine.Do. Handlers
+// never touch a book or the orders in it other than through its engine.
+func (ex *Exchange) do(market Market, fn func(ob *orderbook.Orderbook)) error {
+	engine, ok := ex.engines[market]
+	if !ok {
+		return errMarketNotFound
+	}
+	return engine.Do(fn)
+}
+
+// Close stops the engine of every market.
+func (ex *Exchange) Close() {
+	for _, engine := range ex.engines {
+		engine.Stop()
+	}
+}
+
+// expireOrders removes expired good til date orders from every orderbook,
+// checking once per interval.
+func (ex *Exchange) expireOrders(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		now := time.Now().UnixNano()
+		for market, engine := range ex.engines {
+			expired := 0
+			engine.Do(func(ob *orderbook.Orderbook) {
+				for _, order := range ob.ExpireOrders(now) {
+					log.Printf("expired GTD order => id: {%d} market: {%s} bid: {%v} size: {%s}", order.ID, market, order.Bid, order.Size)
+					expired++
+				}
+			})
+			if expired > 0 {
+				ex.removeClosedOrders()
+			}
+		}
+	}
+}
+
+// runAuctions uncrosses every market whose auction has reached its end and
+// settles the auction trades. A new market listed with an opening auction
+// is started with orderbook.StartAuction and reopened here too.
+func (ex *Exchange) runAuctions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		now := time.Now().UnixNano()
+		for market, engine := range ex.engines {
+			var (
+				matches   []orderbook.Match
+				uncrossed bool
+			)
+			engine.Do(func(ob *orderbook.Orderbook) {
+				state := ob.State()
+				if state.Status != orderbook.StatusAuction || state.AuctionEnd == 0 || state.AuctionEnd > now {
+					return
+				}
+
+				var err error
+				matches, err = ob.Uncross()
+				if err != nil {
+					return
+				}
+				uncrossed = true
+				log.Printf("uncrossed auction => market: {%s} price: {%s} volume: {%s} matches: {%d}", market, state.IndicativePrice, state.IndicativeVolume, len(matches))
+			})
+			if !uncrossed {
+				continue
+			}
+			// self-trade prevention and order conditions can cancel orders
+			// even when nothing trades
+			ex.removeClosedOrders()
+			if len(matches) == 0 {
+				continue
+			}
+
+			if err := ex.handleMatches(matches); err != nil {
+				log.Printf("settling auction matches failed => market: {%s} err: {%v}", market, err)
+			}
+		}
+	}
+}
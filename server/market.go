@@ -0,0 +1,318 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/natac13/go-crypto-exchange/orderbook"
+)
+
+// MarketRules are the trading rules of a market. Orders that break them are
+// rejected before they reach the orderbook. A zero rule is not enforced.
+type MarketRules struct {
+	Market Market `json:"market"`
+	// TickSize is the price increment, LotSize the size increment
+	TickSize orderbook.Decimal `json:"tickSize"`
+	LotSize  orderbook.Decimal `json:"lotSize"`
+	MinQty   orderbook.Decimal `json:"minQty"`
+	MaxQty   orderbook.Decimal `json:"maxQty"`
+	// MinNotional is the smallest price * size an order may have
+	MinNotional orderbook.Decimal `json:"minNotional"`
+	// PriceScale and SizeScale are the decimal places the market quotes
+	// prices and sizes in. Unlike the other rules they are always enforced,
+	// a zero scale only allows whole numbers.
+	PriceScale orderbook.Scale `json:"priceScale"`
+	SizeScale  orderbook.Scale `json:"sizeScale"`
+}
+
+// RuleCode identifies why an order was rejected by the market rules.
+type RuleCode string
+
+const (
+	CodeUnknownMarket    RuleCode = "UNKNOWN_MARKET"
+	CodeInvalidOrderType RuleCode = "INVALID_ORDER_TYPE"
+	CodeInvalidPrice     RuleCode = "INVALID_PRICE"
+	CodePriceTick        RuleCode = "PRICE_NOT_ON_TICK"
+	CodeInvalidSize      RuleCode = "INVALID_SIZE"
+	CodeSizeLot          RuleCode = "SIZE_NOT_ON_LOT"
+	CodeSizeTooSmall     RuleCode = "SIZE_TOO_SMALL"
+	CodeSizeTooLarge     RuleCode = "SIZE_TOO_LARGE"
+	CodeNotionalTooSmall RuleCode = "NOTIONAL_TOO_SMALL"
+	CodeNotionalTooLarge RuleCode = "NOTIONAL_TOO_LARGE"
+	CodeInvalidQuoteSize RuleCode = "INVALID_QUOTE_SIZE"
+	CodeInvalidPeg       RuleCode = "INVALID_PEG"
+	CodeInvalidSlippage  RuleCode = "INVALID_SLIPPAGE"
+	CodeInvalidSTP       RuleCode = "INVALID_SELF_TRADE_PREVENTION"
+	CodePrecision        RuleCode = "TOO_MANY_DECIMALS"
+)
+
+// RuleError is an order rejected by the market rules. It is sent to the
+// client as is.
+type RuleError struct {
+	Code RuleCode `json:"code"`
+	Msg  string   `json:"msg"`
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Msg)
+}
+
+func ruleErrorf(code RuleCode, format string, args ...interface{}) *RuleError {
+	return &RuleError{Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+var defaultMarketRules = map[Market]*MarketRules{
+	MarketETH: {
+		Market:      MarketETH,
+		TickSize:    orderbook.RequireFromString("0.01"),
+		LotSize:     orderbook.RequireFromString("0.001"),
+		MinQty:      orderbook.RequireFromString("0.001"),
+		MaxQty:      orderbook.RequireFromInt(1_000),
+		MinNotional: orderbook.RequireFromInt(10),
+		PriceScale:  4,
+		SizeScale:   6,
+	},
+}
+
+// validate checks that the rules themselves fit the market's scales.
+func (r *MarketRules) validate() error {
+	for _, scale := range []orderbook.Scale{r.PriceScale, r.SizeScale} {
+		if err := scale.Validate(); err != nil {
+			return fmt.Errorf("market %s: %w", r.Market, err)
+		}
+	}
+	if !r.PriceScale.Fits(r.TickSize) || !r.PriceScale.Fits(r.MinNotional) {
+		return fmt.Errorf("market %s: tick size and minimum notional must fit the price scale of %d", r.Market, r.PriceScale)
+	}
+	for _, size := range []orderbook.Decimal{r.LotSize, r.MinQty, r.MaxQty} {
+		if !r.SizeScale.Fits(size) {
+			return fmt.Errorf("market %s: lot size and size limits must fit the size scale of %d", r.Market, r.SizeScale)
+		}
+	}
+	return nil
+}
+
+func onIncrement(d, step orderbook.Decimal) bool {
+	return step.IsZero() || d.Units()%step.Units() == 0
+}
+
+// checkScale validates that the value named name has no more decimal places
+// than scale. Requests are decoded at the engine's full precision, this is
+// where they are brought to the market's.
+func checkScale(name string, d orderbook.Decimal, scale orderbook.Scale) error {
+	if !scale.Fits(d) {
+		return ruleErrorf(CodePrecision, "%s %s has more than %d decimal places", name, d, scale)
+	}
+	return nil
+}
+
+// checkScales validates every price and size of an order request against
+// the market's scales. The quote size is an amount of the quote asset, so
+// it is at the price scale.
+func (r *MarketRules) checkScales(req *PlaceOrderRequest) error {
+	prices := []struct {
+		name  string
+		value orderbook.Decimal
+	}{
+		{"price", req.Price},
+		{"stop price", req.StopPrice},
+		{"worst price", req.WorstPrice},
+		{"peg offset", req.PegOffset},
+		{"quote size", req.QuoteSize},
+	}
+	for _, p := range prices {
+		if err := checkScale(p.name, p.value, r.PriceScale); err != nil {
+			return err
+		}
+	}
+
+	sizes := []struct {
+		name  string
+		value orderbook.Decimal
+	}{
+		{"size", req.Size},
+		{"display size", req.DisplaySize},
+		{"minimum quantity", req.MinQty},
+	}
+	for _, s := range sizes {
+		if err := checkScale(s.name, s.value, r.SizeScale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPrice validates a price the order names, like its limit or stop
+// price.
+func (r *MarketRules) checkPrice(name string, price orderbook.Decimal) error {
+	if !price.IsPositive() {
+		return ruleErrorf(CodeInvalidPrice, "%s must be positive, got %s", name, price)
+	}
+	if !onIncrement(price, r.TickSize) {
+		return ruleErrorf(CodePriceTick, "%s %s is not a multiple of the tick size %s", name, price, r.TickSize)
+	}
+	return nil
+}
+
+// checkSize validates the size of an order.
+func (r *MarketRules) checkSize(size orderbook.Decimal) error {
+	if !size.IsPositive() {
+		return ruleErrorf(CodeInvalidSize, "size must be positive, got %s", size)
+	}
+	if !onIncrement(size, r.LotSize) {
+		return ruleErrorf(CodeSizeLot, "size %s is not a multiple of the lot size %s", size, r.LotSize)
+	}
+	if size.LessThan(r.MinQty) {
+		return ruleErrorf(CodeSizeTooSmall, "size %s is below the minimum of %s", size, r.MinQty)
+	}
+	if !r.MaxQty.IsZero() && size.GreaterThan(r.MaxQty) {
+		return ruleErrorf(CodeSizeTooLarge, "size %s is above the maximum of %s", size, r.MaxQty)
+	}
+	return nil
+}
+
+// checkNotional validates the value of an order of size at price. A zero
+// price, like that of a market order in a market that has not traded yet,
+// is not checked. Both come from the client, so a notional too large for a
+// Decimal is rejected rather than left to panic.
+func (r *MarketRules) checkNotional(price, size orderbook.Decimal) error {
+	if price.IsZero() {
+		return nil
+	}
+	notional, err := price.CheckedMul(size)
+	if err != nil {
+		return ruleErrorf(CodeNotionalTooLarge, "notional of %s at %s is too large", size, price)
+	}
+	if notional.LessThan(r.MinNotional) {
+		return ruleErrorf(CodeNotionalTooSmall, "notional %s is below the minimum of %s", notional, r.MinNotional)
+	}
+	return nil
+}
+
+// checkSlippage validates the slippage guard of a market order: MaxSlippage
+// is a fraction of the best price and WorstPrice a price like any other.
+func (r *MarketRules) checkSlippage(req *PlaceOrderRequest) error {
+	if req.MaxSlippage.IsNegative() || req.MaxSlippage.GreaterThan(orderbook.RequireFromInt(1)) {
+		return ruleErrorf(CodeInvalidSlippage, "max slippage %s must be between 0 and 1", req.MaxSlippage)
+	}
+	if req.WorstPrice.IsZero() {
+		return nil
+	}
+	return r.checkPrice("worst price", req.WorstPrice)
+}
+
+// validateOrder checks an order request against the rules. lastPrice is the
+// market's last trade price, used for the notional of market and stop
+// market orders.
+func (r *MarketRules) validateOrder(req *PlaceOrderRequest, lastPrice orderbook.Decimal) error {
+	if err := r.checkScales(req); err != nil {
+		return err
+	}
+	if err := r.checkSlippage(req); err != nil {
+		return err
+	}
+	if !req.QuoteSize.IsZero() {
+		return r.checkQuoteSize(req)
+	}
+	if err := r.checkSize(req.Size); err != nil {
+		return err
+	}
+	if !req.DisplaySize.IsZero() && !onIncrement(req.DisplaySize, r.LotSize) {
+		return ruleErrorf(CodeSizeLot, "display size %s is not a multiple of the lot size %s", req.DisplaySize, r.LotSize)
+	}
+	if req.MinQty.IsNegative() {
+		return ruleErrorf(CodeInvalidSize, "minimum quantity %s must not be negative", req.MinQty)
+	}
+	if !onIncrement(req.MinQty, r.LotSize) {
+		return ruleErrorf(CodeSizeLot, "minimum quantity %s is not a multiple of the lot size %s", req.MinQty, r.LotSize)
+	}
+
+	if err := req.SelfTradePrevention.Validate(); err != nil {
+		return ruleErrorf(CodeInvalidSTP, "unknown self-trade prevention mode %q", req.SelfTradePrevention)
+	}
+
+	if req.Hidden && req.Type != LimitOrder && req.Type != PeggedOrder {
+		return ruleErrorf(CodeInvalidOrderType, "only limit and pegged orders can be hidden, got %q", req.Type)
+	}
+	if req.Type != PeggedOrder && (req.Peg != "" || !req.PegOffset.IsZero()) {
+		return ruleErrorf(CodeInvalidPeg, "only pegged orders have a peg, got %q", req.Type)
+	}
+
+	notionalPrice := lastPrice
+	switch req.Type {
+	case MarketOrder:
+	case LimitOrder:
+		notionalPrice = req.Price
+		if err := r.checkPrice("price", req.Price); err != nil {
+			return err
+		}
+	case StopMarketOrder, StopLimitOrder:
+		notionalPrice = req.StopPrice
+		if err := r.checkPrice("stop price", req.StopPrice); err != nil {
+			return err
+		}
+		if req.Type == StopLimitOrder {
+			notionalPrice = req.Price
+			if err := r.checkPrice("price", req.Price); err != nil {
+				return err
+			}
+		}
+	case PeggedOrder:
+		switch req.Peg {
+		case orderbook.PegBestBid, orderbook.PegBestAsk, orderbook.PegMid:
+		default:
+			return ruleErrorf(CodeInvalidPeg, "unknown peg %q", req.Peg)
+		}
+		if !onIncrement(req.PegOffset, r.TickSize) {
+			return ruleErrorf(CodePriceTick, "peg offset %s is not a multiple of the tick size %s", req.PegOffset, r.TickSize)
+		}
+	default:
+		return ruleErrorf(CodeInvalidOrderType, "unknown order type %q", req.Type)
+	}
+
+	return r.checkNotional(notionalPrice, req.Size)
+}
+
+// checkQuoteSize validates a market order sized in quote. The base size it
+// buys is worked out by the book in whole lots, so only the quote itself is
+// checked against the minimum notional.
+func (r *MarketRules) checkQuoteSize(req *PlaceOrderRequest) error {
+	if req.Type != MarketOrder {
+		return ruleErrorf(CodeInvalidQuoteSize, "quote size is only for market orders, got %q", req.Type)
+	}
+	if !req.Size.IsZero() {
+		return ruleErrorf(CodeInvalidQuoteSize, "an order has either a size or a quote size, got both")
+	}
+	if !req.QuoteSize.IsPositive() {
+		return ruleErrorf(CodeInvalidQuoteSize, "quote size must be positive, got %s", req.QuoteSize)
+	}
+	if req.QuoteSize.LessThan(r.MinNotional) {
+		return ruleErrorf(CodeNotionalTooSmall, "quote size %s is below the minimum notional of %s", req.QuoteSize, r.MinNotional)
+	}
+	return nil
+}
+
+// validateAmend checks the price and size an amend asks for. Only what the
+// amend changes is checked, so a partly filled order whose rest is below the
+// minimum size can still be repriced. It runs before the amend reaches the
+// engine, the notional of a new size at the order's current price is
+// checked there with checkNotional.
+func (r *MarketRules) validateAmend(req *AmendOrderRequest) error {
+	if err := checkScale("price", req.Price, r.PriceScale); err != nil {
+		return err
+	}
+	if err := checkScale("size", req.Size, r.SizeScale); err != nil {
+		return err
+	}
+	if !req.Price.IsZero() {
+		if err := r.checkPrice("price", req.Price); err != nil {
+			return err
+		}
+	}
+	if !req.Size.IsZero() {
+		if err := r.checkSize(req.Size); err != nil {
+			return err
+		}
+		return r.checkNotional(req.Price, req.Size)
+	}
+	return nil
+}
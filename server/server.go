@@ -3,10 +3,13 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
+	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
@@ -17,8 +20,14 @@ import (
 const (
 	MarketETH Market = "ETH"
 
-	MarketOrder OrderType = "MARKET"
-	LimitOrder  OrderType = "LIMIT"
+	defaultTradesLimit = 100
+	maxTradesLimit     = 1000
+
+	MarketOrder     OrderType = "MARKET"
+	LimitOrder      OrderType = "LIMIT"
+	StopMarketOrder OrderType = "STOP_MARKET"
+	StopLimitOrder  OrderType = "STOP_LIMIT"
+	PeggedOrder     OrderType = "PEGGED"
 
 	// dont ever do this
 	// user 0 is the exchange
@@ -30,41 +39,135 @@ type (
 	Market    string
 
 	PlaceOrderRequest struct {
-		UserID int64     `json:"userId"`
-		Market Market    `json:"market"`
-		Price  float64   `json:"price"`
-		Size   float64   `json:"size"`
-		Bid    bool      `json:"bid"`
-		Type   OrderType `json:"type"` // market or limit
+		UserID int64             `json:"userId"`
+		Market Market            `json:"market"`
+		Price  orderbook.Decimal `json:"price"`
+		Size   orderbook.Decimal `json:"size"`
+		Bid    bool              `json:"bid"`
+		Type   OrderType         `json:"type"` // market, limit, stop market, stop limit or pegged
+		// StopPrice is the last trade price that triggers a stop order, Price
+		// is the limit price a stop limit order is placed at
+		StopPrice orderbook.Decimal `json:"stopPrice"`
+		// TimeInForce only applies to limit orders, it defaults to GTC
+		TimeInForce orderbook.TimeInForce `json:"timeInForce,omitempty"`
+		// ExpiresAt is required for GTD orders, in unix nanoseconds
+		ExpiresAt int64 `json:"expiresAt,omitempty"`
+		// PostOnly limit orders never take liquidity. PostOnlyMode is REJECT
+		// (the default) or SLIDE to reprice one tick passive instead.
+		PostOnly     bool                   `json:"postOnly,omitempty"`
+		PostOnlyMode orderbook.PostOnlyMode `json:"postOnlyMode,omitempty"`
+		// DisplaySize makes a limit order an iceberg, only a slice of this
+		// size is shown in the book at a time
+		DisplaySize orderbook.Decimal `json:"displaySize"`
+		// SelfTradePrevention is CN, CO, CB or DC, it defaults to the user's
+		// own setting
+		SelfTradePrevention orderbook.SelfTradePrevention `json:"selfTradePrevention,omitempty"`
+		// AllowPartialFill lets a market order fill what the book has
+		// instead of being rejected. WorstPrice and MaxSlippage, a fraction
+		// of the best price like 0.01, limit how far it walks the book.
+		AllowPartialFill bool              `json:"allowPartialFill,omitempty"`
+		WorstPrice       orderbook.Decimal `json:"worstPrice"`
+		MaxSlippage      orderbook.Decimal `json:"maxSlippage"`
+		// QuoteSize sizes a market order in the quote currency instead of
+		// Size, like buying 500 USD worth of ETH
+		QuoteSize orderbook.Decimal `json:"quoteSize"`
+		// Hidden limit and pegged orders match like any other but are left
+		// out of the public book
+		Hidden bool `json:"hidden,omitempty"`
+		// Peg is the price a pegged order follows, BEST_BID, BEST_ASK or
+		// MID, PegOffset is how far from it the order rests
+		Peg       orderbook.PegReference `json:"peg,omitempty"`
+		PegOffset orderbook.Decimal      `json:"pegOffset"`
+		// MinQty is the smallest fill the order takes, AllOrNone only lets
+		// it fill completely at once. Orders that cannot meet them pass a
+		// resting order over.
+		MinQty    orderbook.Decimal `json:"minQty"`
+		AllOrNone bool              `json:"allOrNone,omitempty"`
 	}
 
 	PlaceOrderResponse struct {
 		OrderID int64  `json:"orderId"`
 		Message string `json:"message"`
+		// Repriced is set when a post-only order was moved to RestingPrice so
+		// it would not cross the book. RestingPrice is also where a pegged
+		// order was placed.
+		Repriced     bool               `json:"repriced,omitempty"`
+		RestingPrice *orderbook.Decimal `json:"restingPrice,omitempty"`
+		// SelfTradeCanceled lists the size self-trade prevention took off
+		// this order and the user's resting orders
+		SelfTradeCanceled []*CanceledOrder `json:"selfTradeCanceled,omitempty"`
+		// Filled and Unfilled split the size of a market order into what
+		// traded and what was dropped
+		Filled   *orderbook.Decimal `json:"filled,omitempty"`
+		Unfilled *orderbook.Decimal `json:"unfilled,omitempty"`
+		// AvgPrice is what a market order paid or got per unit on average.
+		// QuoteLeft is the part of the quote size of an order sized in
+		// quote that did not trade, in which case Unfilled is not set.
+		AvgPrice  *orderbook.Decimal `json:"avgPrice,omitempty"`
+		QuoteLeft *orderbook.Decimal `json:"quoteLeft,omitempty"`
+	}
+
+	CanceledOrder struct {
+		ID           int64             `json:"id"`
+		Bid          bool              `json:"bid"`
+		SizeCanceled orderbook.Decimal `json:"sizeCanceled"`
+	}
+
+	// AmendOrderRequest changes a resting order. A zero price or size keeps
+	// the current one, size is the new remaining size.
+	AmendOrderRequest struct {
+		Price orderbook.Decimal `json:"price"`
+		Size  orderbook.Decimal `json:"size"`
 	}
 
 	MatchedOrder struct {
-		Price      float64 `json:"price"`
-		SizeFilled float64 `json:"sizeFilled"`
-		ID         int64   `json:"id"`
-		UserID     int64   `json:"userId"`
+		Price      orderbook.Decimal `json:"price"`
+		SizeFilled orderbook.Decimal `json:"sizeFilled"`
+		ID         int64             `json:"id"`
+		UserID     int64             `json:"userId"`
 	}
 
 	Order struct {
-		UserID    int64   `json:"userId"`
-		ID        int64   `json:"id"`
-		Price     float64 `json:"price"`
-		Size      float64 `json:"size"`
-		Bid       bool    `json:"bid"`
-		Timestamp int64   `json:"timestamp"`
+		UserID    int64             `json:"userId"`
+		ID        int64             `json:"id"`
+		Price     orderbook.Decimal `json:"price"`
+		Size      orderbook.Decimal `json:"size"`
+		Bid       bool              `json:"bid"`
+		Timestamp int64             `json:"timestamp"`
+		// StopPrice is only set for stop orders waiting for their trigger
+		StopPrice *orderbook.Decimal `json:"stopPrice,omitempty"`
+	}
+
+	// TradesResponse is one page of the tape. NextCursor is passed back as
+	// cursor to get the next page.
+	TradesResponse struct {
+		Market     Market             `json:"market"`
+		Trades     []*orderbook.Trade `json:"trades"`
+		NextCursor int64              `json:"nextCursor"`
 	}
 
 	OrderbookResponse struct {
-		Market         Market   `json:"market"`
-		Asks           []*Order `json:"asks"`
-		Bids           []*Order `json:"bids"`
-		TotalBidVolume float64  `json:"totalBidVolume"`
-		TotalAskVolume float64  `json:"totalAskVolume"`
+		Market         Market            `json:"market"`
+		Asks           []*Order          `json:"asks"`
+		Bids           []*Order          `json:"bids"`
+		TotalBidVolume orderbook.Decimal `json:"totalBidVolume"`
+		TotalAskVolume orderbook.Decimal `json:"totalAskVolume"`
+		// State says whether the market is trading or halted and where its
+		// price band is
+		State orderbook.MarketState `json:"state"`
+	}
+
+	// DepthResponse is the aggregated book, best price first on both sides
+	DepthResponse struct {
+		Market Market                 `json:"market"`
+		Bids   []orderbook.DepthLevel `json:"bids"`
+		Asks   []orderbook.DepthLevel `json:"asks"`
+		State  orderbook.MarketState  `json:"state"`
+	}
+
+	MarketResponse struct {
+		*MarketRules
+		State orderbook.MarketState `json:"state"`
 	}
 )
 
@@ -84,24 +187,29 @@ func seedUsers(ex *Exchange) error {
 	userData := []struct {
 		pkStr string
 		id    int64
+		stp   orderbook.SelfTradePrevention
 	}{
 		// {pkStr0, 0},
-		{pkStr1, 1},
-		{pkStr2, 2},
-		{pkStr3, 3},
-		{pkStr4, 4},
-		{pkStr5, 5},
-		{pkStr6, 6},
-		{pkStr7, 7},
-		{pkStr8, 8}, // seller
-		{pkStr9, 9}, // buyer
+		{pkStr1, 1, ""},
+		{pkStr2, 2, ""},
+		{pkStr3, 3, ""},
+		{pkStr4, 4, ""},
+		{pkStr5, 5, ""},
+		{pkStr6, 6, ""},
+		{pkStr7, 7, orderbook.CancelOldest}, // market maker, new quotes replace crossing old ones
+		{pkStr8, 8, ""},                     // seller
+		{pkStr9, 9, ""},                     // buyer
 	}
 
 	for _, data := range userData {
+		if err := data.stp.Validate(); err != nil {
+			return fmt.Errorf("user %d: %w", data.id, err)
+		}
 		user, err := NewUser(data.pkStr, data.id)
 		if err != nil {
 			return err
 		}
+		user.SelfTradePrevention = data.stp
 		ex.users[user.ID] = user
 		pubKey := user.PublicKey
 		pubAddress := crypto.PubkeyToAddress(*pubKey)
@@ -136,8 +244,19 @@ func StartServer() {
 		log.Fatal(err)
 	}
 
+	if err := ex.loadSnapshots(snapshotDir); err != nil {
+		log.Fatal(err)
+	}
+	if err := ex.reserveIDs(snapshotDir); err != nil {
+		log.Fatal(err)
+	}
+
+	go ex.expireOrders(time.Second)
+	go ex.runAuctions(time.Second)
+
 	e.POST("/order", ex.handlePlaceOrder)
 	e.DELETE("/order/:id", ex.handleCancelOrder)
+	e.PATCH("/order/:id", ex.handleAmendOrder)
 
 	e.GET("/orders/:userId", ex.handleGetUserOrders)
 	e.GET("/book/:market", ex.handleGetBook)
@@ -145,6 +264,16 @@ func StartServer() {
 	e.GET("/book/:market/asks", ex.handleGetAllAsks)
 	e.GET("/book/:market/best-bid", ex.handleGetBestBid)
 	e.GET("/book/:market/best-ask", ex.handleGetBestAsk)
+	e.GET("/book/:market/depth", ex.handleGetDepth)
+	e.GET("/trades/:market", ex.handleGetTrades)
+	e.GET("/markets/:market", ex.handleGetMarket)
+
+	if token := os.Getenv(adminTokenEnv); token != "" {
+		admin := e.Group("/admin", requireAdminToken(token))
+		admin.POST("/snapshot", ex.handleSnapshot)
+	} else {
+		log.Printf("%s is not set, the admin routes are off", adminTokenEnv)
+	}
 
 	e.Start(":3000")
 }
@@ -173,84 +302,162 @@ func (ex *Exchange) handleGetUserOrders(c echo.Context) error {
 	}
 
 	ex.mu.RLock()
-	orderbookOrders := ex.Orders[int64(userId)]
+	orderbookOrders := append([]*orderbook.Order(nil), ex.Orders[int64(userId)]...)
+	ex.mu.RUnlock()
 
 	orderRes := &UserOrdersResponse{
 		Asks: []Order{},
 		Bids: []Order{},
 	}
 
-	for _, order := range orderbookOrders {
-		// it could be that the order is getting filled even though it is still in the exchange
-		// we must check if the order limit is nil
-		if order.Limit == nil {
-			continue
-		}
-
-		order := Order{
-			UserID:    order.UserID,
-			ID:        order.ID,
-			Price:     order.Limit.Price,
-			Size:      order.Size,
-			Bid:       order.Bid,
-			Timestamp: order.Timestamp,
-		}
-
-		if order.Bid {
-			orderRes.Bids = append(orderRes.Bids, order)
-		} else {
-			orderRes.Asks = append(orderRes.Asks, order)
-		}
+	for _, engine := range ex.engines {
+		engine.Do(func(ob *orderbook.Orderbook) {
+			for _, order := range orderbookOrders {
+				// it could be that the order is getting filled even though it is still in the exchange,
+				// or that it belongs to another market
+				if ob.Order(order.ID) != order {
+					continue
+				}
+
+				userOrder := Order{
+					UserID:    order.UserID,
+					ID:        order.ID,
+					Size:      order.Size,
+					Bid:       order.Bid,
+					Timestamp: order.Timestamp,
+				}
+				if order.IsStopPending() {
+					stopPrice := order.StopPrice
+					userOrder.Price = order.StopLimitPrice
+					userOrder.StopPrice = &stopPrice
+				} else {
+					userOrder.Price = order.Limit.Price
+				}
+
+				if userOrder.Bid {
+					orderRes.Bids = append(orderRes.Bids, userOrder)
+				} else {
+					orderRes.Asks = append(orderRes.Asks, userOrder)
+				}
+			}
+		})
 	}
-	ex.mu.RUnlock()
 
 	return c.JSON(http.StatusOK, orderRes)
 }
 
-func (ex *Exchange) handlePlaceMarketOrder(market Market, order *orderbook.Order) ([]orderbook.Match, []*MatchedOrder, error) {
+// orderState is a copy of what handlePlaceOrder reports about an order. It
+// is taken on the engine as soon as the order is placed, because a resting
+// order keeps changing there as it trades.
+type orderState struct {
+	resting           bool
+	restingPrice      orderbook.Decimal
+	filled            bool
+	remaining         orderbook.Decimal
+	selfTradeCanceled bool
+	selfTradeCancels  []orderbook.SelfTradeCancel
+	quoteLeft         orderbook.Decimal
+}
 
-	ob, ok := ex.orderbooks[market]
+func stateOf(order *orderbook.Order) orderState {
+	state := orderState{
+		filled:            order.IsFilled(),
+		remaining:         order.Size.Add(order.Reserve()),
+		selfTradeCanceled: order.IsSelfTradeCanceled(),
+		selfTradeCancels:  append([]orderbook.SelfTradeCancel(nil), order.SelfTradeCancels...),
+		quoteLeft:         order.QuoteLeft(),
+	}
+	if order.Limit != nil {
+		state.resting = true
+		state.restingPrice = order.Limit.Price
+	}
+	return state
+}
 
-	if !ok {
-		return nil, nil, fmt.Errorf("market not found")
+func (ex *Exchange) handlePlaceMarketOrder(market Market, order *orderbook.Order) ([]orderbook.Match, []*MatchedOrder, orderState, error) {
+	var (
+		matches []orderbook.Match
+		state   orderState
+		err     error
+	)
+	if doErr := ex.do(market, func(ob *orderbook.Orderbook) {
+		matches, err = ob.PlaceMarketOrder(order)
+		state = stateOf(order)
+	}); doErr != nil {
+		return nil, nil, state, doErr
+	}
+	if err != nil {
+		return nil, nil, state, err
 	}
-	matches := ob.PlaceMarketOrder(order)
-	matchedOrders := make([]*MatchedOrder, len(matches))
+	matchedOrders := []*MatchedOrder{}
 
 	isBid := order.Bid
 
-	totalSizeFilled := 0.0
-	sumPrice := 0.0
-	for i, match := range matches {
+	totalSizeFilled := orderbook.Zero
+	sumPrice := orderbook.Zero
+	for _, match := range matches {
+		// the rest are matches of stop orders this order triggered
+		if match.Bid != order && match.Ask != order {
+			continue
+		}
+
 		id := match.Bid.ID
 		userId := match.Bid.UserID
 		if isBid {
 			id = match.Ask.ID
 			userId = match.Ask.UserID
 		}
-		matchedOrders[i] = &MatchedOrder{
+		matchedOrders = append(matchedOrders, &MatchedOrder{
 			SizeFilled: match.SizeFilled,
 			Price:      match.Price,
 			ID:         id,
 			UserID:     userId,
 			// UserID:     order.UserID,
-		}
-		totalSizeFilled += match.SizeFilled
-		sumPrice += match.Price * match.SizeFilled
+		})
+		totalSizeFilled = totalSizeFilled.Add(match.SizeFilled)
+		sumPrice = sumPrice.Add(match.Price.Mul(match.SizeFilled))
 	}
 
-	avgPrice := sumPrice / totalSizeFilled
+	avgPrice := orderbook.Zero
+	if !totalSizeFilled.IsZero() {
+		avgPrice = sumPrice.Div(totalSizeFilled)
+	}
+
+	log.Printf("filled MARKET order => id: {%d} bid: {%v} size filled: {%s} @ average price: {%s}", order.ID, order.Bid, totalSizeFilled, avgPrice)
 
-	log.Printf("filled MARKET order => id: {%d} bid: {%v} size filled: {%.2f} @ average price: {%.2f}", order.ID, order.Bid, totalSizeFilled, avgPrice)
+	ex.removeClosedOrders()
+
+	return matches, matchedOrders, state, nil
+}
+
+// removeClosedOrders drops every order that is no longer resting in a book,
+// because it was filled, cancelled or expired, from the exchange's per user
+// order lists.
+//
+// Which orders are open is read on the engines. Orders added to the lists
+// while that runs were not looked at and are kept, so it can be called
+// while the engines keep matching. It must not be called from an engine
+// command.
+func (ex *Exchange) removeClosedOrders() {
+	ex.mu.RLock()
+	checked := make(map[*orderbook.Order]bool)
+	for _, orderbookOrders := range ex.Orders {
+		for _, order := range orderbookOrders {
+			checked[order] = true
+		}
+	}
+	ex.mu.RUnlock()
+
+	open := ex.openOrders()
 
 	newOrderMap := make(map[int64][]*orderbook.Order)
 
 	ex.mu.Lock()
 	// delete the order from the exchange
-	// we are doing this by coping the orders to a new map without the filled orders
+	// we are doing this by coping the orders to a new map without the closed orders
 	for userId, orderbookOrders := range ex.Orders {
 		for _, order := range orderbookOrders {
-			if !order.IsFilled() {
+			if open[order] || !checked[order] {
 				newOrderMap[userId] = append(newOrderMap[userId], order)
 			}
 		}
@@ -258,27 +465,111 @@ func (ex *Exchange) handlePlaceMarketOrder(market Market, order *orderbook.Order
 
 	ex.Orders = newOrderMap
 	ex.mu.Unlock()
-
-	return matches, matchedOrders, nil
 }
 
-func (ex *Exchange) handlePlaceLimitOrder(market Market, price float64, order *orderbook.Order) error {
-	ob, ok := ex.orderbooks[market]
-	if !ok {
-		return fmt.Errorf("market not found")
+// openOrders returns every order resting or waiting for its trigger in one
+// of the books.
+func (ex *Exchange) openOrders() map[*orderbook.Order]bool {
+	open := make(map[*orderbook.Order]bool)
+	for market := range ex.engines {
+		ex.do(market, func(ob *orderbook.Orderbook) {
+			for _, orders := range []map[int64]*orderbook.Order{ob.Orders, ob.Stops} {
+				for _, order := range orders {
+					open[order] = true
+				}
+			}
+		})
 	}
+	return open
+}
+
+func (ex *Exchange) handlePlaceLimitOrder(market Market, price orderbook.Decimal, order *orderbook.Order) ([]orderbook.Match, orderState, error) {
 	// transfer from the user to the exchange.
 	// I don't think they really do this do to gas costs
 	// they likey just keep track of the balances
-	ob.PlaceLimitOrder(price, order)
+	var (
+		matches []orderbook.Match
+		state   orderState
+		err     error
+	)
+	if doErr := ex.do(market, func(ob *orderbook.Orderbook) {
+		matches, err = ob.PlaceLimitOrder(price, order)
+		if err != nil {
+			return
+		}
+		state = stateOf(order)
+		if !state.resting {
+			return
+		}
 
-	ex.mu.Lock()
-	// store the order in the exchange via the user id
-	ex.Orders[order.UserID] = append(ex.Orders[order.UserID], order)
-	ex.mu.Unlock()
+		ex.mu.Lock()
+		// store the order in the exchange via the user id
+		ex.Orders[order.UserID] = append(ex.Orders[order.UserID], order)
+		ex.mu.Unlock()
 
-	log.Printf("new LIMIT order => bid: {%v}  price: {%.2f}, size: {%.2f}", order.Bid, order.Limit.Price, order.Size)
-	return nil
+		log.Printf("new LIMIT order => bid: {%v}  price: {%s}, size: {%s}", order.Bid, order.Limit.Price, order.Size)
+	}); doErr != nil {
+		return nil, state, doErr
+	}
+	if err != nil {
+		return nil, state, err
+	}
+
+	if len(matches) > 0 {
+		sizeFilled := orderbook.Zero
+		for _, match := range matches {
+			if match.Bid == order || match.Ask == order {
+				sizeFilled = sizeFilled.Add(match.SizeFilled)
+			}
+		}
+		log.Printf("matched LIMIT order => id: {%d} bid: {%v} size filled: {%s} @ limit price: {%s}", order.ID, order.Bid, sizeFilled, price)
+	}
+
+	if len(matches) > 0 || len(state.selfTradeCancels) > 0 {
+		ex.removeClosedOrders()
+	}
+
+	return matches, state, nil
+}
+
+func (ex *Exchange) handlePlaceStopOrder(market Market, order *orderbook.Order) error {
+	var err error
+	if doErr := ex.do(market, func(ob *orderbook.Orderbook) {
+		if err = ob.PlaceStopOrder(order); err != nil {
+			return
+		}
+
+		ex.mu.Lock()
+		ex.Orders[order.UserID] = append(ex.Orders[order.UserID], order)
+		ex.mu.Unlock()
+
+		log.Printf("new STOP order => bid: {%v} stop price: {%s} limit price: {%s}, size: {%s}", order.Bid, order.StopPrice, order.StopLimitPrice, order.Size)
+	}); doErr != nil {
+		return doErr
+	}
+	return err
+}
+
+func (ex *Exchange) handlePlacePeggedOrder(market Market, order *orderbook.Order) (orderState, error) {
+	var (
+		state orderState
+		err   error
+	)
+	if doErr := ex.do(market, func(ob *orderbook.Orderbook) {
+		if err = ob.PlacePeggedOrder(order); err != nil {
+			return
+		}
+		state = stateOf(order)
+
+		ex.mu.Lock()
+		ex.Orders[order.UserID] = append(ex.Orders[order.UserID], order)
+		ex.mu.Unlock()
+
+		log.Printf("new PEGGED order => bid: {%v} peg: {%s} offset: {%s} price: {%s}, size: {%s}", order.Bid, order.Peg, order.PegOffset, order.Limit.Price, order.Size)
+	}); doErr != nil {
+		return state, doErr
+	}
+	return state, err
 }
 
 func (ex *Exchange) handlePlaceOrder(c echo.Context) error {
@@ -289,30 +580,157 @@ func (ex *Exchange) handlePlaceOrder(c echo.Context) error {
 	}
 
 	market := Market(placeOrderData.Market)
+	rules, ok := ex.markets[market]
+	if !ok {
+		return c.JSON(http.StatusBadRequest, ruleErrorf(CodeUnknownMarket, "market %q not found", market))
+	}
+	var lastTradePrice orderbook.Decimal
+	if err := ex.do(market, func(ob *orderbook.Orderbook) {
+		lastTradePrice = ob.LastTradePrice()
+	}); err != nil {
+		return err
+	}
+	if err := rules.validateOrder(&placeOrderData, lastTradePrice); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
 	order := orderbook.NewOrder(placeOrderData.Bid, placeOrderData.Size, placeOrderData.UserID)
+	if placeOrderData.TimeInForce != "" {
+		order.TimeInForce = placeOrderData.TimeInForce
+		order.ExpiresAt = placeOrderData.ExpiresAt
+	}
+	order.PostOnly = placeOrderData.PostOnly
+	order.PostOnlyMode = placeOrderData.PostOnlyMode
+	order.DisplaySize = placeOrderData.DisplaySize
+	order.SelfTradePrevention = placeOrderData.SelfTradePrevention
+	order.AllowPartialFill = placeOrderData.AllowPartialFill
+	order.WorstPrice = placeOrderData.WorstPrice
+	order.MaxSlippage = placeOrderData.MaxSlippage
+	order.QuoteSize = placeOrderData.QuoteSize
+	order.Hidden = placeOrderData.Hidden
+	order.MinQty = placeOrderData.MinQty
+	order.AllOrNone = placeOrderData.AllOrNone
+	if user, ok := ex.users[order.UserID]; ok && order.SelfTradePrevention == "" {
+		order.SelfTradePrevention = user.SelfTradePrevention
+	}
+
+	message := "order placed"
+	var filled, unfilled, avgPrice, quoteLeft *orderbook.Decimal
+	var state orderState
 
 	// Limit order
 	if placeOrderData.Type == LimitOrder {
-		if err := ex.handlePlaceLimitOrder(market, placeOrderData.Price, order); err != nil {
+		matches, placed, err := ex.handlePlaceLimitOrder(market, placeOrderData.Price, order)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
+		}
+		if err := ex.handleMatches(matches); err != nil {
 			return err
 		}
+		state = placed
+	}
+
+	// Stop market and stop limit orders
+	if placeOrderData.Type == StopMarketOrder || placeOrderData.Type == StopLimitOrder {
+		order.StopPrice = placeOrderData.StopPrice
+		if placeOrderData.Type == StopLimitOrder {
+			order.StopLimitPrice = placeOrderData.Price
+		}
+		if err := ex.handlePlaceStopOrder(market, order); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
+		}
+	}
+
+	// Pegged order
+	if placeOrderData.Type == PeggedOrder {
+		order.Peg = placeOrderData.Peg
+		order.PegOffset = placeOrderData.PegOffset
+		placed, err := ex.handlePlacePeggedOrder(market, order)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
+		}
+		state = placed
 	}
 
 	// Market order
 	if placeOrderData.Type == MarketOrder {
-		matches, _, err := ex.handlePlaceMarketOrder(market, order)
+		matches, matchedOrders, placed, err := ex.handlePlaceMarketOrder(market, order)
 		if err != nil {
-			return err
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
 		}
 		if err := ex.handleMatches(matches); err != nil {
 			return err
 		}
+		state = placed
+
+		sizeFilled, notional := orderbook.Zero, orderbook.Zero
+		for _, matched := range matchedOrders {
+			sizeFilled = sizeFilled.Add(matched.SizeFilled)
+			notional = notional.Add(matched.Price.Mul(matched.SizeFilled))
+		}
+		filled = &sizeFilled
+		if sizeFilled.IsPositive() {
+			avg := notional.Div(sizeFilled)
+			avgPrice = &avg
+		}
 
-		/// delete the order(s) of the user when they are filled
+		message = "order filled"
+		if placeOrderData.QuoteSize.IsPositive() {
+			left := state.quoteLeft
+			quoteLeft = &left
+			if sizeFilled.IsZero() {
+				message = "order not filled"
+			}
+		} else {
+			sizeUnfilled := placeOrderData.Size.Sub(sizeFilled)
+			unfilled = &sizeUnfilled
+			if sizeFilled.IsZero() {
+				message = "order not filled"
+			} else if sizeUnfilled.IsPositive() {
+				message = "order partially filled, unfilled size canceled"
+			}
+		}
 	}
+	if placeOrderData.Type == LimitOrder && !state.resting {
+		switch {
+		case state.filled:
+			message = "order filled"
+		case state.remaining.Equal(placeOrderData.Size):
+			message = "order not filled, canceled"
+		default:
+			message = "order partially filled, unfilled size canceled"
+		}
+	}
+
 	res := PlaceOrderResponse{
-		OrderID: order.ID,
-		Message: "order placed",
+		OrderID:   order.ID,
+		Message:   message,
+		Filled:    filled,
+		Unfilled:  unfilled,
+		AvgPrice:  avgPrice,
+		QuoteLeft: quoteLeft,
+	}
+
+	for _, canceled := range state.selfTradeCancels {
+		res.SelfTradeCanceled = append(res.SelfTradeCanceled, &CanceledOrder{
+			ID:           canceled.Order.ID,
+			Bid:          canceled.Order.Bid,
+			SizeCanceled: canceled.Size,
+		})
+	}
+	if state.selfTradeCanceled {
+		res.Message = "order canceled by self-trade prevention"
+	}
+
+	if order.PostOnly && state.resting && !state.restingPrice.Equal(placeOrderData.Price) {
+		restingPrice := state.restingPrice
+		res.Message = "post only order repriced"
+		res.Repriced = true
+		res.RestingPrice = &restingPrice
+	}
+	if order.Peg != "" && state.resting {
+		restingPrice := state.restingPrice
+		res.RestingPrice = &restingPrice
 	}
 
 	return c.JSON(http.StatusOK, res)
@@ -320,93 +738,224 @@ func (ex *Exchange) handlePlaceOrder(c echo.Context) error {
 
 func (ex *Exchange) handleGetBook(c echo.Context) error {
 	market := Market(c.Param("market"))
-	ob, ok := ex.orderbooks[market]
-	if !ok {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": "market not found"})
-	}
 
 	orderbookResponse := OrderbookResponse{
-		Market:         market,
-		Asks:           []*Order{},
-		Bids:           []*Order{},
-		TotalBidVolume: ob.BidTotalVolume(),
-		TotalAskVolume: ob.AskTotalVolume(),
-	}
-
-	for _, limit := range ob.Asks() {
-		for _, o := range limit.Orders {
-			order := Order{
-				UserID:    o.UserID,
-				ID:        o.ID,
-				Price:     limit.Price,
-				Size:      o.Size,
-				Bid:       o.Bid,
-				Timestamp: o.Timestamp,
+		Market: market,
+		Asks:   []*Order{},
+		Bids:   []*Order{},
+	}
+
+	err := ex.do(market, func(ob *orderbook.Orderbook) {
+		orderbookResponse.TotalBidVolume = ob.BidTotalVolume()
+		orderbookResponse.TotalAskVolume = ob.AskTotalVolume()
+		orderbookResponse.State = ob.State()
+
+		for _, limit := range ob.Asks() {
+			for _, o := range limit.Orders() {
+				if o.Hidden {
+					continue
+				}
+				order := Order{
+					UserID:    o.UserID,
+					ID:        o.ID,
+					Price:     limit.Price,
+					Size:      o.Size,
+					Bid:       o.Bid,
+					Timestamp: o.Timestamp,
+				}
+				orderbookResponse.Asks = append(orderbookResponse.Asks, &order)
 			}
-			orderbookResponse.Asks = append(orderbookResponse.Asks, &order)
 		}
-	}
 
-	for _, limit := range ob.Bids() {
-		for _, o := range limit.Orders {
-			order := Order{
-				UserID:    o.UserID,
-				ID:        o.ID,
-				Price:     limit.Price,
-				Size:      o.Size,
-				Bid:       o.Bid,
-				Timestamp: o.Timestamp,
+		for _, limit := range ob.Bids() {
+			for _, o := range limit.Orders() {
+				if o.Hidden {
+					continue
+				}
+				order := Order{
+					UserID:    o.UserID,
+					ID:        o.ID,
+					Price:     limit.Price,
+					Size:      o.Size,
+					Bid:       o.Bid,
+					Timestamp: o.Timestamp,
+				}
+				orderbookResponse.Bids = append(orderbookResponse.Bids, &order)
 			}
-			orderbookResponse.Bids = append(orderbookResponse.Bids, &order)
 		}
+	})
+	if errors.Is(err, errMarketNotFound) {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": "market not found"})
+	}
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, orderbookResponse)
 }
 
+// handleGetTrades returns the market's trades oldest first. The optional
+// from and to query params bound the trade timestamps in unix nanoseconds,
+// cursor is the nextCursor of the previous page and limit caps the page size.
+// Only the most recent trades are kept, so a cursor older than them pages on
+// from the oldest trade kept.
+func (ex *Exchange) handleGetTrades(c echo.Context) error {
+	market := Market(c.Param("market"))
+	if _, ok := ex.engines[market]; !ok {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": "market not found"})
+	}
+
+	query := orderbook.TradeQuery{Limit: defaultTradesLimit}
+	params := []struct {
+		name  string
+		value *int64
+	}{
+		{"from", &query.From},
+		{"to", &query.To},
+		{"cursor", &query.After},
+	}
+	for _, param := range params {
+		str := c.QueryParam(param.name)
+		if str == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": "invalid " + param.name})
+		}
+		*param.value = v
+	}
+	if limitStr := c.QueryParam("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": "invalid limit"})
+		}
+		query.Limit = limit
+	}
+	if query.Limit > maxTradesLimit {
+		query.Limit = maxTradesLimit
+	}
+
+	var trades []*orderbook.Trade
+	if err := ex.do(market, func(ob *orderbook.Orderbook) {
+		trades = ob.QueryTrades(query)
+	}); err != nil {
+		return err
+	}
+
+	tradesResponse := TradesResponse{
+		Market:     market,
+		Trades:     trades,
+		NextCursor: query.After,
+	}
+	if len(trades) > 0 {
+		tradesResponse.NextCursor = trades[len(trades)-1].ID
+	}
+
+	return c.JSON(http.StatusOK, tradesResponse)
+}
+
+// handleGetDepth returns the market's price levels with their total size
+// and order count. The optional levels query param caps the levels per side
+// and group buckets prices to multiples of it.
+func (ex *Exchange) handleGetDepth(c echo.Context) error {
+	market := Market(c.Param("market"))
+	if _, ok := ex.engines[market]; !ok {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": "market not found"})
+	}
+
+	levels := 0
+	if levelsStr := c.QueryParam("levels"); levelsStr != "" {
+		n, err := strconv.Atoi(levelsStr)
+		if err != nil || n < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": "invalid levels"})
+		}
+		levels = n
+	}
+
+	group := orderbook.Zero
+	if groupStr := c.QueryParam("group"); groupStr != "" {
+		g, err := orderbook.NewDecimalFromString(groupStr)
+		if err != nil || !g.IsPositive() {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": "invalid group"})
+		}
+		group = g
+	}
+
+	res := DepthResponse{Market: market}
+	if err := ex.do(market, func(ob *orderbook.Orderbook) {
+		depth := ob.Depth(levels, group)
+		res.Bids = depth.Bids
+		res.Asks = depth.Asks
+		res.State = ob.State()
+	}); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
 type PriceResponse struct {
-	Price float64 `json:"price"`
+	Price orderbook.Decimal `json:"price"`
 }
 
 func (ex *Exchange) handleGetBestBid(c echo.Context) error {
 	market := Market(c.Param("market"))
-	ob := ex.orderbooks[market]
 
-	if len(ob.Bids()) == 0 {
+	var bestBidPrice *orderbook.Decimal
+	if err := ex.do(market, func(ob *orderbook.Orderbook) {
+		if bestBid := ob.BestBid(); bestBid != nil {
+			price := bestBid.Price
+			bestBidPrice = &price
+		}
+	}); err != nil {
+		return err
+	}
+	if bestBidPrice == nil {
 		return fmt.Errorf("the bids are empty")
 	}
-	bestBidPrice := ob.Bids()[0].Price
 
 	pr := PriceResponse{
-		Price: bestBidPrice,
+		Price: *bestBidPrice,
 	}
 	return c.JSON(http.StatusOK, pr)
 }
 
 func (ex *Exchange) handleGetBestAsk(c echo.Context) error {
 	market := Market(c.Param("market"))
-	ob := ex.orderbooks[market]
 
-	if len(ob.Asks()) == 0 {
+	var bestAskPrice *orderbook.Decimal
+	if err := ex.do(market, func(ob *orderbook.Orderbook) {
+		if bestAsk := ob.BestAsk(); bestAsk != nil {
+			price := bestAsk.Price
+			bestAskPrice = &price
+		}
+	}); err != nil {
+		return err
+	}
+	if bestAskPrice == nil {
 		return fmt.Errorf("the asks are empty")
 	}
-	bestAskPrice := ob.Asks()[0].Price
 
 	pr := PriceResponse{
-		Price: bestAskPrice,
+		Price: *bestAskPrice,
 	}
 	return c.JSON(http.StatusOK, pr)
 }
 
 func (ex *Exchange) handleGetAllBids(c echo.Context) error {
 	market := Market(c.Param("market"))
-	ob := ex.orderbooks[market]
 
-	bids := make([]*PriceResponse, len(ob.Bids()))
-	for i, limit := range ob.Bids() {
-		bids[i] = &PriceResponse{
-			Price: limit.Price,
+	var bids []*PriceResponse
+	if err := ex.do(market, func(ob *orderbook.Orderbook) {
+		bids = []*PriceResponse{}
+		for _, limit := range ob.Bids() {
+			if limit.VisibleLen() > 0 {
+				bids = append(bids, &PriceResponse{Price: limit.Price})
+			}
 		}
+	}); err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, bids)
@@ -414,13 +963,17 @@ func (ex *Exchange) handleGetAllBids(c echo.Context) error {
 
 func (ex *Exchange) handleGetAllAsks(c echo.Context) error {
 	market := Market(c.Param("market"))
-	ob := ex.orderbooks[market]
 
-	asks := make([]*PriceResponse, len(ob.Asks()))
-	for i, limit := range ob.Asks() {
-		asks[i] = &PriceResponse{
-			Price: limit.Price,
+	var asks []*PriceResponse
+	if err := ex.do(market, func(ob *orderbook.Orderbook) {
+		asks = []*PriceResponse{}
+		for _, limit := range ob.Asks() {
+			if limit.VisibleLen() > 0 {
+				asks = append(asks, &PriceResponse{Price: limit.Price})
+			}
 		}
+	}); err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, asks)
@@ -433,15 +986,96 @@ func (ex *Exchange) handleCancelOrder(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": "invalid id"})
 	}
 
-	ob := ex.orderbooks[MarketETH]
-	order := ob.Orders[int64(id)]
-	ob.CancelOrder(order)
+	found, err := ex.engines[MarketETH].CancelOrder(int64(id))
+	if err != nil {
+		return err
+	}
+	if !found {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": "order not found"})
+	}
+	ex.removeClosedOrders()
 
 	log.Println("order deleted, id: ", idStr, "market: ", "ETH-USD")
 
 	return c.JSON(http.StatusOK, map[string]interface{}{"msg": "order deleted"})
 }
 
+func (ex *Exchange) handleAmendOrder(c echo.Context) error {
+	idStr := c.Param("id")
+	id, ok := strconv.Atoi(idStr)
+	if ok != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": "invalid id"})
+	}
+
+	var amendOrderData AmendOrderRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&amendOrderData); err != nil {
+		return err
+	}
+	if err := ex.markets[MarketETH].validateAmend(&amendOrderData); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	var (
+		matches  []orderbook.Match
+		notFound bool
+		err      error
+	)
+	if doErr := ex.do(MarketETH, func(ob *orderbook.Orderbook) {
+		order := ob.Order(int64(id))
+		if order == nil || order.Limit == nil {
+			notFound = true
+			return
+		}
+
+		price := amendOrderData.Price
+		if price.IsZero() {
+			price = order.Limit.Price
+		}
+		size := amendOrderData.Size
+		if size.IsZero() {
+			size = order.Size.Add(order.Reserve())
+		}
+
+		if amendOrderData.Price.IsZero() && !amendOrderData.Size.IsZero() {
+			if ruleErr := ex.markets[MarketETH].checkNotional(price, size); ruleErr != nil {
+				err = ruleErr
+				return
+			}
+		}
+
+		matches, err = ob.AmendOrder(order, price, size)
+		if err != nil {
+			return
+		}
+
+		log.Printf("amended LIMIT order => id: {%d} bid: {%v} price: {%s}, size: {%s}", order.ID, order.Bid, price, size)
+	}); doErr != nil {
+		return doErr
+	}
+	if notFound {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": "order not found"})
+	}
+	var ruleErr *RuleError
+	if errors.As(err, &ruleErr) {
+		return c.JSON(http.StatusBadRequest, ruleErr)
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{"msg": err.Error()})
+	}
+
+	if err := ex.handleMatches(matches); err != nil {
+		return err
+	}
+	ex.removeClosedOrders()
+
+	res := PlaceOrderResponse{
+		OrderID: int64(id),
+		Message: "order amended",
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
 func (ex *Exchange) handleMatches(matches []orderbook.Match) error {
 	for _, match := range matches {
 		fromUser, ok := ex.users[match.Ask.UserID]
@@ -463,3 +1097,22 @@ func (ex *Exchange) handleMatches(matches []orderbook.Match) error {
 	}
 	return nil
 }
+
+// handleGetMarket returns the trading rules and state of a market.
+func (ex *Exchange) handleGetMarket(c echo.Context) error {
+	market := Market(c.Param("market"))
+	rules, ok := ex.markets[market]
+	if !ok {
+		return c.JSON(http.StatusNotFound, ruleErrorf(CodeUnknownMarket, "market %q not found", market))
+	}
+
+	state, err := ex.engines[market].State()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, MarketResponse{
+		MarketRules: rules,
+		State:       state,
+	})
+}
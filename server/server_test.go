@@ -0,0 +1,491 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/natac13/go-crypto-exchange/orderbook"
+)
+
+func newTestExchange(t *testing.T) *Exchange {
+	ex, err := NewExchange(exchangePrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ex.Close)
+	return ex
+}
+
+func doRequest(t *testing.T, handler echo.HandlerFunc, method, target, body string, params map[string]string, res interface{}) int {
+	rec, err := serve(handler, method, target, body, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != nil {
+		if err := json.NewDecoder(rec.Body).Decode(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code
+}
+
+// serve runs handler on a request without failing the test, so it can be
+// used from other goroutines.
+func serve(handler echo.HandlerFunc, method, target, body string, params map[string]string) (*httptest.ResponseRecorder, error) {
+	e := echo.New()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	var names, values []string
+	for name, value := range params {
+		names = append(names, name)
+		values = append(values, value)
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+
+	return rec, handler(c)
+}
+
+func TestHandleGetTrades(t *testing.T) {
+	ex := newTestExchange(t)
+	ob := ex.orderbooks[MarketETH]
+
+	ob.PlaceLimitOrder(orderbook.RequireFromInt(10_000), orderbook.NewOrder(false, orderbook.RequireFromInt(5), 8))
+	for i := 0; i < 5; i++ {
+		if _, err := ob.PlaceMarketOrder(orderbook.NewOrder(true, orderbook.RequireFromInt(1), 9)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var page TradesResponse
+	code := doRequest(t, ex.handleGetTrades, http.MethodGet, "/trades/ETH?limit=3", "", map[string]string{"market": "ETH"}, &page)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if len(page.Trades) != 3 || page.NextCursor != 3 {
+		t.Fatalf("got %d trades and cursor %d, want 3 and 3", len(page.Trades), page.NextCursor)
+	}
+
+	doRequest(t, ex.handleGetTrades, http.MethodGet, "/trades/ETH?limit=3&cursor=3", "", map[string]string{"market": "ETH"}, &page)
+	if len(page.Trades) != 2 || page.Trades[0].ID != 4 || page.NextCursor != 5 {
+		t.Fatalf("got %d trades starting at %d and cursor %d, want 2 from 4 and 5", len(page.Trades), page.Trades[0].ID, page.NextCursor)
+	}
+
+	code = doRequest(t, ex.handleGetTrades, http.MethodGet, "/trades/ETH?from=abc", "", map[string]string{"market": "ETH"}, nil)
+	if code != http.StatusBadRequest {
+		t.Fatalf("got status %d for an invalid from, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestHandlePlaceMarketOrderWithoutLiquidity(t *testing.T) {
+	ex := newTestExchange(t)
+
+	var msg map[string]interface{}
+	body := `{"userId": 9, "market": "ETH", "type": "MARKET", "bid": true, "size": 2}`
+	code := doRequest(t, ex.handlePlaceOrder, http.MethodPost, "/order", body, nil, &msg)
+	if code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	var res PlaceOrderResponse
+	body = `{"userId": 9, "market": "ETH", "type": "MARKET", "bid": true, "size": 2, "allowPartialFill": true}`
+	code = doRequest(t, ex.handlePlaceOrder, http.MethodPost, "/order", body, nil, &res)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", code, http.StatusOK)
+	}
+	if res.Filled == nil || !res.Filled.IsZero() || res.Unfilled == nil || res.Unfilled.String() != "2" {
+		t.Fatalf("got filled %v and unfilled %v, want 0 and 2", res.Filled, res.Unfilled)
+	}
+}
+
+func TestHandlePlaceQuoteMarketOrderWithoutLiquidity(t *testing.T) {
+	ex := newTestExchange(t)
+
+	var res PlaceOrderResponse
+	body := `{"userId": 9, "market": "ETH", "type": "MARKET", "bid": true, "quoteSize": 500, "allowPartialFill": true}`
+	code := doRequest(t, ex.handlePlaceOrder, http.MethodPost, "/order", body, nil, &res)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", code, http.StatusOK)
+	}
+	if res.Filled == nil || !res.Filled.IsZero() || res.QuoteLeft == nil || res.QuoteLeft.String() != "500" {
+		t.Fatalf("got filled %v and quote left %v, want 0 and 500", res.Filled, res.QuoteLeft)
+	}
+	if res.Unfilled != nil || res.AvgPrice != nil {
+		t.Fatalf("got unfilled %v and average price %v, want neither", res.Unfilled, res.AvgPrice)
+	}
+}
+
+func TestHandlePlaceImmediateOrCancelWithoutLiquidity(t *testing.T) {
+	ex := newTestExchange(t)
+
+	var res PlaceOrderResponse
+	body := `{"userId": 9, "market": "ETH", "type": "LIMIT", "bid": true, "price": 10000, "size": 1, "timeInForce": "IOC"}`
+	code := doRequest(t, ex.handlePlaceOrder, http.MethodPost, "/order", body, nil, &res)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", code, http.StatusOK)
+	}
+	if res.Message != "order not filled, canceled" {
+		t.Fatalf("got message %q, want %q", res.Message, "order not filled, canceled")
+	}
+}
+
+func TestHandleGetBookHiddenAndPegged(t *testing.T) {
+	ex := newTestExchange(t)
+	market := map[string]string{"market": "ETH"}
+
+	for _, body := range []string{
+		`{"userId": 8, "market": "ETH", "type": "LIMIT", "bid": true, "price": 9900, "size": 1}`,
+		`{"userId": 8, "market": "ETH", "type": "LIMIT", "bid": false, "price": 10100, "size": 1}`,
+		`{"userId": 9, "market": "ETH", "type": "LIMIT", "bid": false, "price": 10050, "size": 2, "hidden": true}`,
+	} {
+		if code := doRequest(t, ex.handlePlaceOrder, http.MethodPost, "/order", body, nil, nil); code != http.StatusOK {
+			t.Fatalf("%s: got status %d", body, code)
+		}
+	}
+
+	var res PlaceOrderResponse
+	body := `{"userId": 9, "market": "ETH", "type": "PEGGED", "bid": true, "size": 1, "peg": "MID", "pegOffset": -0.5, "hidden": true}`
+	if code := doRequest(t, ex.handlePlaceOrder, http.MethodPost, "/order", body, nil, &res); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if res.RestingPrice == nil || res.RestingPrice.String() != "9999.5" {
+		t.Fatalf("got resting price %v, want 9999.5", res.RestingPrice)
+	}
+
+	var book OrderbookResponse
+	doRequest(t, ex.handleGetBook, http.MethodGet, "/book/ETH", "", market, &book)
+	if len(book.Asks) != 1 || len(book.Bids) != 1 {
+		t.Fatalf("got %d asks and %d bids, want the 1 visible order on each side", len(book.Asks), len(book.Bids))
+	}
+	if book.TotalAskVolume.String() != "1" || book.TotalBidVolume.String() != "1" {
+		t.Fatalf("got total volumes %s and %s, want 1 and 1", book.TotalAskVolume, book.TotalBidVolume)
+	}
+
+	var asks []*PriceResponse
+	doRequest(t, ex.handleGetAllAsks, http.MethodGet, "/book/ETH/asks", "", market, &asks)
+	if len(asks) != 1 || asks[0].Price.String() != "10100" {
+		t.Fatalf("got asks %v, want only 10100", asks)
+	}
+
+	var best PriceResponse
+	doRequest(t, ex.handleGetBestAsk, http.MethodGet, "/book/ETH/ask", "", market, &best)
+	if best.Price.String() != "10100" {
+		t.Fatalf("got best ask %s, want 10100", best.Price)
+	}
+}
+
+func TestHandlePlaceOrderMarketRules(t *testing.T) {
+	ex := newTestExchange(t)
+
+	tests := []struct {
+		body string
+		code RuleCode
+	}{
+		{`{"market": "BTC", "type": "LIMIT", "price": 10000, "size": 1}`, CodeUnknownMarket},
+		{`{"market": "ETH", "type": "ICEBERG", "price": 10000, "size": 1}`, CodeInvalidOrderType},
+		{`{"market": "ETH", "type": "LIMIT", "price": 0, "size": 1}`, CodeInvalidPrice},
+		{`{"market": "ETH", "type": "LIMIT", "price": 10000.005, "size": 1}`, CodePriceTick},
+		{`{"market": "ETH", "type": "LIMIT", "price": 10000, "size": -1}`, CodeInvalidSize},
+		{`{"market": "ETH", "type": "LIMIT", "price": 10000, "size": 0.0001}`, CodeSizeLot},
+		{`{"market": "ETH", "type": "LIMIT", "price": 10000, "size": 1001}`, CodeSizeTooLarge},
+		{`{"market": "ETH", "type": "LIMIT", "price": 5, "size": 1}`, CodeNotionalTooSmall},
+		{`{"market": "ETH", "type": "LIMIT", "price": "90000000000", "size": 1000}`, CodeNotionalTooLarge},
+		{`{"market": "ETH", "type": "STOP_LIMIT", "stopPrice": 10000, "price": 10000.001, "size": 1}`, CodePriceTick},
+		{`{"market": "ETH", "type": "LIMIT", "price": 10000, "size": 1, "displaySize": 0.0005}`, CodeSizeLot},
+		{`{"market": "ETH", "type": "LIMIT", "price": 10000, "size": 1, "minQty": -1}`, CodeInvalidSize},
+		{`{"market": "ETH", "type": "LIMIT", "price": 10000, "size": 1, "minQty": 0.0005}`, CodeSizeLot},
+		{`{"market": "ETH", "type": "LIMIT", "price": 10000, "quoteSize": 500}`, CodeInvalidQuoteSize},
+		{`{"market": "ETH", "type": "MARKET", "size": 1, "quoteSize": 500}`, CodeInvalidQuoteSize},
+		{`{"market": "ETH", "type": "MARKET", "quoteSize": -500}`, CodeInvalidQuoteSize},
+		{`{"market": "ETH", "type": "MARKET", "quoteSize": 5}`, CodeNotionalTooSmall},
+		{`{"market": "ETH", "type": "MARKET", "size": 1, "hidden": true}`, CodeInvalidOrderType},
+		{`{"market": "ETH", "type": "MARKET", "bid": true, "size": 1, "maxSlippage": "10000000000"}`, CodeInvalidSlippage},
+		{`{"market": "ETH", "type": "MARKET", "bid": true, "size": 1, "maxSlippage": -0.01}`, CodeInvalidSlippage},
+		{`{"market": "ETH", "type": "MARKET", "bid": true, "quoteSize": 500, "maxSlippage": 2}`, CodeInvalidSlippage},
+		{`{"market": "ETH", "type": "MARKET", "bid": true, "size": 1, "worstPrice": -1}`, CodeInvalidPrice},
+		{`{"market": "ETH", "type": "MARKET", "bid": true, "size": 1, "worstPrice": 10000.001}`, CodePriceTick},
+		{`{"market": "ETH", "type": "LIMIT", "price": 10000, "size": 1, "peg": "MID"}`, CodeInvalidPeg},
+		{`{"market": "ETH", "type": "PEGGED", "size": 1, "peg": "LAST"}`, CodeInvalidPeg},
+		{`{"market": "ETH", "type": "PEGGED", "size": 1, "peg": "MID", "pegOffset": 0.005}`, CodePriceTick},
+		{`{"market": "ETH", "type": "LIMIT", "price": 10000, "size": 1, "selfTradePrevention": "cn"}`, CodeInvalidSTP},
+		{`{"market": "ETH", "type": "LIMIT", "price": 10000.00001, "size": 1}`, CodePrecision},
+		{`{"market": "ETH", "type": "LIMIT", "price": 10000, "size": 1.0000001}`, CodePrecision},
+		{`{"market": "ETH", "type": "MARKET", "quoteSize": 500.00001}`, CodePrecision},
+	}
+
+	for _, test := range tests {
+		var res RuleError
+		code := doRequest(t, ex.handlePlaceOrder, http.MethodPost, "/order", test.body, nil, &res)
+		if code != http.StatusBadRequest || res.Code != test.code {
+			t.Errorf("%s: got %d %s, want %d %s", test.body, code, res.Code, http.StatusBadRequest, test.code)
+		}
+	}
+
+	if len(ex.orderbooks[MarketETH].Orders) != 0 {
+		t.Fatal("rejected orders reached the orderbook")
+	}
+}
+
+func TestHandleAmendOrderNotionalTooLarge(t *testing.T) {
+	ex := newTestExchange(t)
+	sellOrder := orderbook.NewOrder(false, orderbook.RequireFromInt(1), 8)
+	ex.orderbooks[MarketETH].PlaceLimitOrder(orderbook.RequireFromInt(10_000), sellOrder)
+	id := strconv.FormatInt(sellOrder.ID, 10)
+	params := map[string]string{"id": id}
+
+	// the notional does not fit a Decimal, this used to panic on the engine
+	var res RuleError
+	code := doRequest(t, ex.handleAmendOrder, http.MethodPatch, "/order/"+id, `{"price": "90000000000", "size": "1000"}`, params, &res)
+	if code != http.StatusBadRequest || res.Code != CodeNotionalTooLarge {
+		t.Fatalf("got %d %s, want %d %s", code, res.Code, http.StatusBadRequest, CodeNotionalTooLarge)
+	}
+
+	// the order is untouched and the engine still takes commands
+	code = doRequest(t, ex.handleAmendOrder, http.MethodPatch, "/order/"+id, `{"size": "2"}`, params, nil)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", code, http.StatusOK)
+	}
+	ex.do(MarketETH, func(ob *orderbook.Orderbook) {
+		if order := ob.Order(sellOrder.ID); order == nil || order.Limit.Price.String() != "10000" || order.Size.String() != "2" {
+			t.Errorf("got order %+v, want 2 at 10000", order)
+		}
+	})
+}
+
+func TestHandleGetMarket(t *testing.T) {
+	ex := newTestExchange(t)
+
+	var res MarketResponse
+	code := doRequest(t, ex.handleGetMarket, http.MethodGet, "/markets/ETH", "", map[string]string{"market": "ETH"}, &res)
+	if code != http.StatusOK || res.MarketRules == nil || *res.MarketRules != *defaultMarketRules[MarketETH] {
+		t.Fatalf("got %d %+v", code, res.MarketRules)
+	}
+	if res.State.Status != orderbook.StatusTrading {
+		t.Fatalf("got status %s, want %s", res.State.Status, orderbook.StatusTrading)
+	}
+
+	code = doRequest(t, ex.handleGetMarket, http.MethodGet, "/markets/BTC", "", map[string]string{"market": "BTC"}, nil)
+	if code != http.StatusNotFound {
+		t.Fatalf("got status %d for an unknown market, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestHandleGetDepth(t *testing.T) {
+	ex := newTestExchange(t)
+	ob := ex.orderbooks[MarketETH]
+
+	ob.PlaceLimitOrder(orderbook.RequireFromInt(10_001), orderbook.NewOrder(false, orderbook.RequireFromInt(1), 8))
+	ob.PlaceLimitOrder(orderbook.RequireFromInt(10_004), orderbook.NewOrder(false, orderbook.RequireFromInt(2), 8))
+	ob.PlaceLimitOrder(orderbook.RequireFromInt(10_020), orderbook.NewOrder(false, orderbook.RequireFromInt(3), 8))
+
+	var depth DepthResponse
+	code := doRequest(t, ex.handleGetDepth, http.MethodGet, "/book/ETH/depth?levels=1&group=10", "", map[string]string{"market": "ETH"}, &depth)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if len(depth.Asks) != 1 || depth.Asks[0].Price.String() != "10010" || depth.Asks[0].Size.String() != "3" || depth.Asks[0].Orders != 2 {
+		t.Fatalf("got asks %+v, want one level of 3 in 2 orders at 10010", depth.Asks)
+	}
+	if len(depth.Bids) != 0 {
+		t.Fatalf("got bids %+v, want none", depth.Bids)
+	}
+
+	for _, query := range []string{"levels=-1", "levels=x", "group=0", "group=x"} {
+		code := doRequest(t, ex.handleGetDepth, http.MethodGet, "/book/ETH/depth?"+query, "", map[string]string{"market": "ETH"}, nil)
+		if code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", query, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	ex := newTestExchange(t)
+	ob := ex.orderbooks[MarketETH]
+
+	sellOrder := orderbook.NewOrder(false, orderbook.RequireFromInt(3), 8)
+	buyOrder := orderbook.NewOrder(true, orderbook.RequireFromInt(2), 9)
+	ob.PlaceLimitOrder(orderbook.RequireFromInt(10_000), sellOrder)
+	ob.PlaceLimitOrder(orderbook.RequireFromInt(9_000), buyOrder)
+
+	markets, err := ex.writeSnapshots(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(markets) != 1 || markets[0] != MarketETH {
+		t.Fatalf("got markets %v, want [%s]", markets, MarketETH)
+	}
+
+	restored := newTestExchange(t)
+	if err := restored.loadSnapshots(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	restoredOrders := restored.Orders[8]
+	if len(restoredOrders) != 1 || restoredOrders[0].ID != sellOrder.ID || restoredOrders[0].Size.String() != "3" {
+		t.Fatalf("got orders %v for user 8, want the sell order", restoredOrders)
+	}
+	if len(restored.Orders[9]) != 1 {
+		t.Fatalf("got %d orders for user 9, want 1", len(restored.Orders[9]))
+	}
+
+	// new orders carry on the order IDs
+	order := orderbook.NewOrder(true, orderbook.RequireFromInt(1), 9)
+	restored.orderbooks[MarketETH].PlaceLimitOrder(orderbook.RequireFromInt(9_000), order)
+	if order.ID != buyOrder.ID+1 {
+		t.Fatalf("got order id %d, want %d", order.ID, buyOrder.ID+1)
+	}
+}
+
+func TestIDsNotReusedAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	ex := newTestExchange(t)
+	if err := ex.reserveIDs(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	ob := ex.orderbooks[MarketETH]
+	ob.PlaceLimitOrder(orderbook.RequireFromInt(10_000), orderbook.NewOrder(false, orderbook.RequireFromInt(3), 8))
+	ob.PlaceMarketOrder(orderbook.NewOrder(true, orderbook.RequireFromInt(1), 9))
+	lastOrder, lastTrade := ex.orderIDs.Last(), ex.tradeIDs[MarketETH].Last()
+
+	// restart without a snapshot
+	restarted := newTestExchange(t)
+	if err := restarted.loadSnapshots(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.reserveIDs(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	ob = restarted.orderbooks[MarketETH]
+	sellOrder := orderbook.NewOrder(false, orderbook.RequireFromInt(3), 8)
+	ob.PlaceLimitOrder(orderbook.RequireFromInt(10_000), sellOrder)
+	ob.PlaceMarketOrder(orderbook.NewOrder(true, orderbook.RequireFromInt(1), 9))
+	if sellOrder.ID <= lastOrder {
+		t.Fatalf("got order id %d, want above %d", sellOrder.ID, lastOrder)
+	}
+	if id := ob.Trades[0].ID; id <= lastTrade {
+		t.Fatalf("got trade id %d, want above %d", id, lastTrade)
+	}
+}
+
+// TestConcurrentRequests drives every handler that reads or changes the book
+// from many goroutines at once. Run it with -race.
+func TestConcurrentRequests(t *testing.T) {
+	ex := newTestExchange(t)
+	market := map[string]string{"market": "ETH"}
+
+	const (
+		workers = 8
+		ops     = 200
+	)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			r := rand.New(rand.NewSource(int64(w)))
+			userID := int64(1 + w%2)
+			bid := userID == 1
+			var placed []int64
+
+			for i := 0; i < ops; i++ {
+				var (
+					rec *httptest.ResponseRecorder
+					err error
+				)
+				switch r.Intn(8) {
+				case 0, 1:
+					// bids stay below 100 and asks above, so nothing trades
+					price := 90 + r.Intn(10)
+					if !bid {
+						price += 11
+					}
+					body := fmt.Sprintf(`{"userId": %d, "market": "ETH", "type": "LIMIT", "bid": %v, "price": %d, "size": %d}`, userID, bid, price, 1+r.Intn(5))
+					rec, err = serve(ex.handlePlaceOrder, http.MethodPost, "/order", body, nil)
+					if err == nil && rec.Code == http.StatusOK {
+						var res PlaceOrderResponse
+						json.NewDecoder(rec.Body).Decode(&res)
+						placed = append(placed, res.OrderID)
+					}
+				case 2:
+					if len(placed) == 0 {
+						continue
+					}
+					id := strconv.FormatInt(placed[r.Intn(len(placed))], 10)
+					rec, err = serve(ex.handleCancelOrder, http.MethodDelete, "/order/"+id, "", map[string]string{"id": id})
+				case 3:
+					if len(placed) == 0 {
+						continue
+					}
+					id := strconv.FormatInt(placed[r.Intn(len(placed))], 10)
+					rec, err = serve(ex.handleAmendOrder, http.MethodPatch, "/order/"+id, `{"size": 1}`, map[string]string{"id": id})
+				case 4:
+					rec, err = serve(ex.handleGetBook, http.MethodGet, "/book/ETH", "", market)
+				case 5:
+					rec, err = serve(ex.handleGetDepth, http.MethodGet, "/book/ETH/depth?levels=5", "", market)
+				case 6:
+					id := strconv.FormatInt(userID, 10)
+					rec, err = serve(ex.handleGetUserOrders, http.MethodGet, "/orders/"+id, "", map[string]string{"userId": id})
+				case 7:
+					rec, err = serve(ex.handleGetAllBids, http.MethodGet, "/book/ETH/bids", "", market)
+				}
+				if err != nil {
+					t.Errorf("request failed: %v", err)
+				} else if rec.Code != http.StatusOK && rec.Code != http.StatusBadRequest {
+					t.Errorf("got status %d: %s", rec.Code, rec.Body)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	var book OrderbookResponse
+	doRequest(t, ex.handleGetBook, http.MethodGet, "/book/ETH", "", market, &book)
+
+	var userOrders UserOrdersResponse
+	doRequest(t, ex.handleGetUserOrders, http.MethodGet, "/orders/1", "", map[string]string{"userId": "1"}, &userOrders)
+	if len(userOrders.Bids) != len(book.Bids) {
+		t.Fatalf("user 1 has %d open bids, the book %d", len(userOrders.Bids), len(book.Bids))
+	}
+}
+
+func TestAdminTokenRequired(t *testing.T) {
+	handler := requireAdminToken("secret")(func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	for _, test := range []struct {
+		header string
+		code   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"secret", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusNoContent},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/admin/snapshot", nil)
+		if test.header != "" {
+			req.Header.Set(echo.HeaderAuthorization, test.header)
+		}
+		rec := httptest.NewRecorder()
+		if err := handler(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != test.code {
+			t.Errorf("%q: got %d, want %d", test.header, rec.Code, test.code)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/natac13/go-crypto-exchange/orderbook"
+)
+
+const snapshotDir = "snapshots"
+
+type SnapshotResponse struct {
+	Markets []Market `json:"markets"`
+	Message string   `json:"message"`
+}
+
+func snapshotPath(dir string, market Market) string {
+	return filepath.Join(dir, fmt.Sprintf("%s.snapshot", market))
+}
+
+// writeSnapshots writes a snapshot of every orderbook to dir. Each one goes
+// to a temporary file first, so a crash never leaves half a snapshot behind.
+func (ex *Exchange) writeSnapshots(dir string) ([]Market, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	markets := []Market{}
+	for market := range ex.engines {
+		// the snapshot is taken on the engine, the file is written after
+		// so matching does not wait on the disk
+		var buf bytes.Buffer
+		var err error
+		if doErr := ex.do(market, func(ob *orderbook.Orderbook) {
+			err = ob.WriteSnapshot(&buf)
+		}); doErr != nil {
+			return nil, doErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("snapshot of market %s: %w", market, err)
+		}
+
+		if err := writeFile(snapshotPath(dir, market), buf.Bytes()); err != nil {
+			return nil, fmt.Errorf("snapshot of market %s: %w", market, err)
+		}
+
+		markets = append(markets, market)
+	}
+
+	sort.Slice(markets, func(i, j int) bool { return markets[i] < markets[j] })
+	return markets, nil
+}
+
+// writeFile writes data to path through a temporary file in the same
+// directory, so a crash never leaves half a file behind.
+func writeFile(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// loadSnapshots restores every orderbook that has a snapshot in dir and
+// rebuilds the per user order lists from them. Markets without a snapshot
+// start empty.
+func (ex *Exchange) loadSnapshots(dir string) error {
+	orders := make(map[int64][]*orderbook.Order)
+
+	for market := range ex.engines {
+		f, err := os.Open(snapshotPath(dir, market))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if doErr := ex.do(market, func(ob *orderbook.Orderbook) {
+			if err = ob.RestoreSnapshot(f); err != nil {
+				return
+			}
+
+			for _, restored := range []map[int64]*orderbook.Order{ob.Orders, ob.Stops} {
+				for _, order := range restored {
+					orders[order.UserID] = append(orders[order.UserID], order)
+				}
+			}
+			log.Printf("restored snapshot => market: {%s} orders: {%d} stops: {%d} trades: {%d}", market, len(ob.Orders), len(ob.Stops), len(ob.Trades))
+		}); doErr != nil {
+			err = doErr
+		}
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("snapshot of market %s: %w", market, err)
+		}
+	}
+
+	for _, userOrders := range orders {
+		sort.Slice(userOrders, func(i, j int) bool { return userOrders[i].ID < userOrders[j].ID })
+	}
+
+	ex.mu.Lock()
+	ex.Orders = orders
+	ex.mu.Unlock()
+
+	return nil
+}
+
+// idBlock is how many IDs a sequencer reserves at a time. Each block costs a
+// file write, and a restart skips what is left of the last one.
+const idBlock = 10_000
+
+func idMarkPath(dir, name string) string {
+	return filepath.Join(dir, name+".ids")
+}
+
+// reserveIDs makes the order and trade sequencers reserve their IDs in
+// blocks, recording the end of each block in dir. It first moves each
+// sequencer past the mark recorded before, so IDs issued since the last
+// snapshot are never issued again after a restart. Call it after
+// loadSnapshots and before taking orders.
+func (ex *Exchange) reserveIDs(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	sequencers := map[string]*orderbook.Sequencer{"orders": ex.orderIDs}
+	for market, s := range ex.tradeIDs {
+		sequencers[string(market)+".trades"] = s
+	}
+
+	for name, s := range sequencers {
+		path := idMarkPath(dir, name)
+		data, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return err
+		default:
+			mark, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+			if err != nil {
+				return fmt.Errorf("id mark %s: %w", path, err)
+			}
+			s.Advance(mark)
+		}
+
+		err = s.Reserve(idBlock, func(mark int64) error {
+			return writeFile(path, []byte(strconv.FormatInt(mark, 10)+"\n"))
+		})
+		if err != nil {
+			return fmt.Errorf("id mark %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// handleSnapshot writes a snapshot of every market, they are loaded again
+// when the server starts.
+func (ex *Exchange) handleSnapshot(c echo.Context) error {
+	markets, err := ex.writeSnapshots(snapshotDir)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"msg": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, SnapshotResponse{
+		Markets: markets,
+		Message: "snapshot written",
+	})
+}
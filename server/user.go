@@ -5,12 +5,16 @@ import (
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/natac13/go-crypto-exchange/orderbook"
 )
 
 type User struct {
 	ID         int64
 	PrivateKey *ecdsa.PrivateKey
 	PublicKey  *ecdsa.PublicKey
+	// SelfTradePrevention is used for the user's orders that do not set
+	// their own mode
+	SelfTradePrevention orderbook.SelfTradePrevention
 }
 
 func NewUser(privateKey string, userId int64) (*User, error) {
@@ -11,6 +11,7 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/miguelmota/go-ethutil"
+	"github.com/natac13/go-crypto-exchange/orderbook"
 )
 
 func transferETH(client *ethclient.Client, fromPrivKey *ecdsa.PrivateKey, to common.Address, amount *big.Int) error {
@@ -48,8 +49,10 @@ func transferETH(client *ethclient.Client, fromPrivKey *ecdsa.PrivateKey, to com
 	return client.SendTransaction(ctx, signedTx)
 }
 
-func orderSizeToWei(size float64) *big.Int {
-	return ethutil.ToWei(size, 18)
+// orderSizeToWei converts an ETH order size to wei. The conversion is exact
+// since a Decimal never holds more than 18 decimal places.
+func orderSizeToWei(size orderbook.Decimal) *big.Int {
+	return size.BigInt(18)
 }
 
 func weiToEth(wei *big.Int) float64 {
@@ -4,6 +4,8 @@ package server
 import (
 	"math/big"
 	"testing"
+
+	"github.com/natac13/go-crypto-exchange/orderbook"
 )
 
 func TestWeiToEth(t *testing.T) {
@@ -39,4 +41,37 @@ func TestWeiToEth(t *testing.T) {
 	}
 }
 
+func TestOrderSizeToWei(t *testing.T) {
+	testCases := []struct {
+		name string
+		size orderbook.Decimal
+		want string
+	}{
+		{
+			name: "0.2 ether",
+			size: orderbook.RequireFromString("0.2"),
+			want: "200000000000000000",
+		},
+		{
+			name: "10 ether",
+			size: orderbook.RequireFromInt(10),
+			want: "10000000000000000000",
+		},
+		{
+			name: "smallest size",
+			size: orderbook.NewDecimalFromUnits(1),
+			want: "10000000000",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := orderSizeToWei(tc.size)
+			if got.String() != tc.want {
+				t.Errorf("orderSizeToWei(%v) = %v; want %v", tc.size, got, tc.want)
+			}
+		})
+	}
+}
+
 // END: x9c3f8d4b3e6